@@ -0,0 +1,94 @@
+package channelstats
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Trailer which holds the version to use for the next incremental backup
+const BackupVersionHeader = "X-Backup-Version"
+
+// Only allow requests which provide the configured admin token
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.conf.Admin.Token == "" {
+			abort(w, errors.New("admin endpoints are disabled; admin.token is not set"), http.StatusForbidden)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.conf.Admin.Token)) != 1 {
+			abort(w, errors.New("invalid or missing admin token"), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stream a backup of the store to the client. If 'since' is provided only
+// data newer than that version is included. The version to use for the next
+// incremental backup is returned in the 'X-Backup-Version' trailer.
+func (s *Server) backup(w http.ResponseWriter, r *http.Request) {
+	if err := isValidParams(r, []string{"since"}, []string{}); err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	backuper, ok := s.store.(Backuper)
+	if !ok {
+		abort(w, errors.New("configured store does not support backups"), http.StatusNotImplemented)
+		return
+	}
+
+	var since uint64
+	if value := r.FormValue("since"); value != "" {
+		var err error
+		since, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			abort(w, errors.Wrap(err, "'since' must be a backup version number"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Trailer", BackupVersionHeader)
+
+	version, err := backuper.Backup(w, since)
+	if err != nil {
+		// Headers are already sent, the missing trailer tells the client the backup is incomplete
+		s.log.Errorf("while streaming backup: %s", err)
+		return
+	}
+	w.Header().Set(BackupVersionHeader, strconv.FormatUint(version, 10))
+}
+
+type ReportSendResp struct {
+	Channel      string   `json:"channel"`
+	StartHour    string   `json:"start-hour"`
+	EndHour      string   `json:"end-hour"`
+	Destinations []string `json:"destinations"`
+}
+
+// Generate and send the report for a channel now instead of waiting for the report schedule
+func (s *Server) sendReport(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.generateReport(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.reporter.Send(report); err != nil {
+		abort(w, err, http.StatusBadGateway)
+		return
+	}
+
+	toJSON(w, ReportSendResp{
+		Channel:      report.Channel.Name,
+		StartHour:    report.TimeRange.StartDate(),
+		EndHour:      report.TimeRange.EndDate(),
+		Destinations: s.conf.Report.ChannelRule(report.Channel.Name).Destinations,
+	})
+}
@@ -0,0 +1,140 @@
+package channelstats_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestAdmin(t *testing.T) {
+	suite.Run(t, new(AdminSuite))
+}
+
+type AdminSuite struct {
+	suite.Suite
+	dir    string
+	conf   channelstats.Config
+	idMgr  *channelstats.MockIDManage
+	store  channelstats.Storer
+	server *channelstats.Server
+	http   *httptest.Server
+}
+
+func (s *AdminSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *AdminSuite) SetupTest() {
+	var err error
+	s.dir, err = ioutil.TempDir("", "channel-stats-test")
+	s.Require().NoError(err)
+
+	s.conf.Store.Backend = "badger"
+	s.conf.Store.DataDir = s.dir
+	s.conf.Admin.Token = "s3cret"
+	s.idMgr = &channelstats.MockIDManage{UserByID: map[string]string{"U1": "joe"}}
+
+	s.store, err = channelstats.NewStore(s.conf, s.idMgr)
+	s.Require().NoError(err)
+	s.server = channelstats.NewServer(s.conf, s.store, s.idMgr, nil, nil, nil, nil)
+	s.http = httptest.NewServer(s.server)
+}
+
+func (s *AdminSuite) TearDownTest() {
+	s.http.Close()
+	s.server.Stop()
+	s.store.Close()
+	os.RemoveAll(s.dir)
+}
+
+func (s *AdminSuite) get(path, token string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, s.http.URL+path, nil)
+	s.Require().NoError(err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	return resp
+}
+
+func (s *AdminSuite) TestAuth() {
+	resp := s.get("/admin/backup", "")
+	resp.Body.Close()
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	resp = s.get("/admin/backup", "wrong")
+	resp.Body.Close()
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	resp = s.get("/admin/backup", "s3cret")
+	resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func (s *AdminSuite) TestDisabledWithoutToken() {
+	conf := s.conf
+	conf.Admin.Token = ""
+	server := channelstats.NewServer(conf, s.store, s.idMgr, nil, nil, nil, nil)
+	defer server.Stop()
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/backup", nil))
+	s.Equal(http.StatusForbidden, w.Code)
+}
+
+func (s *AdminSuite) TestBackupAndRestore() {
+	for _, ts := range []string{"1544659200.000100", "1544659200.000200"} {
+		s.Require().NoError(s.store.HandleMessage(&slack.MessageEvent{
+			Msg: slack.Msg{Timestamp: ts, Channel: "C1", User: "U1", Text: "hello"},
+		}))
+	}
+
+	resp := s.get("/admin/backup", "s3cret")
+	defer resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	backup, err := ioutil.ReadAll(resp.Body)
+	s.Require().NoError(err)
+
+	// The trailer holds the version to use for the next incremental backup
+	version, err := strconv.ParseUint(resp.Trailer.Get(channelstats.BackupVersionHeader), 10, 64)
+	s.Require().NoError(err)
+	s.NotZero(version)
+
+	dir, err := ioutil.TempDir("", "channel-stats-test")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	conf := s.conf
+	conf.Store.DataDir = dir
+	restored, err := channelstats.NewStore(conf, s.idMgr)
+	s.Require().NoError(err)
+	defer restored.Close()
+
+	backuper, ok := restored.(channelstats.Backuper)
+	s.Require().True(ok)
+	s.Require().NoError(backuper.Restore(bytes.NewReader(backup)))
+
+	timeRange, err := channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T00")
+	s.Require().NoError(err)
+	sums, err := restored.SumByUser(context.Background(), timeRange, "C1", "messages")
+	s.Require().NoError(err)
+	s.Equal([]channelstats.SumResp{{User: "joe", Sum: 2}}, sums)
+
+	// Nothing was written since the last backup
+	resp = s.get("/admin/backup?since="+strconv.FormatUint(version, 10), "s3cret")
+	defer resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	incremental, err := ioutil.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	s.True(len(incremental) < len(backup))
+}
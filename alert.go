@@ -0,0 +1,363 @@
+package channelstats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// The rule is breached and the alert has not resolved
+	AlertFiring = "firing"
+	// The rule was breached but is no longer
+	AlertResolved = "resolved"
+
+	// Measure the sum of the counter
+	AlertMeasureSum = "sum"
+	// Measure the percentage of messages counted by the counter
+	AlertMeasurePercentage = "percentage"
+
+	// Fire when the measure is above the threshold
+	AlertAbove = "above"
+	// Fire when the measure is below the threshold
+	AlertBelow = "below"
+)
+
+var (
+	alertMeasures   = []string{AlertMeasureSum, AlertMeasurePercentage}
+	alertConditions = []string{AlertAbove, AlertBelow}
+)
+
+// The state of an alert rule for the channel, or for a user in the channel
+type Alert struct {
+	// Identifies the alert as the rule name, followed by the user if the rule measures each user
+	ID      string `json:"id"`
+	Rule    string `json:"rule"`
+	Channel string `json:"channel"`
+	// The user measured, empty if the rule measures the whole channel
+	User      string  `json:"user,omitempty"`
+	Status    string  `json:"status"`
+	Counter   string  `json:"counter"`
+	Measure   string  `json:"measure"`
+	Window    string  `json:"window"`
+	Condition string  `json:"condition"`
+	Threshold float64 `json:"threshold"`
+	// The measure when the rule was last evaluated
+	Value float64 `json:"value"`
+	// When the alert started firing
+	Since time.Time `json:"since"`
+	// When the alert resolved, empty if the alert is firing
+	Resolved *time.Time `json:"resolved,omitempty"`
+	// When the destinations were last notified the alert is firing
+	Notified *time.Time `json:"notified,omitempty"`
+
+	// True if the destinations were notified the alert is firing, if not the resolved alert is not notified either
+	announced bool
+}
+
+func (a Alert) String() string {
+	subject := fmt.Sprintf("%s %s", a.Counter, a.Measure)
+	if a.User != "" {
+		subject += " for " + a.User
+	}
+
+	value := fmt.Sprintf("%.0f", a.Value)
+	threshold := fmt.Sprintf("%.0f", a.Threshold)
+	if a.Measure == AlertMeasurePercentage {
+		value = fmt.Sprintf("%.1f%%", a.Value)
+		threshold = fmt.Sprintf("%.1f%%", a.Threshold)
+	}
+
+	if a.Status == AlertResolved {
+		return fmt.Sprintf("Alert '%s' has resolved: %s in #%s over the last %s is %s",
+			a.Rule, subject, a.Channel, a.Window, value)
+	}
+	return fmt.Sprintf("Alert '%s' is firing: %s in #%s over the last %s is %s, %s the threshold of %s",
+		a.Rule, subject, a.Channel, a.Window, value, a.Condition, threshold)
+}
+
+// The state of the alerts as reported by /api/alerts
+type AlertsResp struct {
+	// When the rules were last evaluated, empty if the rules have not been evaluated yet
+	Evaluated *time.Time `json:"evaluated,omitempty"`
+	// The number of alerts firing
+	Firing int     `json:"firing"`
+	Items  []Alert `json:"items"`
+}
+
+// Evaluates the alert rules on a schedule and notifies the destinations of each rule when an
+// alert starts firing and when it resolves
+type Alerter struct {
+	log    *logrus.Entry
+	conf   AlertConfig
+	cron   *cron.Cron
+	list   ChanLister
+	store  Storer
+	notify *notifier
+
+	mutex     sync.Mutex
+	alerts    map[string]*Alert
+	evaluated *time.Time
+}
+
+// Returns nil if there are no alert rules
+func NewAlerter(conf Config, list ChanLister, store Storer, mail Mailer) (*Alerter, error) {
+	if len(conf.Alert.Rules) == 0 {
+		return nil, nil
+	}
+
+	a := &Alerter{
+		log:    GetLogger().WithField("prefix", "alert"),
+		conf:   conf.Alert,
+		cron:   cron.New(),
+		list:   list,
+		store:  store,
+		notify: newNotifier(conf, list, mail),
+		alerts: make(map[string]*Alert),
+	}
+
+	err := a.cron.AddFunc(a.conf.Schedule, func() {
+		if _, err := a.Evaluate(context.Background(), time.Now()); err != nil {
+			a.log.Errorf("while evaluating alert rules: %s", err)
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while scheduling alert rules for '%s'", a.conf.Schedule)
+	}
+	a.cron.Start()
+	return a, nil
+}
+
+// Evaluate every rule as of now, returns the alerts the destinations were notified of
+func (a *Alerter) Evaluate(ctx context.Context, now time.Time) ([]Alert, error) {
+	now = now.UTC()
+
+	var results []Alert
+	var failed []string
+	for _, rule := range a.conf.Rules {
+		changed, err := a.evaluate(ctx, rule, now)
+		if err != nil {
+			a.log.Errorf("while evaluating alert rule '%s': %s", rule.Name, err)
+			failed = append(failed, rule.Name)
+			continue
+		}
+
+		for _, alert := range changed {
+			a.log.Info(alert.String())
+			if err := a.notify.Notify(rule.Destinations, rule.SlackChannel, alert.String()); err != nil {
+				a.log.Errorf("while notifying alert '%s': %s", alert.ID, err)
+			}
+		}
+		results = append(results, changed...)
+	}
+
+	a.mutex.Lock()
+	a.evaluated = &now
+	a.mutex.Unlock()
+
+	if len(failed) != 0 {
+		return results, errors.Errorf("failed to evaluate alert rules '%s'", strings.Join(failed, "', '"))
+	}
+	return results, nil
+}
+
+// Update the alerts of the rule, returns the alerts that started firing or resolved
+// which the destinations should be notified of
+func (a *Alerter) evaluate(ctx context.Context, rule AlertRule, now time.Time) ([]Alert, error) {
+	channel, ok := findChannel(a.list, rule.Channel)
+	if !ok {
+		return nil, errors.Errorf("unknown channel '%s'", rule.Channel)
+	}
+
+	values, err := a.measure(ctx, rule, channel.Id, alertRange(now, rule.Window.Duration))
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var results []Alert
+	breached := make(map[string]bool)
+	for subject, value := range values {
+		if (rule.Condition == AlertAbove && value <= rule.Threshold) ||
+			(rule.Condition == AlertBelow && value >= rule.Threshold) {
+			continue
+		}
+
+		id := rule.Name
+		if subject != "" {
+			id += "/" + subject
+		}
+		breached[id] = true
+
+		prev, ok := a.alerts[id]
+		if ok && prev.Status == AlertFiring {
+			prev.Value = value
+			// An alert which fired again within the cooldown is notified once the cooldown has passed
+			if announce(prev, now, rule.Cooldown.Duration) {
+				results = append(results, *prev)
+			}
+			continue
+		}
+
+		alert := &Alert{
+			ID:        id,
+			Rule:      rule.Name,
+			Channel:   channel.Name,
+			User:      subject,
+			Status:    AlertFiring,
+			Counter:   rule.Counter,
+			Measure:   rule.Measure,
+			Window:    shortDuration(rule.Window.Duration),
+			Condition: rule.Condition,
+			Threshold: rule.Threshold,
+			Value:     value,
+			Since:     now,
+		}
+		if ok {
+			alert.Notified = prev.Notified
+		}
+
+		if announce(alert, now, rule.Cooldown.Duration) {
+			results = append(results, *alert)
+		}
+		a.alerts[id] = alert
+	}
+
+	for id, alert := range a.alerts {
+		if alert.Rule != rule.Name || alert.Status != AlertFiring || breached[id] {
+			continue
+		}
+
+		resolved := now
+		alert.Status = AlertResolved
+		alert.Resolved = &resolved
+		alert.Value = values[alert.User]
+		if alert.announced {
+			results = append(results, *alert)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
+// Marks the firing alert as notified, returns false if the destinations were already notified
+// or the cooldown since the last notification has not passed
+func announce(alert *Alert, now time.Time, cooldown time.Duration) bool {
+	if alert.announced || (alert.Notified != nil && now.Sub(*alert.Notified) < cooldown) {
+		return false
+	}
+	notified := now
+	alert.Notified = &notified
+	alert.announced = true
+	return true
+}
+
+// Returns the measure of the rule keyed by user name, or by an empty string if the
+// rule measures the whole channel
+func (a *Alerter) measure(ctx context.Context, rule AlertRule, channelID string, timeRange *TimeRange) (map[string]float64, error) {
+	messages, err := a.store.SumByUser(ctx, timeRange, channelID, "messages")
+	if err != nil {
+		return nil, errors.Wrap(err, "while fetching 'messages'")
+	}
+
+	counts, err := a.store.SumByUser(ctx, timeRange, channelID, rule.Counter)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching '%s'", rule.Counter)
+	}
+
+	totals, values := make(map[string]int64), make(map[string]int64)
+	if rule.PerUser {
+		for _, item := range messages {
+			totals[item.User] = item.Sum
+		}
+		for _, item := range counts {
+			values[item.User] = item.Sum
+			if _, ok := totals[item.User]; !ok {
+				totals[item.User] = 0
+			}
+		}
+	} else {
+		// The channel is measured even when nothing was counted, such that 'below' rules fire for quiet channels
+		totals[""], values[""] = 0, 0
+		for _, item := range messages {
+			totals[""] += item.Sum
+		}
+		for _, item := range counts {
+			values[""] += item.Sum
+		}
+	}
+
+	results := make(map[string]float64)
+	for subject, total := range totals {
+		if total < rule.MinMessages {
+			continue
+		}
+		switch rule.Measure {
+		case AlertMeasurePercentage:
+			if total != 0 {
+				results[subject] = float64(values[subject]) / float64(total) * 100
+			} else {
+				results[subject] = 0
+			}
+		default:
+			results[subject] = float64(values[subject])
+		}
+	}
+	return results, nil
+}
+
+// Returns the time range covering the window, ending with the current hour
+func alertRange(now time.Time, window time.Duration) *TimeRange {
+	end := now.UTC().Truncate(time.Hour)
+	return &TimeRange{Start: end.Add(-window).Add(time.Hour), End: end}
+}
+
+// Returns the duration without trailing zero units such as '24h' instead of '24h0m0s'
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// Returns the alerts sorted by id. Empty arguments match every alert.
+func (a *Alerter) Status(rule, channel, status string) AlertsResp {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	resp := AlertsResp{Evaluated: a.evaluated, Items: []Alert{}}
+	for _, alert := range a.alerts {
+		if alert.Status == AlertFiring {
+			resp.Firing++
+		}
+		if (rule != "" && alert.Rule != rule) || (channel != "" && alert.Channel != channel) ||
+			(status != "" && alert.Status != status) {
+			continue
+		}
+		resp.Items = append(resp.Items, *alert)
+	}
+
+	sort.Slice(resp.Items, func(i, j int) bool { return resp.Items[i].ID < resp.Items[j].ID })
+	return resp
+}
+
+func (a *Alerter) Stop() {
+	if a == nil {
+		return
+	}
+	a.cron.Stop()
+}
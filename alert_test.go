@@ -0,0 +1,229 @@
+package channelstats_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mailgun/holster/clock"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestAlert(t *testing.T) {
+	suite.Run(t, new(AlertSuite))
+}
+
+type AlertSuite struct {
+	suite.Suite
+	store  channelstats.Storer
+	mailer *operatorMailer
+	now    time.Time
+}
+
+func (s *AlertSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *AlertSuite) SetupTest() {
+	conf := channelstats.Config{}
+	conf.Store.Backend = "memory"
+
+	var err error
+	idMgr := &channelstats.MockIDManage{UserByID: map[string]string{"U1": "joe", "U2": "bob", "U3": "ann"}}
+	s.store, err = channelstats.NewStore(conf, idMgr)
+	s.Require().NoError(err)
+	s.mailer = &operatorMailer{}
+	s.now = time.Date(2018, 12, 13, 12, 0, 0, 0, time.UTC)
+}
+
+func (s *AlertSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *AlertSuite) alerter(rules ...channelstats.AlertRule) *channelstats.Alerter {
+	conf := channelstats.Config{}
+	conf.Alert.Schedule = "0 */5 * * * *"
+	for _, rule := range rules {
+		rule.Window = clock.DurationJSON{Duration: time.Hour * 24}
+		rule.Cooldown = clock.DurationJSON{Duration: time.Hour}
+		rule.Destinations = []string{channelstats.NotifyOperator}
+		conf.Alert.Rules = append(conf.Alert.Rules, rule)
+	}
+
+	list := channelList{
+		{Name: "general", Id: "C1", IsMember: true},
+		{Name: "support", Id: "C2", IsMember: true},
+	}
+	alerter, err := channelstats.NewAlerter(conf, list, s.store, s.mailer)
+	s.Require().NoError(err)
+	return alerter
+}
+
+func (s *AlertSuite) add(hour, userID, counter string, value int64) {
+	reindexer, ok := s.store.(channelstats.Reindexer)
+	s.Require().True(ok)
+	s.Require().NoError(reindexer.AddDataPoints([]channelstats.DataPoint{
+		{Hour: hour, Counter: counter, ChannelID: "C2", UserID: userID, Value: value},
+	}))
+}
+
+func (s *AlertSuite) evaluate(alerter *channelstats.Alerter, after time.Duration) []channelstats.Alert {
+	alerts, err := alerter.Evaluate(context.Background(), s.now.Add(after))
+	s.Require().NoError(err)
+	return alerts
+}
+
+func (s *AlertSuite) TestFiringAndResolved() {
+	alerter := s.alerter(channelstats.AlertRule{
+		Name:        "support-negativity",
+		Channel:     "support",
+		Counter:     "negative",
+		Measure:     channelstats.AlertMeasurePercentage,
+		Condition:   channelstats.AlertAbove,
+		Threshold:   30,
+		PerUser:     true,
+		MinMessages: 20,
+	})
+	defer alerter.Stop()
+
+	s.add("2018-12-13T10", "U1", "messages", 25)
+	s.add("2018-12-13T10", "U1", "negative", 10)
+	s.add("2018-12-13T10", "U2", "messages", 30)
+	s.add("2018-12-13T10", "U2", "negative", 3)
+	// Too few messages to be measured
+	s.add("2018-12-13T10", "U3", "messages", 5)
+	s.add("2018-12-13T10", "U3", "negative", 5)
+
+	alerts := s.evaluate(alerter, 0)
+	s.Require().Len(alerts, 1)
+	s.Equal("support-negativity/joe", alerts[0].ID)
+	s.Equal(channelstats.AlertFiring, alerts[0].Status)
+	s.Equal(40.0, alerts[0].Value)
+	s.Equal([]string{"Alert 'support-negativity' is firing: negative percentage for joe in #support " +
+		"over the last 24h is 40.0%, above the threshold of 30.0%"}, s.mailer.Messages())
+
+	// Alerts which are still firing are not notified again
+	s.Empty(s.evaluate(alerter, time.Minute*5))
+	s.Len(s.mailer.Messages(), 1)
+
+	// joe is now at 20%
+	s.add("2018-12-13T11", "U1", "messages", 25)
+	alerts = s.evaluate(alerter, time.Minute*10)
+	s.Require().Len(alerts, 1)
+	s.Equal(channelstats.AlertResolved, alerts[0].Status)
+	s.Require().Len(s.mailer.Messages(), 2)
+	s.Equal("Alert 'support-negativity' has resolved: negative percentage for joe in #support "+
+		"over the last 24h is 20.0%", s.mailer.Messages()[1])
+
+	status := alerter.Status("", "", "")
+	s.Equal(0, status.Firing)
+	s.Require().Len(status.Items, 1)
+	s.Equal(channelstats.AlertResolved, status.Items[0].Status)
+	s.Require().NotNil(status.Evaluated)
+	s.Equal(s.now.Add(time.Minute*10), *status.Evaluated)
+}
+
+func (s *AlertSuite) TestCooldown() {
+	alerter := s.alerter(channelstats.AlertRule{
+		Name:      "support-negativity",
+		Channel:   "support",
+		Counter:   "negative",
+		Measure:   channelstats.AlertMeasurePercentage,
+		Condition: channelstats.AlertAbove,
+		Threshold: 30,
+	})
+	defer alerter.Stop()
+
+	// 50%
+	s.add("2018-12-13T10", "U1", "messages", 10)
+	s.add("2018-12-13T10", "U1", "negative", 5)
+	s.Len(s.evaluate(alerter, 0), 1)
+	s.Equal("Alert 'support-negativity' is firing: negative percentage in #support over the last 24h "+
+		"is 50.0%, above the threshold of 30.0%", s.mailer.Messages()[0])
+
+	// 25%
+	s.add("2018-12-13T11", "U2", "messages", 10)
+	s.Len(s.evaluate(alerter, time.Minute*5), 1)
+	s.Len(s.mailer.Messages(), 2)
+
+	// Fires again within the cooldown, neither firing nor resolving is notified
+	s.add("2018-12-13T11", "U2", "negative", 5)
+	s.Empty(s.evaluate(alerter, time.Minute*10))
+	status := alerter.Status("support-negativity", "support", channelstats.AlertFiring)
+	s.Equal(1, status.Firing)
+	s.Require().Len(status.Items, 1)
+	s.Equal(s.now, *status.Items[0].Notified)
+
+	s.add("2018-12-13T11", "U2", "messages", 20)
+	s.Empty(s.evaluate(alerter, time.Minute*15))
+	s.Len(s.mailer.Messages(), 2)
+
+	// Once the cooldown has passed firing is notified
+	s.add("2018-12-13T12", "U2", "negative", 10)
+	s.Len(s.evaluate(alerter, time.Minute*90), 1)
+	s.Len(s.mailer.Messages(), 3)
+}
+
+func (s *AlertSuite) TestCooldownStillFiring() {
+	alerter := s.alerter(channelstats.AlertRule{
+		Name:      "support-negativity",
+		Channel:   "support",
+		Counter:   "negative",
+		Measure:   channelstats.AlertMeasurePercentage,
+		Condition: channelstats.AlertAbove,
+		Threshold: 30,
+	})
+	defer alerter.Stop()
+
+	// 50%, then 25%
+	s.add("2018-12-13T10", "U1", "messages", 10)
+	s.add("2018-12-13T10", "U1", "negative", 5)
+	s.Len(s.evaluate(alerter, 0), 1)
+	s.add("2018-12-13T11", "U2", "messages", 10)
+	s.Len(s.evaluate(alerter, time.Minute*5), 1)
+
+	// Fires again within the cooldown and keeps firing
+	s.add("2018-12-13T11", "U2", "negative", 5)
+	s.Empty(s.evaluate(alerter, time.Minute*10))
+	s.Empty(s.evaluate(alerter, time.Minute*30))
+	s.Len(s.mailer.Messages(), 2)
+
+	// Once the cooldown has passed the alert still firing is notified, but only once
+	alerts := s.evaluate(alerter, time.Hour)
+	s.Require().Len(alerts, 1)
+	s.Equal(channelstats.AlertFiring, alerts[0].Status)
+	s.Equal(s.now.Add(time.Minute*10), alerts[0].Since)
+	s.Equal(s.now.Add(time.Hour), *alerts[0].Notified)
+	s.Require().Len(s.mailer.Messages(), 3)
+	s.Equal("Alert 'support-negativity' is firing: negative percentage in #support over the last 24h "+
+		"is 50.0%, above the threshold of 30.0%", s.mailer.Messages()[2])
+
+	s.Empty(s.evaluate(alerter, time.Minute*65))
+	s.Len(s.mailer.Messages(), 3)
+}
+
+func (s *AlertSuite) TestChannelBelow() {
+	alerter := s.alerter(channelstats.AlertRule{
+		Name:      "quiet-general",
+		Channel:   "#general",
+		Counter:   "messages",
+		Measure:   channelstats.AlertMeasureSum,
+		Condition: channelstats.AlertBelow,
+		Threshold: 1,
+	})
+	defer alerter.Stop()
+
+	alerts := s.evaluate(alerter, 0)
+	s.Require().Len(alerts, 1)
+	s.Equal("quiet-general", alerts[0].ID)
+	s.Equal([]string{"Alert 'quiet-general' is firing: messages sum in #general over the last 24h is 0, " +
+		"below the threshold of 1"}, s.mailer.Messages())
+}
+
+func (s *AlertSuite) TestNoRules() {
+	alerter, err := channelstats.NewAlerter(channelstats.Config{}, channelList{}, s.store, s.mailer)
+	s.Require().NoError(err)
+	s.Nil(alerter)
+	alerter.Stop()
+}
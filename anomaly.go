@@ -0,0 +1,294 @@
+package channelstats
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Many more messages than usual for the hour of the week
+	AnomalySpike = "spike"
+	// Far fewer messages than usual for the hour of the week
+	AnomalySilence = "silence"
+	// Many more negative messages than usual for the hour of the week
+	AnomalyNegativity = "negativity"
+
+	// The number of anomalies kept for /api/anomalies
+	anomalyHistory = 1000
+)
+
+// An hour of channel activity which is unusual compared to the same hour of the week in previous weeks
+type Anomaly struct {
+	// Identifies the anomaly, an anomaly is only raised once
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Channel   string `json:"channel"`
+	ChannelID string `json:"channel-id"`
+	Hour      string `json:"hour"`
+	Counter   string `json:"counter"`
+	Value     int64  `json:"value"`
+	// The mean and standard deviation of the counter for the same hour of the week in previous weeks
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std-dev"`
+	// The number of standard deviations the value is from the mean
+	Score    float64   `json:"score"`
+	Detected time.Time `json:"detected"`
+}
+
+func (a Anomaly) String() string {
+	var title, unit string
+	switch a.Kind {
+	case AnomalySpike:
+		title, unit = "Spike", "messages"
+	case AnomalySilence:
+		title, unit = "Silence", "messages"
+	default:
+		title, unit = "Negativity surge", "negative messages"
+	}
+	return fmt.Sprintf("%s in #%s: %d %s during %s UTC, usually %.1f ± %.1f",
+		title, a.Channel, a.Value, unit, a.Hour, a.Mean, a.StdDev)
+}
+
+// The mean and standard deviation of a counter for the same hour of the week in previous weeks
+type Baseline struct {
+	Mean   float64
+	StdDev float64
+}
+
+func NewBaseline(values []int64) Baseline {
+	if len(values) == 0 {
+		return Baseline{}
+	}
+
+	var sum float64
+	for _, value := range values {
+		sum += float64(value)
+	}
+	mean := sum / float64(len(values))
+
+	var variance float64
+	for _, value := range values {
+		variance += math.Pow(float64(value)-mean, 2)
+	}
+	return Baseline{Mean: mean, StdDev: math.Sqrt(variance / float64(len(values)))}
+}
+
+// Returns the number of standard deviations the value is from the mean. Counts of messages
+// vary by at least the square root of the mean, so quiet channels with a steady baseline do
+// not raise anomalies for a handful of extra messages.
+func (b Baseline) Score(value int64) float64 {
+	stdDev := math.Max(b.StdDev, math.Max(math.Sqrt(b.Mean), 1))
+	return (float64(value) - b.Mean) / stdDev
+}
+
+// Checks the hourly activity of each channel the bot is in against the baseline for
+// the hour of the week and notifies the configured destinations of any anomalies
+type AnomalyDetector struct {
+	log    *logrus.Entry
+	conf   AnomalyConfig
+	cron   *cron.Cron
+	list   ChanLister
+	store  Storer
+	notify *notifier
+
+	mutex     sync.Mutex
+	anomalies []Anomaly
+	raised    map[string]bool
+}
+
+// Returns nil if anomaly detection is not enabled
+func NewAnomalyDetector(conf Config, list ChanLister, store Storer, mail Mailer) (*AnomalyDetector, error) {
+	if !conf.Anomaly.Enabled {
+		return nil, nil
+	}
+
+	d := &AnomalyDetector{
+		log:    GetLogger().WithField("prefix", "anomaly"),
+		conf:   conf.Anomaly,
+		cron:   cron.New(),
+		list:   list,
+		store:  store,
+		notify: newNotifier(conf, list, mail),
+		raised: make(map[string]bool),
+	}
+
+	err := d.cron.AddFunc(d.conf.Schedule, func() {
+		// Check the last complete hour
+		hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+		if _, err := d.Detect(context.Background(), hour); err != nil {
+			d.log.Errorf("while detecting anomalies: %s", err)
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while scheduling anomaly detection for '%s'", d.conf.Schedule)
+	}
+	d.cron.Start()
+	return d, nil
+}
+
+// Check the hour for every channel the bot is in, returns the anomalies which were not raised before
+func (d *AnomalyDetector) Detect(ctx context.Context, hour time.Time) ([]Anomaly, error) {
+	hour = hour.UTC().Truncate(time.Hour)
+
+	var results []Anomaly
+	for _, channel := range d.list.Channels() {
+		if !channel.IsMember {
+			continue
+		}
+
+		anomalies, err := d.detectChannel(ctx, channel, hour)
+		if err != nil {
+			return results, errors.Wrapf(err, "while checking '%s'", channel.Name)
+		}
+
+		for _, anomaly := range anomalies {
+			if !d.raise(anomaly) {
+				continue
+			}
+			d.log.Infof("Detected %s", anomaly)
+			if err := d.notify.Notify(d.conf.Destinations, d.conf.SlackChannel, anomaly.String()); err != nil {
+				d.log.Errorf("while notifying anomaly '%s': %s", anomaly.ID, err)
+			}
+			results = append(results, anomaly)
+		}
+	}
+	return results, nil
+}
+
+func (d *AnomalyDetector) detectChannel(ctx context.Context, channel SlackChannelInfo, hour time.Time) ([]Anomaly, error) {
+	// Until the channel has enough history every baseline week is zero, and any busy hour would be a spike
+	warm, err := d.warmedUp(ctx, channel.Id, hour)
+	if err != nil || !warm {
+		return nil, err
+	}
+
+	var results []Anomaly
+	for _, counter := range []string{"messages", "negative"} {
+		value, baseline, err := d.hourOfWeek(ctx, channel.Id, counter, hour)
+		if err != nil {
+			return nil, err
+		}
+
+		score := baseline.Score(value)
+		var kind string
+		switch {
+		case counter == "messages" && score >= d.conf.Threshold && value >= int64(d.conf.MinCount):
+			kind = AnomalySpike
+		case counter == "messages" && score <= -d.conf.Threshold && baseline.Mean >= float64(d.conf.MinCount):
+			kind = AnomalySilence
+		case counter == "negative" && score >= d.conf.Threshold && value >= int64(d.conf.MinCount):
+			kind = AnomalyNegativity
+		default:
+			continue
+		}
+
+		results = append(results, Anomaly{
+			ID:        fmt.Sprintf("%s/%s/%s", hour.Format(RFC3339Short), channel.Id, kind),
+			Kind:      kind,
+			Channel:   channel.Name,
+			ChannelID: channel.Id,
+			Hour:      hour.Format(RFC3339Short),
+			Counter:   counter,
+			Value:     value,
+			Mean:      baseline.Mean,
+			StdDev:    baseline.StdDev,
+			Score:     score,
+			Detected:  time.Now().UTC(),
+		})
+	}
+	return results, nil
+}
+
+// Returns the counter total for the hour and the baseline for the same hour of the week in previous weeks
+func (d *AnomalyDetector) hourOfWeek(ctx context.Context, channelID, counter string, hour time.Time) (int64, Baseline, error) {
+	value, err := hourTotal(ctx, d.store, channelID, counter, hour)
+	if err != nil {
+		return 0, Baseline{}, err
+	}
+
+	var previous []int64
+	for week := 1; week <= d.conf.BaselineWeeks; week++ {
+		total, err := hourTotal(ctx, d.store, channelID, counter, hour.AddDate(0, 0, -7*week))
+		if err != nil {
+			return 0, Baseline{}, err
+		}
+		previous = append(previous, total)
+	}
+	return value, NewBaseline(previous), nil
+}
+
+// Returns true if the channel has messages in the oldest week of the baseline, such that the
+// baseline weeks do not fall before the first messages counted in the channel
+func (d *AnomalyDetector) warmedUp(ctx context.Context, channelID string, hour time.Time) (bool, error) {
+	start := hour.AddDate(0, 0, -7*d.conf.BaselineWeeks)
+	timeRange := &TimeRange{Start: start, End: start.AddDate(0, 0, 7).Add(-time.Hour)}
+	totals, err := ChannelTotals(ctx, d.store, timeRange, []string{channelID}, "messages")
+	if err != nil {
+		return false, errors.Wrap(err, "while fetching the oldest week of the baseline")
+	}
+	return totals[0].Sum != 0, nil
+}
+
+func hourTotal(ctx context.Context, store Storer, channelID, counter string, hour time.Time) (int64, error) {
+	totals, err := ChannelTotals(ctx, store, &TimeRange{Start: hour, End: hour}, []string{channelID}, counter)
+	if err != nil {
+		return 0, errors.Wrapf(err, "while fetching '%s' for %s", counter, hour.Format(RFC3339Short))
+	}
+	return totals[0].Sum, nil
+}
+
+// Record the anomaly, returns false if the anomaly was already raised
+func (d *AnomalyDetector) raise(anomaly Anomaly) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.raised[anomaly.ID] {
+		return false
+	}
+	d.raised[anomaly.ID] = true
+	d.anomalies = append(d.anomalies, anomaly)
+
+	// Forget the oldest anomalies
+	if len(d.anomalies) > anomalyHistory {
+		delete(d.raised, d.anomalies[0].ID)
+		d.anomalies = d.anomalies[1:]
+	}
+	return true
+}
+
+// Returns the anomalies raised for hours within the time range, oldest first. If
+// channelID is not empty only anomalies for the channel are returned.
+func (d *AnomalyDetector) Anomalies(timeRange *TimeRange, channelID string) []Anomaly {
+	if d == nil {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	results := []Anomaly{}
+	for _, anomaly := range d.anomalies {
+		if anomaly.Hour < timeRange.StartDate() || anomaly.Hour > timeRange.EndDate() {
+			continue
+		}
+		if channelID != "" && anomaly.ChannelID != channelID {
+			continue
+		}
+		results = append(results, anomaly)
+	}
+	return results
+}
+
+func (d *AnomalyDetector) Stop() {
+	if d == nil {
+		return
+	}
+	d.cron.Stop()
+}
@@ -0,0 +1,174 @@
+package channelstats_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestAnomaly(t *testing.T) {
+	suite.Run(t, new(AnomalySuite))
+}
+
+type AnomalySuite struct {
+	suite.Suite
+	store    channelstats.Storer
+	mailer   *operatorMailer
+	detector *channelstats.AnomalyDetector
+}
+
+func (s *AnomalySuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *AnomalySuite) SetupTest() {
+	conf := channelstats.Config{}
+	conf.Store.Backend = "memory"
+	conf.Anomaly = channelstats.AnomalyConfig{
+		Enabled:       true,
+		Schedule:      "0 5 * * * *",
+		BaselineWeeks: 4,
+		Threshold:     3.0,
+		MinCount:      5,
+		Destinations:  []string{channelstats.NotifyOperator},
+	}
+
+	var err error
+	s.store, err = channelstats.NewStore(conf, &channelstats.MockIDManage{})
+	s.Require().NoError(err)
+
+	reindexer, ok := s.store.(channelstats.Reindexer)
+	s.Require().True(ok)
+
+	// The same hour of the week in the previous 4 weeks
+	var points []channelstats.DataPoint
+	for week, count := range []int64{10, 12, 8, 10} {
+		hour := time.Date(2018, 12, 13, 14, 0, 0, 0, time.UTC).AddDate(0, 0, -7*(week+1))
+		points = append(points,
+			channelstats.DataPoint{Hour: hour.Format(channelstats.RFC3339Short), Counter: "messages",
+				ChannelID: "C1", UserID: "U1", Value: count},
+			channelstats.DataPoint{Hour: hour.Format(channelstats.RFC3339Short), Counter: "messages",
+				ChannelID: "C2", UserID: "U1", Value: 20},
+		)
+	}
+	points = append(points,
+		channelstats.DataPoint{Hour: "2018-12-13T14", Counter: "messages", ChannelID: "C1", UserID: "U1", Value: 40},
+		channelstats.DataPoint{Hour: "2018-12-13T14", Counter: "negative", ChannelID: "C1", UserID: "U1", Value: 6},
+		channelstats.DataPoint{Hour: "2018-12-13T14", Counter: "messages", ChannelID: "C3", UserID: "U1", Value: 3},
+	)
+	s.Require().NoError(reindexer.AddDataPoints(points))
+
+	list := channelList{
+		{Name: "general", Id: "C1", IsMember: true},
+		{Name: "support", Id: "C2", IsMember: true},
+		{Name: "random", Id: "C3", IsMember: true},
+	}
+	s.mailer = &operatorMailer{}
+	s.detector, err = channelstats.NewAnomalyDetector(conf, list, s.store, s.mailer)
+	s.Require().NoError(err)
+}
+
+func (s *AnomalySuite) TearDownTest() {
+	s.detector.Stop()
+	s.store.Close()
+}
+
+func (s *AnomalySuite) TestDetect() {
+	hour := time.Date(2018, 12, 13, 14, 0, 0, 0, time.UTC)
+	anomalies, err := s.detector.Detect(context.Background(), hour)
+	s.Require().NoError(err)
+	s.Require().Len(anomalies, 3)
+
+	s.Equal(channelstats.AnomalySpike, anomalies[0].Kind)
+	s.Equal("general", anomalies[0].Channel)
+	s.Equal(int64(40), anomalies[0].Value)
+	s.Equal(10.0, anomalies[0].Mean)
+
+	s.Equal(channelstats.AnomalyNegativity, anomalies[1].Kind)
+	s.Equal("negative", anomalies[1].Counter)
+
+	s.Equal(channelstats.AnomalySilence, anomalies[2].Kind)
+	s.Equal("support", anomalies[2].Channel)
+	s.Equal(int64(0), anomalies[2].Value)
+
+	s.Require().Len(s.mailer.Messages(), 3)
+	s.Equal("Spike in #general: 40 messages during 2018-12-13T14 UTC, usually 10.0 ± 1.4", s.mailer.Messages()[0])
+
+	// Anomalies are only raised once
+	anomalies, err = s.detector.Detect(context.Background(), hour)
+	s.Require().NoError(err)
+	s.Empty(anomalies)
+	s.Len(s.mailer.Messages(), 3)
+
+	timeRange, err := channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T23")
+	s.Require().NoError(err)
+	s.Len(s.detector.Anomalies(timeRange, ""), 3)
+	s.Len(s.detector.Anomalies(timeRange, "C2"), 1)
+
+	timeRange, err = channelstats.NewTimeRange("2018-12-14T00", "2018-12-14T23")
+	s.Require().NoError(err)
+	s.Empty(s.detector.Anomalies(timeRange, ""))
+}
+
+func (s *AnomalySuite) TestWarmUp() {
+	conf := channelstats.Config{}
+	conf.Store.Backend = "memory"
+	conf.Anomaly = channelstats.AnomalyConfig{
+		Enabled:       true,
+		Schedule:      "0 5 * * * *",
+		BaselineWeeks: 4,
+		Threshold:     3.0,
+		MinCount:      5,
+		Destinations:  []string{channelstats.NotifyOperator},
+	}
+
+	store, err := channelstats.NewStore(conf, &channelstats.MockIDManage{})
+	s.Require().NoError(err)
+	defer store.Close()
+
+	reindexer, ok := store.(channelstats.Reindexer)
+	s.Require().True(ok)
+
+	// A new install, with a single week of history
+	s.Require().NoError(reindexer.AddDataPoints([]channelstats.DataPoint{
+		{Hour: "2018-12-06T14", Counter: "messages", ChannelID: "C1", UserID: "U1", Value: 2},
+		{Hour: "2018-12-13T14", Counter: "messages", ChannelID: "C1", UserID: "U1", Value: 40},
+		{Hour: "2018-12-13T14", Counter: "negative", ChannelID: "C1", UserID: "U1", Value: 20},
+	}))
+
+	detector, err := channelstats.NewAnomalyDetector(conf, channelList{{Name: "general", Id: "C1", IsMember: true}},
+		store, s.mailer)
+	s.Require().NoError(err)
+	defer detector.Stop()
+
+	anomalies, err := detector.Detect(context.Background(), time.Date(2018, 12, 13, 14, 0, 0, 0, time.UTC))
+	s.Require().NoError(err)
+	s.Empty(anomalies)
+	s.Empty(s.mailer.Messages())
+}
+
+func (s *AnomalySuite) TestBaseline() {
+	baseline := channelstats.NewBaseline([]int64{10, 12, 8, 10})
+	s.Equal(10.0, baseline.Mean)
+	s.InDelta(1.414, baseline.StdDev, 0.001)
+
+	// Quiet channels vary by at least the square root of the mean
+	s.InDelta(3.0, channelstats.NewBaseline([]int64{9, 9}).Score(18), 0.001)
+	// A baseline of nothing varies by at least one
+	s.Equal(4.0, channelstats.NewBaseline([]int64{0, 0}).Score(4))
+}
+
+func (s *AnomalySuite) TestDisabled() {
+	detector, err := channelstats.NewAnomalyDetector(channelstats.Config{}, channelList{}, s.store, s.mailer)
+	s.Require().NoError(err)
+	s.Nil(detector)
+
+	// A disabled detector is safe to use
+	timeRange, err := channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T23")
+	s.Require().NoError(err)
+	s.Nil(detector.Anomalies(timeRange, ""))
+	detector.Stop()
+}
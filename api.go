@@ -1,6 +1,7 @@
 package channelstats
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -18,6 +19,8 @@ import (
 
 var (
 	validParams    = []string{"start-hour", "end-hour", "channel", "counter"}
+	queryParams    = []string{"start-hour", "end-hour", "channel", "counter", "format"}
+	compareParams  = []string{"start-hour", "end-hour", "channel", "counter", "format", "compare"}
 	requiredParams = []string{"channel", "counter"}
 	validCounters  = []string{"messages", "positive", "negative", "link", "emoji", "word-count"}
 )
@@ -52,21 +55,35 @@ type ItemResp struct {
 	StartHour string      `json:"start-hour"`
 	EndHour   string      `json:"end-hour"`
 	Items     interface{} `json:"items"`
+	// The compared range and the users who only have a value in one of the ranges, if 'compare' was requested
+	CompareStartHour string   `json:"compare-start-hour,omitempty"`
+	CompareEndHour   string   `json:"compare-end-hour,omitempty"`
+	Appeared         []string `json:"appeared,omitempty"`
+	Disappeared      []string `json:"disappeared,omitempty"`
 }
 
 type Server struct {
-	idMgr  IDManager
-	wg     sync.WaitGroup
-	server *http.Server
-	store  Storer
-	log    *logrus.Entry
+	idMgr    IDManager
+	wg       sync.WaitGroup
+	server   *http.Server
+	store    Storer
+	reporter Reporter
+	detector *AnomalyDetector
+	alerter  *Alerter
+	log      *logrus.Entry
+	conf     Config
 }
 
-func NewServer(store Storer, idMgr IDManager) *Server {
+func NewServer(conf Config, store Storer, idMgr IDManager, bot *SlackBot, reporter Reporter,
+	detector *AnomalyDetector, alerter *Alerter) *Server {
 	s := &Server{
-		log:   GetLogger().WithField("prefix", "http"),
-		idMgr: idMgr,
-		store: store,
+		log:      GetLogger().WithField("prefix", "http"),
+		idMgr:    idMgr,
+		store:    store,
+		reporter: reporter,
+		detector: detector,
+		alerter:  alerter,
+		conf:     conf,
 	}
 
 	r := chi.NewRouter()
@@ -74,29 +91,65 @@ func NewServer(store Storer, idMgr IDManager) *Server {
 	// Middleware
 	r.Use(NewStructuredLogger(s.log))
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(5 * time.Second))
 
-	// UI Routes
-	r.Get("/", s.redirectUI)
-	r.Get("/index.html", s.redirectUI)
-	r.Route("/ui", func(r chi.Router) {
-		r.Get("/*", s.serveFiles)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(5 * time.Second))
+
+		// UI Routes
+		r.Get("/", s.redirectUI)
+		r.Get("/index.html", s.redirectUI)
+		r.Route("/ui", func(r chi.Router) {
+			r.Get("/reports/{id}", s.archivedReport)
+			r.Get("/*", s.serveFiles)
+		})
+
+		// API routes
+		r.Route("/api", func(r chi.Router) {
+			r.Get("/", s.doc)
+			r.Get("/datapoints", s.getDataPoints)
+			r.Get("/sum", s.getSum)
+			r.Get("/percentage", s.getPercentage)
+			r.Get("/chart/sum", s.chartSum)
+			r.Get("/chart/percentage", s.chartPercentage)
+			r.Get("/cache", s.getCacheStats)
+			r.Get("/reports", s.listReports)
+			r.Get("/anomalies", s.getAnomalies)
+			r.Get("/alerts", s.getAlerts)
+		})
 	})
 
-	// API routes
-	r.Route("/api", func(r chi.Router) {
-		r.Get("/all", s.getAll)
-		r.Get("/", s.doc)
-		r.Get("/datapoints", s.getDataPoints)
-		r.Get("/sum", s.getSum)
-		r.Get("/percentage", s.getPercentage)
-		r.Get("/chart/sum", s.chartSum)
-		r.Get("/chart/percentage", s.chartPercentage)
+	// Rendering a report renders every chart and so is not subject to the request timeout
+	r.Get("/api/report/preview", s.previewReport)
+
+	// Exports stream every data point and are not subject to the request timeout
+	r.Get("/api/all", s.getAll)
+
+	// Reports the state of the connection to slack
+	r.Method(http.MethodGet, "/healthz", NewHealthHandler(bot))
+
+	// Events pushed from slack via the Events API
+	if conf.Slack.Mode == SlackModeEvents {
+		r.Method(http.MethodPost, "/slack/events", NewSlackEventsHandler(conf, bot))
+	}
+
+	// The '/stats' slash command, requests are signed with the same secret as the Events API
+	if conf.Slack.SigningSecret != "" {
+		r.Method(http.MethodPost, "/slack/commands", NewSlashCommandHandler(conf, store, idMgr))
+	}
+
+	// Admin routes are long running and so are not subject to the request timeout
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(s.adminAuth)
+		r.Get("/backup", s.backup)
+		r.Post("/report/send", s.sendReport)
 	})
 
 	s.server = &http.Server{Addr: listenAddr, Handler: r}
+	return s
+}
 
-	// Listen thingy
+// Listen for requests on the listen address until Stop() is called
+func (s *Server) Start() {
 	s.wg.Add(1)
 	go func() {
 		s.log.Infof("Listening on %s", listenAddr)
@@ -107,7 +160,11 @@ func NewServer(store Storer, idMgr IDManager) *Server {
 		}
 		s.wg.Done()
 	}()
-	return s
+}
+
+// Serve the request without the listener, such that tests can serve the routes via httptest
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.server.Handler.ServeHTTP(w, r)
 }
 
 func (s *Server) Stop() error {
@@ -128,6 +185,7 @@ func (s *Server) doc(w http.ResponseWriter, r *http.Request) {
 					{Param: "end-hour", Desc: "retrieve counters ending at this hour"},
 					{Param: "channel", Desc: "channel to retrieve counters for"},
 					{Param: "counter", Desc: "name of the counter (See 'Counters' for valid counter names)"},
+					{Param: "format", Desc: formatDesc},
 				},
 			},
 			{
@@ -138,6 +196,8 @@ func (s *Server) doc(w http.ResponseWriter, r *http.Request) {
 					{Param: "end-hour", Desc: "retrieve counters ending at this hour"},
 					{Param: "channel", Desc: "channel to retrieve counters for"},
 					{Param: "counter", Desc: "name of the counter (See 'Counters' for valid counter names)"},
+					{Param: "format", Desc: formatDesc},
+					{Param: "compare", Desc: compareDesc},
 				},
 			},
 			{
@@ -148,6 +208,55 @@ func (s *Server) doc(w http.ResponseWriter, r *http.Request) {
 					{Param: "end-hour", Desc: "retrieve counters ending at this hour"},
 					{Param: "channel", Desc: "channel to retrieve counters for"},
 					{Param: "counter", Desc: "name of the counter (See 'Counters' for valid counter names)"},
+					{Param: "format", Desc: formatDesc},
+					{Param: "compare", Desc: compareDesc},
+				},
+			},
+			{
+				Path: "/api/all",
+				Desc: "stream all data points as newline delimited JSON, the next cursor is returned in the 'X-Next-Cursor' trailer",
+				Params: []ParamDoc{
+					{Param: "start-hour", Desc: "only include data points starting at this hour"},
+					{Param: "end-hour", Desc: "only include data points ending at this hour"},
+					{Param: "channel", Desc: "only include data points for this channel"},
+					{Param: "counter", Desc: "only include data points for this counter"},
+					{Param: "cursor", Desc: "continue after the data point with this key"},
+					{Param: "limit", Desc: "return at most this many data points"},
+				},
+			},
+			{Path: "/api/cache", Desc: "hit and miss statistics for the query cache"},
+			{
+				Path: "/api/reports",
+				Desc: "list the archived reports oldest first, view a report at '/ui/reports/{id}'",
+				Params: []ParamDoc{
+					{Param: "channel", Desc: "only list reports for this channel"},
+				},
+			},
+			{
+				Path: "/api/anomalies",
+				Desc: "list the anomalies detected in channel activity oldest first",
+				Params: []ParamDoc{
+					{Param: "start-hour", Desc: "only list anomalies starting at this hour"},
+					{Param: "end-hour", Desc: "only list anomalies ending at this hour"},
+					{Param: "channel", Desc: "only list anomalies for this channel"},
+				},
+			},
+			{
+				Path: "/api/alerts",
+				Desc: "list the state of the alert rules, firing and resolved alerts",
+				Params: []ParamDoc{
+					{Param: "rule", Desc: "only list alerts for this rule"},
+					{Param: "channel", Desc: "only list alerts for this channel"},
+					{Param: "status", Desc: "only list alerts which are 'firing' or 'resolved'"},
+				},
+			},
+			{
+				Path: "/api/report/preview",
+				Desc: "render the channel report as HTML with the charts inlined",
+				Params: []ParamDoc{
+					{Param: "channel", Desc: "channel to render the report for"},
+					{Param: "start-hour", Desc: "report starting at this hour (defaults to the report duration)"},
+					{Param: "end-hour", Desc: "report ending at this hour (defaults to now)"},
 				},
 			},
 		},
@@ -163,17 +272,14 @@ func (s *Server) doc(w http.ResponseWriter, r *http.Request) {
 	toJSON(w, resp)
 }
 
-func (s *Server) getAll(w http.ResponseWriter, r *http.Request) {
-	data, err := s.store.GetAll()
-	if err != nil {
-		abort(w, err, 500)
+func (s *Server) getDataPoints(w http.ResponseWriter, r *http.Request) {
+	if err := isValidParams(r, queryParams, requiredParams); err != nil {
+		abort(w, err, http.StatusBadRequest)
 		return
 	}
-	toJSON(w, data)
-}
 
-func (s *Server) getDataPoints(w http.ResponseWriter, r *http.Request) {
-	if err := isValidParams(r, validParams, requiredParams); err != nil {
+	format, err := formatFromRequest(r)
+	if err != nil {
 		abort(w, err, http.StatusBadRequest)
 		return
 	}
@@ -192,6 +298,7 @@ func (s *Server) getDataPoints(w http.ResponseWriter, r *http.Request) {
 
 	// Get the data points from the database
 	data, err := s.store.GetDataPoints(
+		r.Context(),
 		timeRange,
 		channelID,
 		r.FormValue("counter"))
@@ -201,7 +308,7 @@ func (s *Server) getDataPoints(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	toJSON(w, ItemResp{
+	writeItems(w, format, "datapoints", ItemResp{
 		StartHour: timeRange.StartDate(),
 		EndHour:   timeRange.EndDate(),
 		Items:     data,
@@ -209,7 +316,19 @@ func (s *Server) getDataPoints(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) getSum(w http.ResponseWriter, r *http.Request) {
-	if err := isValidParams(r, validParams, requiredParams); err != nil {
+	if err := isValidParams(r, compareParams, requiredParams); err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	format, err := formatFromRequest(r)
+	if err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	compare, err := compareFromRequest(r)
+	if err != nil {
 		abort(w, err, http.StatusBadRequest)
 		return
 	}
@@ -228,6 +347,7 @@ func (s *Server) getSum(w http.ResponseWriter, r *http.Request) {
 
 	// aggregate the data points by user
 	data, err := s.store.SumByUser(
+		r.Context(),
 		timeRange,
 		channelID,
 		r.FormValue("counter"))
@@ -235,15 +355,44 @@ func (s *Server) getSum(w http.ResponseWriter, r *http.Request) {
 		abort(w, err, http.StatusInternalServerError)
 		return
 	}
-	toJSON(w, ItemResp{
-		StartHour: timeRange.StartDate(),
-		EndHour:   timeRange.EndDate(),
-		Items:     data,
-	})
+
+	if compare == "" {
+		writeItems(w, format, "sum", ItemResp{
+			StartHour: timeRange.StartDate(),
+			EndHour:   timeRange.EndDate(),
+			Items:     data,
+		})
+		return
+	}
+
+	compareRange, err := CompareRange(timeRange, compare)
+	if err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	previous, err := s.store.SumByUser(r.Context(), compareRange, channelID, r.FormValue("counter"))
+	if err != nil {
+		abort(w, err, http.StatusInternalServerError)
+		return
+	}
+	writeItems(w, format, "sum-compare", compareResp(timeRange, compareRange, CompareSums(data, previous)))
 }
 
 func (s *Server) getPercentage(w http.ResponseWriter, r *http.Request) {
-	if err := isValidParams(r, validParams, requiredParams); err != nil {
+	if err := isValidParams(r, compareParams, requiredParams); err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	format, err := formatFromRequest(r)
+	if err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	compare, err := compareFromRequest(r)
+	if err != nil {
 		abort(w, err, http.StatusBadRequest)
 		return
 	}
@@ -260,17 +409,46 @@ func (s *Server) getPercentage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results, err := s.store.PercentageByUser(timeRange, channelID, r.FormValue("counter"))
+	results, err := s.store.PercentageByUser(r.Context(), timeRange, channelID, r.FormValue("counter"))
 	if err != nil {
 		abort(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	toJSON(w, ItemResp{
-		StartHour: timeRange.StartDate(),
-		EndHour:   timeRange.EndDate(),
-		Items:     results,
-	})
+	if compare == "" {
+		writeItems(w, format, "percentage", ItemResp{
+			StartHour: timeRange.StartDate(),
+			EndHour:   timeRange.EndDate(),
+			Items:     results,
+		})
+		return
+	}
+
+	compareRange, err := CompareRange(timeRange, compare)
+	if err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	previous, err := s.store.PercentageByUser(r.Context(), compareRange, channelID, r.FormValue("counter"))
+	if err != nil {
+		abort(w, err, http.StatusInternalServerError)
+		return
+	}
+	writeItems(w, format, "percentage-compare",
+		compareResp(timeRange, compareRange, ComparePercentages(results, previous)))
+}
+
+func compareResp(timeRange, compareRange *TimeRange, items []CompareResp) ItemResp {
+	return ItemResp{
+		StartHour:        timeRange.StartDate(),
+		EndHour:          timeRange.EndDate(),
+		Items:            items,
+		CompareStartHour: compareRange.StartDate(),
+		CompareEndHour:   compareRange.EndDate(),
+		Appeared:         usersWithStatus(items, UserAppeared),
+		Disappeared:      usersWithStatus(items, UserDisappeared),
+	}
 }
 
 func (s *Server) chartPercentage(w http.ResponseWriter, r *http.Request) {
@@ -291,10 +469,7 @@ func (s *Server) chartPercentage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-	if err := RenderPercentage(s.store, w, timeRange, channelID, r.FormValue("counter")); err != nil {
-		abort(w, err, http.StatusInternalServerError)
-	}
+	s.writeChart(w, r, "chart-percentage", RenderPercentage, timeRange, channelID, r.FormValue("counter"))
 }
 
 func (s *Server) chartSum(w http.ResponseWriter, r *http.Request) {
@@ -315,10 +490,200 @@ func (s *Server) chartSum(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.writeChart(w, r, "chart-sum", RenderSum, timeRange, channelID, r.FormValue("counter"))
+}
+
+// Render the chart to the client, charts are cached if the store provides a cache
+func (s *Server) writeChart(w http.ResponseWriter, r *http.Request, name string, render RenderFunc,
+	timeRange *TimeRange, channelID, counter string) {
+
+	var cache *QueryCache
+	if cacher, ok := s.store.(Cacher); ok {
+		cache = cacher.QueryCache()
+	}
+
+	cacheKey := cache.Key(name, timeRange, channelID, counter)
+	png, ok := cache.Get(cacheKey)
+	if !ok {
+		var buf bytes.Buffer
+		if err := render(r.Context(), s.store, &buf, timeRange, channelID, counter); err != nil {
+			abort(w, err, http.StatusInternalServerError)
+			return
+		}
+		png = buf.Bytes()
+		cache.Add(cacheKey, png)
+	}
+
 	w.Header().Set("Content-Type", "image/png")
-	if err := RenderSum(s.store, w, timeRange, channelID, r.FormValue("counter")); err != nil {
+	w.Write(png.([]byte))
+}
+
+func (s *Server) previewReport(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.generateReport(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(InlineImages(report.Data.Html, report.Data.Images))
+}
+
+// Generate the report for the 'channel', 'start-hour' and 'end-hour' parameters. If
+// neither hour is provided the report covers the report duration for the channel.
+func (s *Server) generateReport(w http.ResponseWriter, r *http.Request) (ChannelReport, bool) {
+	if err := isValidParams(r, []string{"channel", "start-hour", "end-hour"}, []string{"channel"}); err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return ChannelReport{}, false
+	}
+
+	if s.reporter == nil {
+		abort(w, errors.New("reports are not enabled"), http.StatusNotImplemented)
+		return ChannelReport{}, false
+	}
+
+	if _, err := s.idMgr.GetChannelID(strings.TrimPrefix(r.FormValue("channel"), "#")); err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return ChannelReport{}, false
+	}
+
+	var timeRange *TimeRange
+	if r.FormValue("start-hour") != "" || r.FormValue("end-hour") != "" {
+		var err error
+		timeRange, err = NewTimeRange(r.FormValue("start-hour"), r.FormValue("end-hour"))
+		if err != nil {
+			abort(w, err, http.StatusBadRequest)
+			return ChannelReport{}, false
+		}
+	}
+
+	report, err := s.reporter.Generate(r.FormValue("channel"), timeRange)
+	if err != nil {
+		abort(w, err, http.StatusInternalServerError)
+		return ChannelReport{}, false
+	}
+	return report, true
+}
+
+type ReportsResp struct {
+	Items []ArchivedReport `json:"items"`
+}
+
+func (s *Server) listReports(w http.ResponseWriter, r *http.Request) {
+	if err := isValidParams(r, []string{"channel"}, []string{}); err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	archiver, ok := s.store.(ReportArchiver)
+	if !ok {
+		abort(w, errors.New("configured store does not archive reports"), http.StatusNotImplemented)
+		return
+	}
+
+	var channelID string
+	if channel := r.FormValue("channel"); channel != "" {
+		var err error
+		channelID, err = s.idMgr.GetChannelID(strings.TrimPrefix(channel, "#"))
+		if err != nil {
+			abort(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	reports, err := archiver.ListReports(r.Context(), channelID)
+	if err != nil {
+		abort(w, err, http.StatusInternalServerError)
+		return
+	}
+	if reports == nil {
+		reports = []ArchivedReport{}
+	}
+	toJSON(w, ReportsResp{Items: reports})
+}
+
+// Serve an archived report with the charts inlined
+func (s *Server) archivedReport(w http.ResponseWriter, r *http.Request) {
+	archiver, ok := s.store.(ReportArchiver)
+	if !ok {
+		abort(w, errors.New("configured store does not archive reports"), http.StatusNotImplemented)
+		return
+	}
+
+	report, err := archiver.GetReport(r.Context(), chi.URLParam(r, "id"))
+	if err == ErrReportNotFound {
+		abort(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		abort(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(InlineImages(report.Html, report.Images))
+}
+
+func (s *Server) getAnomalies(w http.ResponseWriter, r *http.Request) {
+	if err := isValidParams(r, []string{"start-hour", "end-hour", "channel"}, []string{}); err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if s.detector == nil {
+		abort(w, errors.New("anomaly detection is not enabled; set anomaly.enabled = true"), http.StatusNotImplemented)
+		return
+	}
+
+	var channelID string
+	if channel := r.FormValue("channel"); channel != "" {
+		var err error
+		channelID, err = s.idMgr.GetChannelID(strings.TrimPrefix(channel, "#"))
+		if err != nil {
+			abort(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	timeRange, err := NewTimeRange(r.FormValue("start-hour"), r.FormValue("end-hour"))
+	if err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	toJSON(w, ItemResp{
+		StartHour: timeRange.StartDate(),
+		EndHour:   timeRange.EndDate(),
+		Items:     s.detector.Anomalies(timeRange, channelID),
+	})
+}
+
+func (s *Server) getAlerts(w http.ResponseWriter, r *http.Request) {
+	if err := isValidParams(r, []string{"rule", "channel", "status"}, []string{}); err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if s.alerter == nil {
+		abort(w, errors.New("alerting is not enabled; configure alert.rules"), http.StatusNotImplemented)
+		return
+	}
+
+	status := r.FormValue("status")
+	if status != "" && status != AlertFiring && status != AlertResolved {
+		abort(w, fmt.Errorf("invalid 'status' must be one of '%s,%s'", AlertFiring, AlertResolved),
+			http.StatusBadRequest)
+		return
+	}
+
+	toJSON(w, s.alerter.Status(r.FormValue("rule"), strings.TrimPrefix(r.FormValue("channel"), "#"), status))
+}
+
+func (s *Server) getCacheStats(w http.ResponseWriter, r *http.Request) {
+	cacher, ok := s.store.(Cacher)
+	if !ok {
+		abort(w, errors.New("configured store does not cache queries"), http.StatusNotImplemented)
+		return
 	}
+	toJSON(w, cacher.QueryCache().Stats())
 }
 
 func abort(w http.ResponseWriter, err error, code int) {
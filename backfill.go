@@ -0,0 +1,280 @@
+package channelstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Number of messages requested per page of history
+	backfillPageSize = 200
+	// Wait this long when slack rate limits us without providing 'Retry-After'
+	defaultRetryAfter = time.Second
+	// How often the events counted live which are older than the look-back are forgotten
+	pruneEventsInterval = time.Hour
+)
+
+// A page of messages returned by conversations.history and conversations.replies
+type slackHistoryPage struct {
+	Ok       bool        `json:"ok"`
+	Error    string      `json:"error"`
+	Messages []slack.Msg `json:"messages"`
+	HasMore  bool        `json:"has_more"`
+	Metadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+type backfillRequest struct {
+	channelID string
+	latest    time.Time
+}
+
+// Counts the history of channels the bot joins, such that a channel does not start at zero
+type Backfiller struct {
+	log     *logrus.Entry
+	conf    Config
+	store   Deduper
+	idMgr   IDManager
+	client  *http.Client
+	queue   chan backfillRequest
+	done    chan struct{}
+	wg      sync.WaitGroup
+	enabled bool
+}
+
+func NewBackfiller(conf Config, store Storer, idMgr IDManager) *Backfiller {
+	b := &Backfiller{
+		log:     GetLogger().WithField("prefix", "backfill"),
+		conf:    conf,
+		idMgr:   idMgr,
+		client:  &http.Client{Timeout: time.Second * 30},
+		queue:   make(chan backfillRequest, 100),
+		done:    make(chan struct{}),
+		enabled: conf.Backfill.Enabled,
+	}
+
+	// Live events are recorded so neither backfill nor slack redelivering an event counts it twice
+	if pruner, ok := store.(EventPruner); ok {
+		b.wg.Add(1)
+		go b.pruneEvents(pruner)
+	}
+
+	if !b.enabled {
+		return b
+	}
+
+	deduper, ok := store.(Deduper)
+	if !ok {
+		b.log.Error("backfill disabled; configured store does not support importing history")
+		b.enabled = false
+		return b
+	}
+	b.store = deduper
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			select {
+			case req := <-b.queue:
+				b.log.Infof("Backfilling channel '%s'", req.channelID)
+				stats, err := b.BackfillChannel(req.channelID, req.latest)
+				if err != nil {
+					b.log.Errorf("while backfilling channel '%s': %s", req.channelID, err)
+					continue
+				}
+				b.log.Infof("Backfilled channel '%s'; counted %d messages and %d reactions, %d already counted",
+					req.channelID, stats.Messages, stats.Reactions, stats.Duplicates)
+			case <-b.done:
+				return
+			}
+		}
+	}()
+	return b
+}
+
+// Queue the channel to be backfilled, only messages posted before now are counted
+// as messages after now are counted by the bot as they arrive.
+func (b *Backfiller) Backfill(channelID string) {
+	if !b.enabled {
+		return
+	}
+
+	select {
+	case b.queue <- backfillRequest{channelID: channelID, latest: time.Now()}:
+	default:
+		b.log.Errorf("backfill queue full; channel '%s' will not be backfilled", channelID)
+	}
+}
+
+// Forget the events counted live once they are older than the look-back, as backfill will never see them
+func (b *Backfiller) pruneEvents(pruner EventPruner) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(pruneEventsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.done:
+			return
+		}
+
+		pruned, err := pruner.PruneEvents(context.Background(), time.Now().Add(-b.conf.Backfill.LookBack.Duration))
+		if err != nil {
+			b.log.Errorf("while pruning events: %s", err)
+			continue
+		}
+		b.log.Debugf("Pruned %d events older than the look-back", pruned)
+	}
+}
+
+func (b *Backfiller) Stop() {
+	close(b.done)
+	b.wg.Wait()
+}
+
+// Count the messages, thread replies and reactions in the channel history
+// between the configured look back and 'latest'
+func (b *Backfiller) BackfillChannel(channelID string, latest time.Time) (ImportStats, error) {
+	var stats ImportStats
+	oldest := latest.Add(-b.conf.Backfill.LookBack.Duration)
+
+	params := url.Values{}
+	params.Set("channel", channelID)
+	params.Set("oldest", slackTimeStamp(oldest))
+	params.Set("latest", slackTimeStamp(latest))
+
+	err := b.eachPage("conversations.history", params, func(msg slack.Msg) error {
+		if err := b.count(&stats, channelID, msg); err != nil {
+			return err
+		}
+
+		// Count the replies if the message is the parent of a thread
+		if msg.ReplyCount == 0 || msg.ThreadTimestamp != msg.Timestamp {
+			return nil
+		}
+
+		params := url.Values{}
+		params.Set("channel", channelID)
+		params.Set("ts", msg.ThreadTimestamp)
+		params.Set("latest", slackTimeStamp(latest))
+
+		return b.eachPage("conversations.replies", params, func(reply slack.Msg) error {
+			// The parent message is included in the replies
+			if reply.Timestamp == msg.Timestamp {
+				return nil
+			}
+			return b.count(&stats, channelID, reply)
+		})
+	})
+	if err == nil {
+		stats.Channels = 1
+	}
+	return stats, err
+}
+
+// Like the slack bot, only count messages from users slack knows about
+func (b *Backfiller) count(stats *ImportStats, channelID string, msg slack.Msg) error {
+	if msg.Type != "message" {
+		stats.Skipped++
+		return nil
+	}
+	if _, err := b.idMgr.GetUserName(msg.User); err != nil {
+		stats.Skipped++
+		return nil
+	}
+	return stats.count(b.store, channelID, msg)
+}
+
+// Call fn() for each message returned by the slack API method, requesting each page until
+// slack indicates there are no more messages.
+func (b *Backfiller) eachPage(method string, params url.Values, fn func(slack.Msg) error) error {
+	params.Set("limit", strconv.Itoa(backfillPageSize))
+
+	for {
+		page, err := b.getPage(method, params)
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range page.Messages {
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+
+		if !page.HasMore || page.Metadata.NextCursor == "" {
+			return nil
+		}
+		params.Set("cursor", page.Metadata.NextCursor)
+	}
+}
+
+// Fetch a single page from the slack API, waiting and retrying as long as slack rate limits the request
+func (b *Backfiller) getPage(method string, params url.Values) (slackHistoryPage, error) {
+	var page slackHistoryPage
+	url := fmt.Sprintf("%s/%s?%s", b.conf.Slack.APIURL, method, params.Encode())
+
+	for {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return page, errors.Wrapf(err, "while creating request for '%s'", method)
+		}
+		req.Header.Set("Authorization", "Bearer "+b.conf.Slack.Token)
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return page, errors.Wrapf(err, "GET '%s' failed", method)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			wait := retryAfter(resp)
+			b.log.Debugf("Rate limited by slack during '%s'; retrying in %s", method, wait)
+			select {
+			case <-time.After(wait):
+				continue
+			case <-b.done:
+				return page, errors.Errorf("GET '%s' cancelled while rate limited", method)
+			}
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return page, errors.Wrapf(err, "GET '%s' failed with '%d' during json decode", method, resp.StatusCode)
+		}
+
+		// Handle slack error
+		if !page.Ok {
+			return page, errors.Errorf("GET '%s' failed with slack error '%s'", method, page.Error)
+		}
+		return page, nil
+	}
+}
+
+// Returns how long slack asked us to wait before retrying a rate limited request
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Convert a time into a slack timestamp like '1544659200.000100'
+func slackTimeStamp(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/int(time.Microsecond))
+}
@@ -0,0 +1,144 @@
+package channelstats_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestBackfill(t *testing.T) {
+	suite.Run(t, new(BackfillSuite))
+}
+
+type BackfillSuite struct {
+	suite.Suite
+	server      *httptest.Server
+	store       channelstats.Storer
+	backfill    *channelstats.Backfiller
+	rateLimited int32
+}
+
+var fakeHistory = map[string]string{
+	"": `{"ok": true, "has_more": true, "response_metadata": {"next_cursor": "page-2"}, "messages": [
+		{"type": "message", "user": "U1", "text": "hello", "ts": "1544662800.000200",
+			"reactions": [{"name": "smile", "users": ["U2"], "count": 1}]},
+		{"type": "message", "subtype": "bot_message", "bot_id": "B1", "text": "beep", "ts": "1544662800.000100"}
+	]}`,
+	"page-2": `{"ok": true, "has_more": false, "messages": [
+		{"type": "message", "user": "U2", "text": "a thread", "ts": "1544659200.000100",
+			"thread_ts": "1544659200.000100", "reply_count": 2}
+	]}`,
+}
+
+const fakeReplies = `{"ok": true, "has_more": false, "messages": [
+	{"type": "message", "user": "U2", "text": "a thread", "ts": "1544659200.000100", "thread_ts": "1544659200.000100"},
+	{"type": "message", "user": "U1", "text": "a reply", "ts": "1544659200.000300", "thread_ts": "1544659200.000100"},
+	{"type": "message", "user": "U9", "text": "unknown user", "ts": "1544659200.000400", "thread_ts": "1544659200.000100"}
+]}`
+
+func (s *BackfillSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/conversations.history", func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("Bearer xoxb-test", r.Header.Get("Authorization"))
+		s.Equal("C1", r.FormValue("channel"))
+		s.NotEmpty(r.FormValue("oldest"))
+		s.NotEmpty(r.FormValue("latest"))
+
+		// Rate limit the first request
+		if atomic.CompareAndSwapInt32(&s.rateLimited, 0, 1) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, fakeHistory[r.FormValue("cursor")])
+	})
+	mux.HandleFunc("/conversations.replies", func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("1544659200.000100", r.FormValue("ts"))
+		fmt.Fprint(w, fakeReplies)
+	})
+	s.server = httptest.NewServer(mux)
+}
+
+func (s *BackfillSuite) TearDownSuite() {
+	s.server.Close()
+}
+
+func (s *BackfillSuite) SetupTest() {
+	var conf channelstats.Config
+	conf.Store.Backend = "memory"
+	conf.Slack.Token = "xoxb-test"
+	conf.Slack.APIURL = s.server.URL
+	conf.Backfill.Enabled = true
+	conf.Backfill.LookBack.Duration = time.Hour * 24
+
+	idMgr := &channelstats.MockIDManage{
+		UserByID: map[string]string{"U1": "joe", "U2": "scott"},
+	}
+
+	var err error
+	s.store, err = channelstats.NewStore(conf, idMgr)
+	s.Require().NoError(err)
+	s.backfill = channelstats.NewBackfiller(conf, s.store, idMgr)
+	atomic.StoreInt32(&s.rateLimited, 0)
+}
+
+func (s *BackfillSuite) TearDownTest() {
+	s.backfill.Stop()
+}
+
+func (s *BackfillSuite) TestBackfillChannel() {
+	latest := time.Date(2018, 12, 13, 12, 0, 0, 0, time.UTC)
+
+	stats, err := s.backfill.BackfillChannel("C1", latest)
+	s.Require().NoError(err)
+	s.Equal(channelstats.ImportStats{Channels: 1, Messages: 3, Reactions: 1, Skipped: 2}, stats)
+	s.Equal(int32(1), atomic.LoadInt32(&s.rateLimited))
+
+	sums, err := s.store.SumByUser(context.Background(), s.timeRange(), "C1", "messages")
+	s.Require().NoError(err)
+	s.Equal([]channelstats.SumResp{{User: "scott", Sum: 1}, {User: "joe", Sum: 2}}, sums)
+
+	// Backfilling the same channel again should not count anything twice
+	stats, err = s.backfill.BackfillChannel("C1", latest)
+	s.Require().NoError(err)
+	s.Equal(channelstats.ImportStats{Channels: 1, Duplicates: 4, Skipped: 2}, stats)
+}
+
+func (s *BackfillSuite) TestBackfillSkipsCountedLive() {
+	// The message and its reaction were counted live before the bot reconnected
+	s.Require().NoError(s.store.HandleMessage(&slack.MessageEvent{
+		Msg: slack.Msg{Timestamp: "1544662800.000200", Channel: "C1", User: "U1", Text: "hello"},
+	}))
+	reaction := &slack.ReactionAddedEvent{User: "U2", Reaction: "smile", EventTimestamp: "1544662900.000100"}
+	reaction.Item.Channel = "C1"
+	reaction.Item.Timestamp = "1544662800.000200"
+	s.Require().NoError(s.store.HandleReactionAdded(reaction))
+
+	stats, err := s.backfill.BackfillChannel("C1", time.Date(2018, 12, 13, 12, 0, 0, 0, time.UTC))
+	s.Require().NoError(err)
+	s.Equal(channelstats.ImportStats{Channels: 1, Messages: 2, Duplicates: 2, Skipped: 2}, stats)
+
+	sums, err := s.store.SumByUser(context.Background(), s.timeRange(), "C1", "messages")
+	s.Require().NoError(err)
+	s.Equal([]channelstats.SumResp{{User: "scott", Sum: 1}, {User: "joe", Sum: 2}}, sums)
+
+	sums, err = s.store.SumByUser(context.Background(), s.timeRange(), "C1", "emoji")
+	s.Require().NoError(err)
+	s.Equal([]channelstats.SumResp{{User: "scott", Sum: 1}}, sums)
+}
+
+func (s *BackfillSuite) timeRange() *channelstats.TimeRange {
+	timeRange, err := channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T23")
+	s.Require().NoError(err)
+	return timeRange
+}
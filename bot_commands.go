@@ -0,0 +1,302 @@
+package channelstats
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// How long a command has to query the store, post the answer and upload the charts
+const botCommandTimeout = time.Second * 30
+
+// Matches messages which begin by mentioning a user like '<@U1234> top emoji'
+var mentionRegex = regexp.MustCompile(`^<@([A-Z0-9]+)(\|[^>]*)?>:?\s*(.*)$`)
+
+// A command users run by mentioning the bot, like '@channel-stats top emoji this week'
+type BotCommand struct {
+	// The words which invoke the command, like 'my stats'
+	Name string
+	// The arguments the command accepts, shown by 'help'
+	Usage string
+	Desc  string
+	Run   func(ctx context.Context, req CommandRequest) (CommandResponse, error)
+}
+
+type CommandRequest struct {
+	// The user who ran the command
+	UserID string
+	// The channel the command was run in
+	ChannelID string
+	// The arguments which follow the command name
+	Args CommandArgs
+}
+
+type CommandResponse struct {
+	Message SlackMessage
+	// Charts uploaded after the message is posted
+	Charts []CommandChart
+}
+
+type CommandChart struct {
+	Filename string
+	Title    string
+	Render   func(ctx context.Context, w io.Writer) error
+}
+
+// Answers commands users send by mentioning the bot. The answer is posted to the channel, or
+// the thread if the command was sent in a thread. All methods on a nil *Commander are no-ops.
+type Commander struct {
+	log      *logrus.Entry
+	store    Storer
+	idMgr    IDManager
+	api      *SlackAPI
+	commands []BotCommand
+	wg       sync.WaitGroup
+
+	mutex sync.Mutex
+	botID string
+}
+
+func NewCommander(conf Config, store Storer, idMgr IDManager) *Commander {
+	c := &Commander{
+		log:   GetLogger().WithField("prefix", "commands"),
+		store: store,
+		idMgr: idMgr,
+		api:   NewSlackAPI(conf),
+	}
+
+	c.Register(BotCommand{
+		Name:  "top",
+		Usage: "[counter] [#channel] [period]",
+		Desc:  "Lists the users with the highest count, for example `top emoji this week`",
+		Run:   c.top,
+	})
+	c.Register(BotCommand{
+		Name:  "my stats",
+		Usage: "[#channel] [period]",
+		Desc:  "Shows your count for every counter",
+		Run:   c.myStats,
+	})
+	c.Register(BotCommand{
+		Name:  "compare",
+		Usage: "#channel #channel [counter] [period]",
+		Desc:  "Compares the totals of two or more channels",
+		Run:   c.compare,
+	})
+	c.Register(BotCommand{
+		Name: "help",
+		Desc: "Shows this message",
+		Run:  c.help,
+	})
+	return c
+}
+
+// Register a command, it is listed by 'help' in the order it was registered
+func (c *Commander) Register(cmd BotCommand) {
+	c.commands = append(c.commands, cmd)
+}
+
+// Answer the message if it is a command to the bot, returns false if the message is not a command.
+// The command is run in the background such that the bot can continue handling events.
+func (c *Commander) Handle(ev *slack.MessageEvent) bool {
+	if c == nil || ev.SubType != "" || !strings.HasPrefix(ev.Text, "<@") {
+		return false
+	}
+
+	m := mentionRegex.FindStringSubmatch(ev.Text)
+	if m == nil {
+		return false
+	}
+
+	botID := c.botUserID()
+	if botID == "" || m[1] != botID || ev.User == botID {
+		return false
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.run(ev, m[3])
+	}()
+	return true
+}
+
+// Wait for running commands to complete
+func (c *Commander) Stop() {
+	if c == nil {
+		return
+	}
+	c.wg.Wait()
+}
+
+func (c *Commander) run(ev *slack.MessageEvent, text string) {
+	ctx, cancel := context.WithTimeout(context.Background(), botCommandTimeout)
+	defer cancel()
+
+	resp, err := c.answer(ctx, ev, text)
+	if err != nil {
+		resp = CommandResponse{Message: SlackMessage{Text: err.Error()}}
+	}
+
+	resp.Message.Channel = ev.Channel
+	resp.Message.ThreadTS = ev.ThreadTimestamp
+	if _, err := c.api.PostMessage(resp.Message); err != nil {
+		c.log.Errorf("while answering '%s': %s", text, err)
+		return
+	}
+
+	for _, chart := range resp.Charts {
+		var buf bytes.Buffer
+		if err := chart.Render(ctx, &buf); err != nil {
+			c.log.Errorf("while rendering chart '%s': %s", chart.Filename, err)
+			continue
+		}
+		if err := c.api.UploadFile(ev.Channel, ev.ThreadTimestamp, chart.Filename, chart.Title, buf.Bytes()); err != nil {
+			c.log.Errorf("while uploading chart '%s': %s", chart.Filename, err)
+		}
+	}
+}
+
+// Find the command and run it, errors the user can correct are returned as an error
+func (c *Commander) answer(ctx context.Context, ev *slack.MessageEvent, text string) (CommandResponse, error) {
+	cmd, rest := c.match(text)
+	if cmd == nil {
+		return CommandResponse{}, errors.Errorf("I don't know how to '%s', try `help`", text)
+	}
+
+	args, err := ParseCommandArgs(rest, c.idMgr, time.Now())
+	if err != nil {
+		return CommandResponse{}, errors.Errorf("%s\nUsage: `%s`", err, strings.TrimSpace(cmd.Name+" "+cmd.Usage))
+	}
+	if len(args.Channels) == 0 {
+		args.Channels = []string{ev.Channel}
+	}
+
+	resp, err := cmd.Run(ctx, CommandRequest{UserID: ev.User, ChannelID: ev.Channel, Args: args})
+	if err != nil {
+		c.log.Errorf("while running '%s': %s", text, err)
+		return CommandResponse{}, errors.New("Sorry, something went wrong while fetching those stats")
+	}
+	return resp, nil
+}
+
+// Returns the command with the longest name matching the start of the text, and the remaining
+// text. An empty text matches 'help'.
+func (c *Commander) match(text string) (*BotCommand, string) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		words = []string{"help"}
+	}
+
+	var result *BotCommand
+	var rest []string
+	for i, cmd := range c.commands {
+		name := strings.Fields(cmd.Name)
+		if len(name) > len(words) || (result != nil && len(name) <= len(strings.Fields(result.Name))) {
+			continue
+		}
+		if strings.EqualFold(strings.Join(words[:len(name)], " "), strings.Join(name, " ")) {
+			result, rest = &c.commands[i], words[len(name):]
+		}
+	}
+	return result, strings.Join(rest, " ")
+}
+
+// Set the user id of the bot, commands are not answered until it is known. The RTM
+// connection provides the id once connected, other modes fetch it when the bot starts,
+// such that handling events never waits on slack.
+func (c *Commander) SetBotUserID(id string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.botID = id
+}
+
+func (c *Commander) botUserID() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.botID
+}
+
+func (c *Commander) top(ctx context.Context, req CommandRequest) (CommandResponse, error) {
+	msg, err := TopUsersMessage(ctx, c.store, c.idMgr, req.Args)
+	if err != nil {
+		return CommandResponse{}, err
+	}
+
+	args := req.Args
+	channelID := args.Channels[0]
+	return CommandResponse{
+		Message: msg,
+		Charts: []CommandChart{{
+			Filename: args.Counter + ".png",
+			Title:    fmt.Sprintf("%s in #%s for %s", args.Counter, channelName(c.idMgr, channelID), args.Period),
+			Render: func(ctx context.Context, w io.Writer) error {
+				return renderFor(args.Counter)(ctx, c.store, w, args.TimeRange, channelID, args.Counter)
+			},
+		}},
+	}, nil
+}
+
+func (c *Commander) myStats(ctx context.Context, req CommandRequest) (CommandResponse, error) {
+	msg, err := UserStatsMessage(ctx, c.store, c.idMgr, req.Args, req.UserID)
+	return CommandResponse{Message: msg}, err
+}
+
+func (c *Commander) compare(ctx context.Context, req CommandRequest) (CommandResponse, error) {
+	args := req.Args
+	if len(args.Channels) < 2 {
+		return CommandResponse{Message: SlackMessage{
+			Text: "Which channels should I compare? Usage: `compare #channel #channel [counter] [period]`",
+		}}, nil
+	}
+
+	totals, err := ChannelTotals(ctx, c.store, args.TimeRange, args.Channels, args.Counter)
+	if err != nil {
+		return CommandResponse{}, err
+	}
+
+	title := fmt.Sprintf("Total %s for %s", args.Counter, args.Period)
+	var fields []TextObject
+	for _, total := range totals {
+		fields = append(fields, *markdown(fmt.Sprintf("*#%s*\n%d", channelName(c.idMgr, total.ChannelID), total.Sum)))
+	}
+
+	return CommandResponse{
+		Message: SlackMessage{
+			Text:   title,
+			Blocks: []Block{sectionBlock("*" + title + "*"), {Type: "section", Fields: fields}},
+		},
+		Charts: []CommandChart{{
+			Filename: "compare.png",
+			Title:    title,
+			Render: func(ctx context.Context, w io.Writer) error {
+				return RenderChannelTotals(ctx, c.store, c.idMgr, w, args.TimeRange, args.Channels, args.Counter)
+			},
+		}},
+	}, nil
+}
+
+func (c *Commander) help(ctx context.Context, req CommandRequest) (CommandResponse, error) {
+	lines := []string{"Mention me with one of these commands:"}
+	for _, cmd := range c.commands {
+		lines = append(lines, fmt.Sprintf("• `%s` %s", strings.TrimSpace(cmd.Name+" "+cmd.Usage), cmd.Desc))
+	}
+	lines = append(lines, fmt.Sprintf("Counters: %s", strings.Join(validCounters, ", ")),
+		"Periods: `24h`, `7d`, `2w`, `today`, `this week` or `this month`")
+
+	text := strings.Join(lines, "\n")
+	return CommandResponse{Message: SlackMessage{Text: text, Blocks: []Block{sectionBlock(text)}}}, nil
+}
@@ -0,0 +1,180 @@
+package channelstats_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestBotCommands(t *testing.T) {
+	suite.Run(t, new(BotCommandsSuite))
+}
+
+type BotCommandsSuite struct {
+	suite.Suite
+	store     channelstats.Storer
+	commander *channelstats.Commander
+	slack     *httptest.Server
+	posts     chan channelstats.SlackMessage
+	uploads   chan upload
+}
+
+func (s *BotCommandsSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *BotCommandsSuite) SetupTest() {
+	s.posts = make(chan channelstats.SlackMessage, 10)
+	s.uploads = make(chan upload, 10)
+
+	// A fake slack web API
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+		var msg channelstats.SlackMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_json"}`)
+			return
+		}
+		s.posts <- msg
+		fmt.Fprint(w, `{"ok": true, "ts": "1544659200.000100"}`)
+	})
+	mux.HandleFunc("/files.upload", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_form_data"}`)
+			return
+		}
+		s.uploads <- upload{Channel: r.FormValue("channels"), Filename: r.FormValue("filename")}
+		fmt.Fprint(w, `{"ok": true}`)
+	})
+	s.slack = httptest.NewServer(mux)
+
+	var conf channelstats.Config
+	conf.Store.Backend = "memory"
+	conf.Slack.APIURL = s.slack.URL
+
+	idMgr := &channelstats.MockIDManage{UserByID: map[string]string{"U1": "joe", "U2": "scott"}}
+
+	var err error
+	s.store, err = channelstats.NewStore(conf, idMgr)
+	s.Require().NoError(err)
+	s.commander = channelstats.NewCommander(conf, s.store, idMgr)
+	s.commander.SetBotUserID("UBOT")
+
+	now := time.Now()
+	for i, msg := range []slack.Msg{
+		{Channel: "C1", User: "U1", Text: "http://example.com"},
+		{Channel: "C1", User: "U1", Text: "http://example.com"},
+		{Channel: "C1", User: "U2", Text: "hello"},
+		{Channel: "C2", User: "U2", Text: "hello"},
+	} {
+		msg.Timestamp = fmt.Sprintf("%d.%06d", now.Unix(), i)
+		s.Require().NoError(s.store.HandleMessage(&slack.MessageEvent{Msg: msg}))
+	}
+}
+
+func (s *BotCommandsSuite) TearDownTest() {
+	s.commander.Stop()
+	s.slack.Close()
+	s.store.Close()
+}
+
+func (s *BotCommandsSuite) mention(text, threadTS string) bool {
+	return s.commander.Handle(&slack.MessageEvent{
+		Msg: slack.Msg{Channel: "C1", User: "U1", Text: text, ThreadTimestamp: threadTS},
+	})
+}
+
+func (s *BotCommandsSuite) post() channelstats.SlackMessage {
+	select {
+	case msg := <-s.posts:
+		return msg
+	case <-time.After(time.Second * 10):
+		s.FailNow("timed out waiting for chat.postMessage")
+	}
+	return channelstats.SlackMessage{}
+}
+
+func (s *BotCommandsSuite) upload() upload {
+	select {
+	case u := <-s.uploads:
+		return u
+	case <-time.After(time.Second * 10):
+		s.FailNow("timed out waiting for files.upload")
+	}
+	return upload{}
+}
+
+func (s *BotCommandsSuite) TestTop() {
+	s.Require().True(s.mention("<@UBOT> top link this week", ""))
+
+	msg := s.post()
+	s.Equal("C1", msg.Channel)
+	s.Equal("", msg.ThreadTS)
+	s.Require().Len(msg.Blocks, 3)
+	s.Equal("Top link in #general", msg.Blocks[0].Text.Text)
+	s.Equal("1. *joe* — 2", msg.Blocks[1].Text.Text)
+	s.Equal("For this week", msg.Blocks[2].Elements[0].Text)
+
+	s.Equal(upload{Channel: "C1", Filename: "link.png"}, s.upload())
+}
+
+func (s *BotCommandsSuite) TestAnswersInThread() {
+	s.Require().True(s.mention("<@UBOT> my stats", "1544659100.000100"))
+
+	msg := s.post()
+	s.Equal("1544659100.000100", msg.ThreadTS)
+	s.Require().Len(msg.Blocks, 1)
+	s.Contains(msg.Blocks[0].Fields, channelstats.TextObject{Type: "mrkdwn", Text: "*messages*\n2"})
+}
+
+func (s *BotCommandsSuite) TestCompare() {
+	s.Require().True(s.mention("<@UBOT> compare <#C1|general> <#C2|random> messages 24h", ""))
+
+	msg := s.post()
+	s.Require().Len(msg.Blocks, 2)
+	s.Equal("*Total messages for the last 24 hours*", msg.Blocks[0].Text.Text)
+	s.Len(msg.Blocks[1].Fields, 2)
+	s.Equal(upload{Channel: "C1", Filename: "compare.png"}, s.upload())
+}
+
+func (s *BotCommandsSuite) TestHelp() {
+	s.Require().True(s.mention("<@UBOT>", ""))
+
+	msg := s.post()
+	s.Contains(msg.Text, "`top [counter] [#channel] [period]`")
+	s.Contains(msg.Text, "`my stats [#channel] [period]`")
+	s.Contains(msg.Text, "`compare #channel #channel [counter] [period]`")
+	s.Contains(msg.Text, "`help`")
+}
+
+func (s *BotCommandsSuite) TestErrors() {
+	s.Require().True(s.mention("<@UBOT> dance", ""))
+	s.Equal("I don't know how to 'dance', try `help`", s.post().Text)
+
+	s.Require().True(s.mention("<@UBOT> top bogus", ""))
+	s.Contains(s.post().Text, "Usage: `top [counter] [#channel] [period]`")
+}
+
+func (s *BotCommandsSuite) TestIgnoresMentionsUntilConnected() {
+	s.commander.SetBotUserID("")
+	s.False(s.mention("<@UBOT> top", ""))
+
+	s.commander.SetBotUserID("UBOT")
+	s.True(s.mention("<@UBOT> help", ""))
+	s.post()
+}
+
+func (s *BotCommandsSuite) TestIgnoresOtherMessages() {
+	s.False(s.mention("<@U2> top", ""))
+	s.False(s.mention("top <@UBOT>", ""))
+	s.False(s.commander.Handle(&slack.MessageEvent{
+		Msg: slack.Msg{Channel: "C1", User: "UBOT", Text: "<@UBOT> top"},
+	}))
+}
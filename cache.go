@@ -0,0 +1,160 @@
+package channelstats
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/holster"
+)
+
+// The number of channel hours QueryCache tracks the last write of before it invalidates the entire
+// cache and starts over. Live messages only write to the current hour of each channel, such that the
+// limit is reached after months of activity, or by backfilling and importing history.
+const queryCacheMaxWrites = 100000
+
+// Any Storer which caches query results, allows callers to cache
+// values derived from queries (like rendered charts) in the same cache
+type Cacher interface {
+	QueryCache() *QueryCache
+}
+
+type CacheStats struct {
+	// Number of items currently in the cache
+	Size int `json:"size"`
+	// Number of lookups that were found in the cache
+	Hits int64 `json:"hits"`
+	// Number of lookups that were not found in the cache
+	Misses int64 `json:"misses"`
+}
+
+// QueryCache caches query results with keys that include the last write generation
+// of every hour the query covers. A write to a channel/hour bumps the generation, so
+// any cached result which included that hour is never looked up again and ages out.
+// All methods are safe to call on a nil *QueryCache, which caches nothing.
+//
+// Cached values are shared by every caller which looks up the same key, such as the []SumResp
+// and []PercentageResp slices the stores return. Callers must not modify cached values, copy
+// them first.
+type QueryCache struct {
+	cache  *holster.LRUCache
+	ttl    time.Duration
+	hits   int64
+	misses int64
+
+	mutex sync.Mutex
+	// Incremented on every write
+	generation uint64
+	// Incremented when the entire cache is invalidated
+	epoch uint64
+	// The generation of the last write to each hour by channel id
+	writes map[string]map[string]uint64
+	// The number of hours in writes
+	tracked int
+}
+
+func NewQueryCache(size int, ttl time.Duration) *QueryCache {
+	return &QueryCache{
+		cache:  holster.NewLRUCache(size),
+		writes: make(map[string]map[string]uint64),
+		ttl:    ttl,
+	}
+}
+
+// Returns a cache key for the query which changes when any hour in the time range is written to
+func (c *QueryCache) Key(query string, timeRange *TimeRange, channelID, counter string) string {
+	if c == nil {
+		return ""
+	}
+
+	c.mutex.Lock()
+	var version uint64
+	hours := c.writes[channelID]
+	for _, hour := range timeRange.ByHour() {
+		if hours[hour] > version {
+			version = hours[hour]
+		}
+	}
+	key := fmt.Sprintf("%s/%s/%s/%s/%d/%d", query, timeRange.String(), channelID, counter, version, c.epoch)
+	c.mutex.Unlock()
+	return key
+}
+
+// Invalidate any cached queries which include this hour of the channel. Must be
+// called after the write is committed.
+func (c *QueryCache) Invalidate(channelID, hour string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	hours, ok := c.writes[channelID]
+	if !ok {
+		hours = make(map[string]uint64)
+		c.writes[channelID] = hours
+	}
+	if _, ok := hours[hour]; !ok {
+		c.tracked++
+	}
+	c.generation++
+	hours[hour] = c.generation
+
+	// Forgetting a write could make a query which was cached before the write current again,
+	// so the writes are only forgotten along with every cached query
+	if c.tracked > queryCacheMaxWrites {
+		c.reset()
+	}
+}
+
+// Invalidate every cached query, used when data is written outside of normal
+// message handling (like restoring a backup)
+func (c *QueryCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.reset()
+}
+
+func (c *QueryCache) reset() {
+	c.epoch++
+	c.writes = make(map[string]map[string]uint64)
+	c.tracked = 0
+}
+
+func (c *QueryCache) Get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	item, ok := c.cache.Get(key)
+	if ok {
+		atomic.AddInt64(&c.hits, 1)
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+	}
+	return item, ok
+}
+
+func (c *QueryCache) Add(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.cache.AddWithTTL(key, value, c.ttl)
+}
+
+func (c *QueryCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Size:   c.cache.Size(),
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+	}
+}
@@ -0,0 +1,96 @@
+package channelstats_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestQueryCache(t *testing.T) {
+	suite.Run(t, new(QueryCacheSuite))
+}
+
+type QueryCacheSuite struct {
+	suite.Suite
+	cache     *channelstats.QueryCache
+	timeRange *channelstats.TimeRange
+}
+
+func (s *QueryCacheSuite) SetupTest() {
+	var err error
+	s.cache = channelstats.NewQueryCache(100, time.Minute)
+	s.timeRange, err = channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T05")
+	s.Require().NoError(err)
+}
+
+func (s *QueryCacheSuite) TestWriteInRangeInvalidates() {
+	key := s.cache.Key("sum", s.timeRange, "C1", "messages")
+	s.cache.Add(key, "value")
+
+	item, ok := s.cache.Get(s.cache.Key("sum", s.timeRange, "C1", "messages"))
+	s.True(ok)
+	s.Equal("value", item)
+
+	s.cache.Invalidate("C1", "2018-12-13T03")
+	_, ok = s.cache.Get(s.cache.Key("sum", s.timeRange, "C1", "messages"))
+	s.False(ok)
+}
+
+func (s *QueryCacheSuite) TestWriteOutOfRangeDoesNotInvalidate() {
+	key := s.cache.Key("sum", s.timeRange, "C1", "messages")
+	s.cache.Add(key, "value")
+
+	s.cache.Invalidate("C1", "2018-12-14T03")
+	s.cache.Invalidate("C2", "2018-12-13T03")
+	_, ok := s.cache.Get(s.cache.Key("sum", s.timeRange, "C1", "messages"))
+	s.True(ok)
+}
+
+func (s *QueryCacheSuite) TestInvalidateAll() {
+	s.cache.Add(s.cache.Key("sum", s.timeRange, "C1", "messages"), "value")
+
+	s.cache.InvalidateAll()
+	_, ok := s.cache.Get(s.cache.Key("sum", s.timeRange, "C1", "messages"))
+	s.False(ok)
+}
+
+func (s *QueryCacheSuite) TestTrackedWritesAreBounded() {
+	s.cache.Add(s.cache.Key("sum", s.timeRange, "C1", "messages"), "value")
+
+	// Writes to hours outside the range, until the cache forgets the writes and every query with them
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 100000; i++ {
+		s.cache.Invalidate("C2", start.Add(time.Hour*time.Duration(i)).Format(channelstats.RFC3339Short))
+	}
+	_, ok := s.cache.Get(s.cache.Key("sum", s.timeRange, "C1", "messages"))
+	s.True(ok)
+
+	s.cache.Invalidate("C2", "1999-12-31T23")
+	_, ok = s.cache.Get(s.cache.Key("sum", s.timeRange, "C1", "messages"))
+	s.False(ok)
+}
+
+func (s *QueryCacheSuite) TestStats() {
+	key := s.cache.Key("sum", s.timeRange, "C1", "messages")
+	s.cache.Get(key)
+	s.cache.Add(key, "value")
+	s.cache.Get(key)
+	s.cache.Get(key)
+
+	stats := s.cache.Stats()
+	s.Equal(int64(2), stats.Hits)
+	s.Equal(int64(1), stats.Misses)
+	s.Equal(1, stats.Size)
+}
+
+func (s *QueryCacheSuite) TestNilCache() {
+	var cache *channelstats.QueryCache
+	key := cache.Key("sum", s.timeRange, "C1", "messages")
+	cache.Add(key, "value")
+	cache.Invalidate("C1", "2018-12-13T03")
+	_, ok := cache.Get(key)
+	s.False(ok)
+	s.Equal(channelstats.CacheStats{}, cache.Stats())
+}
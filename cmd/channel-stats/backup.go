@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thrawn01/channel-stats"
+)
+
+// Write a backup of the database to a file, either by opening the local
+// database directly or by asking a running instance via /admin/backup
+func backup(conf channelstats.Config, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := flags.String("out", "", "file the backup is written to (required)")
+	since := flags.Uint64("since", 0, "only include data newer than this version (incremental backup)")
+	url := flags.String("url", "", "backup a running instance instead of the local database (e.g. http://localhost:2020)")
+	flags.Parse(args)
+
+	if *out == "" {
+		return errors.New("backup requires --out")
+	}
+
+	fd, err := os.Create(*out)
+	if err != nil {
+		return errors.Wrapf(err, "while creating backup file '%s'", *out)
+	}
+	defer fd.Close()
+
+	var version uint64
+	if *url != "" {
+		version, err = remoteBackup(conf, *url, *since, fd)
+	} else {
+		version, err = localBackup(conf, *since, fd)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup written to '%s'; use '--since %d' for the next incremental backup\n", *out, version)
+	return nil
+}
+
+func localBackup(conf channelstats.Config, since uint64, w io.Writer) (uint64, error) {
+	store, err := channelstats.NewStore(conf, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "while opening the local database (use --url if channel-stats is running)")
+	}
+	defer store.Close()
+
+	backuper, ok := store.(channelstats.Backuper)
+	if !ok {
+		return 0, errors.New("configured store does not support backups")
+	}
+	return backuper.Backup(w, since)
+}
+
+func remoteBackup(conf channelstats.Config, url string, since uint64, w io.Writer) (uint64, error) {
+	url = fmt.Sprintf("%s/admin/backup?since=%d", strings.TrimSuffix(url, "/"), since)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, errors.Wrapf(err, "while creating request for '%s'", url)
+	}
+	req.Header.Set("Authorization", "Bearer "+conf.Admin.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "GET '%s' failed", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return 0, errors.Errorf("GET '%s' failed with '%d': %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return 0, errors.Wrap(err, "while writing backup")
+	}
+
+	// The trailer is only sent if the server completed the backup
+	version := resp.Trailer.Get(channelstats.BackupVersionHeader)
+	if version == "" {
+		return 0, errors.New("server did not complete the backup; backup file is incomplete")
+	}
+	return strconv.ParseUint(version, 10, 64)
+}
+
+// Load a backup file into the local database, channel-stats must not be running
+func restore(conf channelstats.Config, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := flags.String("in", "", "backup file to restore from (required)")
+	flags.Parse(args)
+
+	if *in == "" {
+		return errors.New("restore requires --in")
+	}
+
+	fd, err := os.Open(*in)
+	if err != nil {
+		return errors.Wrapf(err, "while opening backup file '%s'", *in)
+	}
+	defer fd.Close()
+
+	store, err := channelstats.NewStore(conf, nil)
+	if err != nil {
+		return errors.Wrap(err, "while opening the local database (is channel-stats still running?)")
+	}
+	defer store.Close()
+
+	backuper, ok := store.(channelstats.Backuper)
+	if !ok {
+		return errors.New("configured store does not support restore")
+	}
+
+	if err := backuper.Restore(fd); err != nil {
+		return err
+	}
+	fmt.Printf("Restored backup from '%s'\n", *in)
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/thrawn01/channel-stats"
+)
+
+// Write query results from the local database as JSON, CSV or Parquet
+func export(conf channelstats.Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "-", "file the export is written to, '-' writes to stdout")
+	format := flags.String("format", channelstats.FormatCSV, "output format one of 'json', 'csv' or 'parquet'")
+	query := flags.String("query", "datapoints", "what to export one of 'datapoints', 'sum' or 'percentage'")
+	channel := flags.String("channel", "", "only export this channel (required for 'sum' and 'percentage')")
+	counter := flags.String("counter", "", "only export this counter (required for 'sum' and 'percentage')")
+	startHour := flags.String("start-hour", "", "export starting at this hour (e.g. 2018-12-13T00)")
+	endHour := flags.String("end-hour", "", "export ending at this hour (e.g. 2018-12-13T23)")
+	offline := flags.Bool("offline", false, "do not connect to slack; users and channels are exported "+
+		"by id and --channel must be a channel id")
+	flags.Parse(args)
+
+	// Validate the hours without defaulting a missing hour, as a 'datapoints' export may be unbounded
+	if *startHour != "" || *endHour != "" {
+		start, end := *startHour, *endHour
+		if start == "" {
+			start = end
+		}
+		if end == "" {
+			end = start
+		}
+		if _, err := channelstats.NewTimeRange(start, end); err != nil {
+			return errors.Wrap(err, "invalid --start-hour or --end-hour")
+		}
+	}
+
+	// The ID manager resolves channel and user names
+	var idMgr channelstats.IDManager = &channelstats.OfflineIDManager{}
+	var err error
+	if !*offline {
+		if idMgr, err = channelstats.NewIdManager(conf); err != nil {
+			return errors.Wrap(err, "while fetching names from slack (use --offline to export ids)")
+		}
+	}
+
+	store, err := channelstats.NewStore(conf, idMgr)
+	if err != nil {
+		return errors.Wrap(err, "while opening the local database (is channel-stats running?)")
+	}
+	defer store.Close()
+
+	var channelID string
+	if *channel != "" {
+		if channelID, err = idMgr.GetChannelID(*channel); err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		fd, err := os.Create(*out)
+		if err != nil {
+			return errors.Wrapf(err, "while creating export file '%s'", *out)
+		}
+		defer fd.Close()
+		w = fd
+	}
+
+	ctx := context.Background()
+	var rw channelstats.RowWriter
+
+	switch *query {
+	case "datapoints":
+		if rw, err = channelstats.NewRowWriter(w, *format, channelstats.DataPoint{}); err != nil {
+			return err
+		}
+		filter := channelstats.DataPointFilter{
+			ChannelID: channelID,
+			Counter:   *counter,
+			StartHour: *startHour,
+			EndHour:   *endHour,
+		}
+		err = store.ForEach(ctx, filter, func(dp channelstats.DataPoint) error {
+			return rw.Write(dp)
+		})
+	case "sum", "percentage":
+		if channelID == "" || *counter == "" {
+			return errors.Errorf("'%s' export requires --channel and --counter", *query)
+		}
+		timeRange, err := channelstats.NewTimeRange(*startHour, *endHour)
+		if err != nil {
+			return err
+		}
+
+		var items []interface{}
+		var sample interface{}
+		if *query == "sum" {
+			sample = channelstats.SumResp{}
+			results, err := store.SumByUser(ctx, timeRange, channelID, *counter)
+			if err != nil {
+				return err
+			}
+			for _, item := range results {
+				items = append(items, item)
+			}
+		} else {
+			sample = channelstats.PercentageResp{}
+			results, err := store.PercentageByUser(ctx, timeRange, channelID, *counter)
+			if err != nil {
+				return err
+			}
+			for _, item := range results {
+				items = append(items, item)
+			}
+		}
+
+		if rw, err = channelstats.NewRowWriter(w, *format, sample); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := rw.Write(item); err != nil {
+				return errors.Wrap(err, "while exporting")
+			}
+		}
+	default:
+		return errors.Errorf("invalid --query '%s'; must be one of 'datapoints', 'sum' or 'percentage'", *query)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "while exporting")
+	}
+	if err := rw.Close(); err != nil {
+		return err
+	}
+
+	if *out != "-" {
+		fmt.Printf("Export written to '%s'\n", *out)
+	}
+	return nil
+}
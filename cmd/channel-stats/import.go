@@ -0,0 +1,60 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thrawn01/channel-stats"
+)
+
+// Count the history in a slack workspace export archive into the local database
+func importSlack(conf channelstats.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	archive := flags.String("slack-export", "", "slack workspace export archive to import (required)")
+	channels := flags.String("channels", "", "comma separated list of channel names to import, imports all channels if empty")
+	until := flags.String("until", "", "ignore messages at or after this hour (e.g. 2018-12-13T00), "+
+		"use the hour channel-stats started counting to avoid counting messages twice")
+	flags.Parse(args)
+
+	if *archive == "" {
+		return errors.New("import requires --slack-export")
+	}
+
+	var opts channelstats.ImportOptions
+	if *channels != "" {
+		opts.Channels = strings.Split(*channels, ",")
+	}
+	if *until != "" {
+		var err error
+		opts.Until, err = time.Parse(channelstats.RFC3339Short, *until)
+		if err != nil {
+			return errors.Wrapf(err, "--until is not in the format '%s'", channelstats.RFC3339Short)
+		}
+	}
+
+	zr, err := zip.OpenReader(*archive)
+	if err != nil {
+		return errors.Wrapf(err, "while opening slack export '%s'", *archive)
+	}
+	defer zr.Close()
+
+	store, err := channelstats.NewStore(conf, nil)
+	if err != nil {
+		return errors.Wrap(err, "while opening the local database (is channel-stats running?)")
+	}
+	defer store.Close()
+
+	stats, err := channelstats.ImportSlackExport(context.Background(), store, &zr.Reader, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d messages and %d reactions from %d channels; %d already imported, %d skipped\n",
+		stats.Messages, stats.Reactions, stats.Channels, stats.Duplicates, stats.Skipped)
+	return nil
+}
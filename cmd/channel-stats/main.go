@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -27,6 +28,34 @@ func main() {
 	// Initialize our logging config
 	channelstats.InitLogging(conf)
 
+	// Run a sub command if requested instead of the bot
+	switch flag.Arg(0) {
+	case "":
+	case "backup":
+		checkErr(backup(conf, flag.Args()[1:]))
+		return
+	case "restore":
+		checkErr(restore(conf, flag.Args()[1:]))
+		return
+	case "export":
+		checkErr(export(conf, flag.Args()[1:]))
+		return
+	case "import":
+		checkErr(importSlack(conf, flag.Args()[1:]))
+		return
+	case "replay":
+		checkErr(replay(conf, flag.Args()[1:]))
+		return
+	case "reindex":
+		checkErr(reindex(conf, flag.Args()[1:]))
+		return
+	case "report":
+		checkErr(report(conf, flag.Args()[1:]))
+		return
+	default:
+		checkErr(fmt.Errorf("unknown command '%s'", flag.Arg(0)))
+	}
+
 	channelstats.GetLogger().Infof("Starting Version: %s", Version)
 
 	// Can mailer an operator of events
@@ -64,11 +93,20 @@ func main() {
 	reporter, err := channelstats.NewReporter(conf, idMgr, mail, store)
 	checkErr(err)
 
+	// Detects unusual channel activity, nil if anomaly detection is not enabled
+	detector, err := channelstats.NewAnomalyDetector(conf, idMgr, store, mail)
+	checkErr(err)
+
+	// Evaluates the alert rules, nil if there are no alert rules
+	alerter, err := channelstats.NewAlerter(conf, idMgr, store, mail)
+	checkErr(err)
+
 	// Start the slack bot
 	bot := channelstats.NewSlackBot(conf, store, idMgr, mail)
 
 	// Start the http server
-	server := channelstats.NewServer(store, idMgr)
+	server := channelstats.NewServer(conf, store, idMgr, bot, reporter, detector, alerter)
+	server.Start()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -82,6 +120,10 @@ func main() {
 				bot.Stop()
 				// Stop the reporter
 				reporter.Stop()
+				// Stop the anomaly detector
+				detector.Stop()
+				// Stop evaluating alert rules
+				alerter.Stop()
 				//os.Exit(1)
 			}
 		}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/thrawn01/channel-stats"
+)
+
+// Recompute a counter from the event journal
+func reindex(conf channelstats.Config, args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	counter := flags.String("counter", "", "the counter to recompute (required)")
+	since := flags.String("since", "", "recompute the counter for every hour at or after this hour (e.g. 2018-12-13T00) (required)")
+	flags.Parse(args)
+
+	if *counter == "" || *since == "" {
+		return errors.New("reindex requires --counter and --since")
+	}
+
+	start, err := time.Parse(channelstats.RFC3339Short, *since)
+	if err != nil {
+		return errors.Wrapf(err, "--since is not in the format '%s'", channelstats.RFC3339Short)
+	}
+
+	journal, err := channelstats.NewJournal(conf)
+	if err != nil {
+		return err
+	}
+	if journal == nil {
+		return errors.New("reindex requires the event journal; set journal.enabled = true")
+	}
+	defer journal.Close()
+
+	store, err := channelstats.NewStore(conf, nil)
+	if err != nil {
+		return errors.Wrap(err, "while opening the local database (is channel-stats running?)")
+	}
+	defer store.Close()
+
+	stats, err := channelstats.Reindex(context.Background(), store, journal, *counter, start)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recomputed '%s' from %d journaled events; added %d data points\n",
+		*counter, stats.Events, stats.DataPoints)
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/thrawn01/channel-stats"
+)
+
+// Count the events from a recording made with 'slack.record-file' without connecting to slack
+func replay(conf channelstats.Config, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := flags.String("file", "", "the event recording to replay (required)")
+	speed := flags.String("speed", "1x", "replay speed relative to the recording (e.g. '10x') or 'max' to replay without waiting")
+	flags.Parse(args)
+
+	if *file == "" {
+		return errors.New("replay requires --file")
+	}
+
+	multiplier, err := channelstats.ParseReplaySpeed(*speed)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.Open(*file)
+	if err != nil {
+		return errors.Wrapf(err, "while opening event recording '%s'", *file)
+	}
+	defer fd.Close()
+
+	store, err := channelstats.NewStore(conf, nil)
+	if err != nil {
+		return errors.Wrap(err, "while opening the local database (is channel-stats running?)")
+	}
+	defer store.Close()
+
+	count, err := channelstats.ReplayRecording(context.Background(), conf, store, fd, multiplier)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Replayed %d events from '%s'\n", count, *file)
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/thrawn01/channel-stats"
+)
+
+// Generate the report for a channel now and send it, or write it to a file with --dry-run
+func report(conf channelstats.Config, args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	channel := flags.String("channel", "", "the channel to generate the report for (required)")
+	startHour := flags.String("start-hour", "", "the report starts at this hour (e.g. 2018-12-13T00) "+
+		"(defaults to the report duration)")
+	endHour := flags.String("end-hour", "", "the report ends at this hour (defaults to now)")
+	dryRun := flags.Bool("dry-run", false, "write the report HTML to --output instead of sending the report")
+	output := flags.String("output", "", "file the --dry-run report HTML is written to (defaults to stdout)")
+	flags.Parse(args)
+
+	if *channel == "" {
+		return errors.New("report requires --channel")
+	}
+
+	var timeRange *channelstats.TimeRange
+	if *startHour != "" || *endHour != "" {
+		var err error
+		timeRange, err = channelstats.NewTimeRange(*startHour, *endHour)
+		if err != nil {
+			return err
+		}
+	}
+
+	mail, err := channelstats.NewMailer(conf)
+	if err != nil {
+		return err
+	}
+
+	idMgr, err := channelstats.NewIdManager(conf)
+	if err != nil {
+		return err
+	}
+
+	store, err := channelstats.NewStore(conf, idMgr)
+	if err != nil {
+		return errors.Wrap(err, "while opening the local database (is channel-stats running?)")
+	}
+	defer store.Close()
+
+	reporter := channelstats.NewManualReporter(conf, idMgr, mail, store)
+
+	r, err := reporter.Generate(*channel, timeRange)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		html := channelstats.InlineImages(r.Data.Html, r.Data.Images)
+		if *output == "" {
+			_, err := os.Stdout.Write(html)
+			return err
+		}
+		if err := ioutil.WriteFile(*output, html, 0644); err != nil {
+			return errors.Wrapf(err, "while writing '%s'", *output)
+		}
+		fmt.Printf("Wrote report for '%s' from %s to '%s'\n", r.Channel.Name, r.TimeRange, *output)
+		return nil
+	}
+
+	if err := reporter.Send(r); err != nil {
+		return err
+	}
+	fmt.Printf("Sent report for '%s' from %s\n", r.Channel.Name, r.TimeRange)
+	return nil
+}
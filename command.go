@@ -0,0 +1,247 @@
+package channelstats
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailgun/holster/slice"
+	"github.com/pkg/errors"
+)
+
+const (
+	// The counter and period used when a command does not specify one
+	defaultCommandCounter = "messages"
+	defaultCommandPeriod  = time.Hour * 24 * 7
+	// Number of users listed by commands which rank users
+	commandTopUsers = 5
+)
+
+var (
+	// Channels appear as '<#C1234|general>' when slack escapes them and as '#general' when it does not
+	escapedChannelRegex = regexp.MustCompile(`^<#([A-Z0-9]+)(\|([^>]*))?>$`)
+	durationRegex       = regexp.MustCompile(`^(\d+)([hdw])$`)
+)
+
+// The arguments common to the stats commands, parsed from '#general messages 7d'
+type CommandArgs struct {
+	// Channel ids in the order they were given
+	Channels []string
+	Counter  string
+	// The period requested, or 'defaultCommandPeriod' ending now
+	TimeRange *TimeRange
+	// A human readable description of the period like 'the last 7 days'
+	Period string
+	// The user asked about themselves ('me' or 'my')
+	Me bool
+}
+
+// Parse the command arguments, channels are resolved to ids using the id manager. Periods are either
+// a duration like '24h', '7d' or '2w' or one of 'today', 'this week' or 'this month'.
+func ParseCommandArgs(text string, idMgr IDManager, now time.Time) (CommandArgs, error) {
+	args := CommandArgs{Counter: defaultCommandCounter}
+	now = now.UTC()
+
+	tokens := strings.Fields(text)
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		lower := strings.ToLower(token)
+
+		switch {
+		case lower == "me" || lower == "my":
+			args.Me = true
+		case strings.HasPrefix(token, "#") || strings.HasPrefix(token, "<#"):
+			id, err := parseChannel(token, idMgr)
+			if err != nil {
+				return args, err
+			}
+			args.Channels = append(args.Channels, id)
+		case slice.ContainsString(lower, validCounters, nil):
+			args.Counter = lower
+		case lower == "today":
+			args.setPeriod(startOfDay(now), now, "today")
+		case lower == "this" && i+1 < len(tokens):
+			i++
+			switch strings.ToLower(tokens[i]) {
+			case "week":
+				start := startOfDay(now)
+				// Weeks start on monday
+				start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+				args.setPeriod(start, now, "this week")
+			case "month":
+				args.setPeriod(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), now, "this month")
+			default:
+				return args, errors.Errorf("unknown period 'this %s'; expected 'this week' or 'this month'", tokens[i])
+			}
+		case durationRegex.MatchString(lower):
+			duration, period := parseDuration(lower)
+			if duration <= 0 {
+				return args, errors.Errorf("invalid period '%s'", token)
+			}
+			args.setPeriod(now.Add(-duration), now, period)
+		default:
+			return args, errors.Errorf("I don't understand '%s'; expected a #channel, a counter (%s) or a period like '7d'",
+				token, strings.Join(validCounters, ", "))
+		}
+	}
+
+	if args.TimeRange == nil {
+		args.setPeriod(now.Add(-defaultCommandPeriod), now, "the last 7 days")
+	}
+	return args, nil
+}
+
+func (a *CommandArgs) setPeriod(start, end time.Time, period string) {
+	a.TimeRange = &TimeRange{Start: start, End: end}
+	a.Period = period
+}
+
+func parseChannel(token string, idMgr IDManager) (string, error) {
+	if m := escapedChannelRegex.FindStringSubmatch(token); m != nil {
+		return m[1], nil
+	}
+
+	name := strings.TrimPrefix(token, "#")
+	id, err := idMgr.GetChannelID(name)
+	if err != nil {
+		return "", errors.Errorf("unknown channel '%s'", token)
+	}
+	return id, nil
+}
+
+// Returns the duration and a description like 'the last 7 days'
+func parseDuration(token string) (time.Duration, string) {
+	m := durationRegex.FindStringSubmatch(token)
+	count, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, ""
+	}
+
+	unit, size := "hour", time.Hour
+	switch m[2] {
+	case "d":
+		unit, size = "day", time.Hour*24
+	case "w":
+		unit, size = "week", time.Hour*24*7
+	}
+
+	if count == 1 {
+		return size, "the last " + unit
+	}
+	return time.Duration(count) * size, fmt.Sprintf("the last %d %ss", count, unit)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Returns at most 'limit' users with the highest sum for the counter, highest first
+func TopUsers(ctx context.Context, store Storer, timeRange *TimeRange, channelID, counter string, limit int) ([]SumResp, error) {
+	totals, err := store.SumByUser(ctx, timeRange, channelID, counter)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SumResp
+	for i := len(totals) - 1; i >= 0 && len(results) < limit; i-- {
+		results = append(results, totals[i])
+	}
+	return results, nil
+}
+
+type ChannelTotal struct {
+	ChannelID string
+	Sum       int64
+}
+
+// Returns the total of the counter for each channel, in the order the channels were given
+func ChannelTotals(ctx context.Context, store Storer, timeRange *TimeRange, channels []string, counter string) ([]ChannelTotal, error) {
+	var results []ChannelTotal
+	for _, channelID := range channels {
+		totals, err := store.SumByUser(ctx, timeRange, channelID, counter)
+		if err != nil {
+			return nil, err
+		}
+		total := ChannelTotal{ChannelID: channelID}
+		for _, item := range totals {
+			total.Sum += item.Sum
+		}
+		results = append(results, total)
+	}
+	return results, nil
+}
+
+// Lists the users with the highest count for the counter in the first channel of the arguments
+func TopUsersMessage(ctx context.Context, store Storer, idMgr IDManager, args CommandArgs) (SlackMessage, error) {
+	channelID := args.Channels[0]
+	top, err := TopUsers(ctx, store, args.TimeRange, channelID, args.Counter, commandTopUsers)
+	if err != nil {
+		return SlackMessage{}, err
+	}
+
+	title := fmt.Sprintf("Top %s in #%s", args.Counter, channelName(idMgr, channelID))
+	var lines []string
+	for i, item := range top {
+		lines = append(lines, fmt.Sprintf("%d. *%s* — %d", i+1, item.User, item.Sum))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, fmt.Sprintf("No %s counted for %s", args.Counter, args.Period))
+	}
+
+	return SlackMessage{
+		Text: title,
+		Blocks: []Block{
+			headerBlock(title),
+			sectionBlock(strings.Join(lines, "\n")),
+			contextBlock(fmt.Sprintf("For %s", args.Period)),
+		},
+	}, nil
+}
+
+// Shows the users count for every counter in the first channel of the arguments
+func UserStatsMessage(ctx context.Context, store Storer, idMgr IDManager, args CommandArgs, userID string) (SlackMessage, error) {
+	channelID := args.Channels[0]
+	userName, err := idMgr.GetUserName(userID)
+	if err != nil {
+		return SlackMessage{}, err
+	}
+
+	title := fmt.Sprintf("Your stats in #%s", channelName(idMgr, channelID))
+	section := Block{Type: "section", Text: markdown(fmt.Sprintf("*%s* for %s", title, args.Period))}
+	for _, counter := range validCounters {
+		totals, err := store.SumByUser(ctx, args.TimeRange, channelID, counter)
+		if err != nil {
+			return SlackMessage{}, err
+		}
+		var sum int64
+		for _, item := range totals {
+			if item.User == userName {
+				sum = item.Sum
+			}
+		}
+		section.Fields = append(section.Fields, *markdown(fmt.Sprintf("*%s*\n%d", counter, sum)))
+	}
+
+	return SlackMessage{Text: title, Blocks: []Block{section}}, nil
+}
+
+// Returns the channel name or the id if the name is not known
+func channelName(idMgr IDManager, id string) string {
+	name, err := idMgr.GetChannelName(id)
+	if err != nil {
+		return id
+	}
+	return name
+}
+
+// Returns the render function used to chart the counter
+func renderFor(counter string) RenderFunc {
+	switch counter {
+	case "positive", "negative":
+		return RenderPercentage
+	}
+	return RenderSum
+}
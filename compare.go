@@ -0,0 +1,123 @@
+package channelstats
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mailgun/holster/slice"
+)
+
+const (
+	// Compare to the period of the same length immediately before the time range
+	ComparePrevious = "previous"
+	// Compare to the same time range a year earlier
+	CompareYear = "year"
+
+	// The user has a value in the time range but not in the compared range
+	UserAppeared = "appeared"
+	// The user has a value in the compared range but not in the time range
+	UserDisappeared = "disappeared"
+
+	compareDesc = "compare to the 'previous' period of the same length or the same period a 'year' earlier"
+)
+
+var validCompares = []string{ComparePrevious, CompareYear}
+
+// The value of a counter for a user compared to the value in the compared range
+type CompareResp struct {
+	User string `json:"user"`
+	CounterTotal
+	// One of 'appeared' or 'disappeared' if the user only has a value in one of the ranges
+	Status string `json:"status,omitempty"`
+}
+
+// Returns the time range to compare the time range to. As time ranges include the end
+// hour the previous period ends the hour before the time range starts.
+func CompareRange(timeRange *TimeRange, compare string) (*TimeRange, error) {
+	switch compare {
+	case ComparePrevious:
+		end := timeRange.Start.Add(-time.Hour)
+		return &TimeRange{Start: end.Add(-timeRange.End.Sub(timeRange.Start)), End: end}, nil
+	case CompareYear:
+		return &TimeRange{Start: timeRange.Start.AddDate(-1, 0, 0), End: timeRange.End.AddDate(-1, 0, 0)}, nil
+	}
+	return nil, fmt.Errorf("invalid 'compare' must be one of '%s'", strings.Join(validCompares, ","))
+}
+
+// Compare the sums of each user, highest current sum first
+func CompareSums(current, previous []SumResp) []CompareResp {
+	currentMap, previousMap := make(map[string]int64), make(map[string]int64)
+	for _, item := range current {
+		currentMap[item.User] = item.Sum
+	}
+	for _, item := range previous {
+		previousMap[item.User] = item.Sum
+	}
+	return compareByUser(currentMap, previousMap)
+}
+
+// Compare the percentages of each user, highest current percentage first
+func ComparePercentages(current, previous []PercentageResp) []CompareResp {
+	currentMap, previousMap := make(map[string]int64), make(map[string]int64)
+	for _, item := range current {
+		currentMap[item.User] = item.Percent
+	}
+	for _, item := range previous {
+		previousMap[item.User] = item.Percent
+	}
+	return compareByUser(currentMap, previousMap)
+}
+
+func compareByUser(current, previous map[string]int64) []CompareResp {
+	var results []CompareResp
+	for user, value := range current {
+		item := CompareResp{User: user, CounterTotal: newCounterTotal(value, previous[user])}
+		if _, ok := previous[user]; !ok {
+			item.Status = UserAppeared
+		}
+		results = append(results, item)
+	}
+	for user, value := range previous {
+		if _, ok := current[user]; !ok {
+			results = append(results, CompareResp{
+				User:         user,
+				CounterTotal: newCounterTotal(0, value),
+				Status:       UserDisappeared,
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Value != results[j].Value {
+			return results[i].Value > results[j].Value
+		}
+		if results[i].Previous != results[j].Previous {
+			return results[i].Previous > results[j].Previous
+		}
+		return results[i].User < results[j].User
+	})
+	return results
+}
+
+// Returns the names of the users with the status
+func usersWithStatus(items []CompareResp, status string) []string {
+	var results []string
+	for _, item := range items {
+		if item.Status == status {
+			results = append(results, item.User)
+		}
+	}
+	return results
+}
+
+// Returns the 'compare' parameter, or an empty string if the request does not compare
+func compareFromRequest(r *http.Request) (string, error) {
+	compare := strings.ToLower(r.FormValue("compare"))
+	if compare != "" && !slice.ContainsString(compare, validCompares, nil) {
+		return "", fmt.Errorf("invalid 'compare' must be one of '%s'", strings.Join(validCompares, ","))
+	}
+	return compare, nil
+}
@@ -0,0 +1,74 @@
+package channelstats_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestCompare(t *testing.T) {
+	suite.Run(t, new(CompareSuite))
+}
+
+type CompareSuite struct {
+	suite.Suite
+}
+
+func (s *CompareSuite) TestCompareRange() {
+	timeRange, err := channelstats.NewTimeRange("2018-12-06T00", "2018-12-12T23")
+	s.Require().NoError(err)
+
+	previous, err := channelstats.CompareRange(timeRange, channelstats.ComparePrevious)
+	s.Require().NoError(err)
+	s.Equal("2018-11-29T00 to 2018-12-05T23", previous.String())
+	s.Len(previous.ByHour(), len(timeRange.ByHour()))
+
+	year, err := channelstats.CompareRange(timeRange, channelstats.CompareYear)
+	s.Require().NoError(err)
+	s.Equal("2017-12-06T00 to 2017-12-12T23", year.String())
+
+	_, err = channelstats.CompareRange(timeRange, "month")
+	s.EqualError(err, "invalid 'compare' must be one of 'previous,year'")
+}
+
+func (s *CompareSuite) TestCompareSums() {
+	results := channelstats.CompareSums(
+		[]channelstats.SumResp{{User: "scott", Sum: 3}, {User: "joe", Sum: 15}, {User: "kitty", Sum: 3}},
+		[]channelstats.SumResp{{User: "joe", Sum: 10}, {User: "doug", Sum: 4}, {User: "kitty", Sum: 3}},
+	)
+
+	s.Equal([]channelstats.CompareResp{
+		{User: "joe", CounterTotal: channelstats.CounterTotal{Value: 15, Previous: 10, Change: 5, PercentChange: 50}},
+		{User: "kitty", CounterTotal: channelstats.CounterTotal{Value: 3, Previous: 3}},
+		{User: "scott", CounterTotal: channelstats.CounterTotal{Value: 3, Change: 3}, Status: channelstats.UserAppeared},
+		{User: "doug", CounterTotal: channelstats.CounterTotal{Previous: 4, Change: -4, PercentChange: -100},
+			Status: channelstats.UserDisappeared},
+	}, results)
+	s.Equal("+50.0%", results[0].Delta())
+	s.Equal("no change", results[1].Delta())
+	s.Equal("new", results[2].Delta())
+	s.Equal("-100.0%", results[3].Delta())
+}
+
+func (s *CompareSuite) TestComparePercentages() {
+	results := channelstats.ComparePercentages(
+		[]channelstats.PercentageResp{{User: "joe", Total: 10, Count: 5, Percent: 50}},
+		[]channelstats.PercentageResp{{User: "joe", Total: 10, Count: 2, Percent: 20}},
+	)
+	s.Equal([]channelstats.CompareResp{
+		{User: "joe", CounterTotal: channelstats.CounterTotal{Value: 50, Previous: 20, Change: 30, PercentChange: 150}},
+	}, results)
+}
+
+func (s *CompareSuite) TestComparePreviousDay() {
+	timeRange := &channelstats.TimeRange{
+		Start: time.Date(2018, 12, 6, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2018, 12, 6, 23, 0, 0, 0, time.UTC),
+	}
+	previous, err := channelstats.CompareRange(timeRange, channelstats.ComparePrevious)
+	s.Require().NoError(err)
+	s.Equal(time.Date(2018, 12, 5, 0, 0, 0, 0, time.UTC), previous.Start)
+	s.Equal(time.Date(2018, 12, 5, 23, 0, 0, 0, time.UTC), previous.End)
+}
@@ -14,6 +14,8 @@ import (
 	"github.com/fatih/structs"
 	"github.com/ghodss/yaml"
 	"github.com/mailgun/holster"
+	"github.com/mailgun/holster/slice"
+	"github.com/robfig/cron"
 )
 
 const (
@@ -33,14 +35,62 @@ type Config struct {
 	// Mailgun configuration
 	Mailgun MailgunConfig `json:"mailgun"`
 
+	// Selects how email is sent and configures SMTP
+	Mail MailConfig `json:"mail"`
+
 	Report ReportConfig `json:"report"`
+
+	// Admin endpoint config
+	Admin AdminConfig `json:"admin"`
+
+	// Backfill history when the bot joins a channel
+	Backfill BackfillConfig `json:"backfill"`
+
+	// Journal of every counted event
+	Journal JournalConfig `json:"journal"`
+
+	// Detect unusual channel activity
+	Anomaly AnomalyConfig `json:"anomaly"`
+
+	// Notify when channel activity crosses a threshold
+	Alert AlertConfig `json:"alert"`
 }
 
 type SlackConfig struct {
 	Token string `json:"token" env:"STATS_SLACK_TOKEN"`
+
+	// How events are received from slack; one of 'rtm', 'events' or 'socket'. Defaults to 'rtm'
+	Mode string `json:"mode" env:"STATS_SLACK_MODE"`
+
+	// The app-level token (xapp-...) with the 'connections:write' scope, required if mode = 'socket'
+	AppToken string `json:"app-token" env:"STATS_SLACK_APP_TOKEN"`
+
+	// The app signing secret used to verify requests to /slack/events and /slack/commands,
+	// required if mode = 'events'. The '/stats' slash command is disabled if empty
+	SigningSecret string `json:"signing-secret" env:"STATS_SLACK_SIGNING_SECRET"`
+
+	// Base URL of the slack web API, useful for testing against a fake slack server
+	// Defaults to "https://slack.com/api"
+	APIURL string `json:"api-url" env:"STATS_SLACK_API_URL"`
+
+	// How long to wait before reconnecting after the connection to slack is lost, the wait
+	// doubles (with jitter) after each failed attempt up to 'reconnect-max'. Defaults to "1s" and "5m"
+	ReconnectMin clock.DurationJSON `json:"reconnect-min" env:"STATS_SLACK_RECONNECT_MIN"`
+	ReconnectMax clock.DurationJSON `json:"reconnect-max" env:"STATS_SLACK_RECONNECT_MAX"`
+
+	// Record every incoming RTM event to this newline delimited JSON file, such
+	// that the events can be replayed with 'channel-stats replay'. Disabled if empty
+	RecordFile string `json:"record-file" env:"STATS_SLACK_RECORD_FILE"`
 }
 
 type StoreConfig struct {
+	// The storage backend; one of 'badger', 'sqlite', 'postgres' or 'memory'. Defaults to 'badger'
+	Backend string `json:"backend" env:"STATS_STORE_BACKEND"`
+
+	// Data source name for the 'sqlite' and 'postgres' backends
+	// Defaults to "./channel-stats.db" for 'sqlite'
+	DSN string `json:"dsn" env:"STATS_STORE_DSN"`
+
 	DataDir   string             `json:"data-dir" env:"STATS_STORE_DATA_DIR"`
 	CacheSize int                `json:"cache-size" env:"STATS_STORE_CACHE_SIZE"`
 	CacheTTL  clock.DurationJSON `json:"cache-ttl" env:"STATS_STORE_CACHE_TTL"`
@@ -71,6 +121,51 @@ type MailgunConfig struct {
 	Timeout clock.DurationJSON `json:"timeout" env:"STATS_MG_TIMEOUT"`
 }
 
+type MailConfig struct {
+	// How email is sent; one of 'mailgun', 'smtp' or 'none'
+	// Defaults to 'mailgun' if mailgun.enabled = true, otherwise 'none'
+	Backend string `json:"backend" env:"STATS_MAIL_BACKEND"`
+
+	// SMTP configuration, required if backend = 'smtp'
+	SMTP SMTPConfig `json:"smtp"`
+}
+
+type SMTPConfig struct {
+	// Host name of the SMTP server
+	Host string `json:"host" env:"STATS_SMTP_HOST"`
+
+	// Defaults to 465 if tls = 'tls' otherwise 587
+	Port int `json:"port" env:"STATS_SMTP_PORT"`
+
+	// How the connection is encrypted; one of 'starttls', 'tls' (implicit TLS) or 'none'
+	// Defaults to 'starttls'
+	TLS string `json:"tls" env:"STATS_SMTP_TLS"`
+
+	// Skip verification of the server certificate, only useful for testing
+	InsecureSkipVerify bool `json:"insecure-skip-verify" env:"STATS_SMTP_INSECURE_SKIP_VERIFY"`
+
+	// Credentials used to authenticate, no authentication is attempted if username is empty
+	Username string `json:"username" env:"STATS_SMTP_USERNAME"`
+	Password string `json:"password" env:"STATS_SMTP_PASSWORD"`
+
+	// The authentication mechanism; one of 'plain' or 'login'. Defaults to 'plain'
+	Auth string `json:"auth" env:"STATS_SMTP_AUTH"`
+
+	// The from email address given when sending email
+	From string `json:"from" env:"STATS_SMTP_FROM"`
+
+	// The email address of the operator of the bot
+	OperatorAddr string `json:"operator-address" env:"STATS_SMTP_OPERATOR_ADDR"`
+
+	// The email address reports are sent to (Could be an mailing list address)
+	ReportAddr string `json:"report-address" env:"STATS_SMTP_REPORT_ADDR"`
+
+	// Timeout for sending an email
+	// (See http://golang.org/pkg/time/#ParseDuration for string format)
+	// Defaults to "20s" (20 Seconds)
+	Timeout clock.DurationJSON `json:"timeout" env:"STATS_SMTP_TIMEOUT"`
+}
+
 type ReportConfig struct {
 	// The cron like string the dictates when reports are sent to users
 	// (See https://godoc.org/github.com/robfig/cron#hdr-CRON_Expression_Format)
@@ -81,6 +176,157 @@ type ReportConfig struct {
 	// (See http://golang.org/pkg/time/#ParseDuration for string format)
 	// Defaults to "168h" aka 7 days
 	ReportDuration clock.DurationJSON `json:"report-duration" env:"STATS_REPORT_DURATION"`
+
+	// Where reports are delivered; any of 'email' or 'slack'. 'slack' posts the report to the
+	// channel the report is for, or 'slack-channel' if provided. Defaults to 'email'
+	Destinations []string `json:"destinations" env:"STATS_REPORT_DESTINATIONS"`
+
+	// The channel name or id slack reports are posted to instead of the channel the report is for
+	SlackChannel string `json:"slack-channel" env:"STATS_REPORT_SLACK_CHANNEL"`
+
+	// Path to the go html/template used to render the email report
+	// Defaults to the bundled "html/templates/email.tmpl"
+	Template string `json:"template" env:"STATS_REPORT_TEMPLATE"`
+
+	// Path to the go text/template used to render the text/plain version of the email report
+	// Defaults to the bundled "html/templates/email.txt.tmpl"
+	TextTemplate string `json:"text-template" env:"STATS_REPORT_TEXT_TEMPLATE"`
+
+	// How long archived reports are kept, reports are kept forever if not provided. Each
+	// archived report includes the rendered report, so the archive grows with every report sent
+	ArchiveRetention clock.DurationJSON `json:"archive-retention" env:"STATS_REPORT_ARCHIVE_RETENTION"`
+
+	// Report settings for individual channels keyed by channel name, overriding the settings above
+	Channels map[string]ReportChannelConfig `json:"channels"`
+}
+
+// Settings left empty default to the global report settings
+type ReportChannelConfig struct {
+	// Set to false to never send reports for this channel. Defaults to true
+	Enabled *bool `json:"enabled"`
+
+	// The cron like string that dictates when reports for this channel are sent
+	Schedule string `json:"schedule"`
+
+	// The duration used to decide the start and end hour of reports for this channel
+	ReportDuration clock.DurationJSON `json:"report-duration"`
+
+	// Where reports for this channel are delivered; any of 'email' or 'slack'
+	Destinations []string `json:"destinations"`
+
+	// The channel name or id slack reports for this channel are posted to
+	SlackChannel string `json:"slack-channel"`
+
+	// Email addresses reports for this channel are sent to instead of the mailer report address
+	Recipients []string `json:"recipients"`
+
+	// The sections included in the report; any of 'most-active', 'most-positive',
+	// 'most-negative', 'top-links' or 'top-emoji'. Defaults to all sections
+	Sections []string `json:"sections"`
+}
+
+type AdminConfig struct {
+	// The token clients must provide via 'Authorization: Bearer <token>' to access
+	// the /admin endpoints. Admin endpoints are disabled if no token is provided
+	Token string `json:"token" env:"STATS_ADMIN_TOKEN"`
+}
+
+type BackfillConfig struct {
+	// Count the channel history via conversations.history when the bot joins a channel
+	Enabled bool `json:"enabled" env:"STATS_BACKFILL_ENABLED"`
+
+	// How far back in the channel history to count
+	// (See http://golang.org/pkg/time/#ParseDuration for string format)
+	// Defaults to "720h" aka 30 days
+	LookBack clock.DurationJSON `json:"look-back" env:"STATS_BACKFILL_LOOK_BACK"`
+}
+
+type JournalConfig struct {
+	// Record every counted event so counters can be recomputed with 'channel-stats reindex'
+	Enabled bool `json:"enabled" env:"STATS_JOURNAL_ENABLED"`
+
+	// Location of the journal on disk, defaults to "./journal"
+	Dir string `json:"dir" env:"STATS_JOURNAL_DIR"`
+
+	// Record the message text, which is required to recompute counters derived from the
+	// text such as 'positive' or 'link'. The text is encrypted with the encryption key
+	IncludeText bool `json:"include-text" env:"STATS_JOURNAL_INCLUDE_TEXT"`
+
+	// Base64 encoded AES key (16, 24 or 32 bytes) used to encrypt the message text
+	// (Generate one with 'openssl rand -base64 32')
+	EncryptionKey string `json:"encryption-key" env:"STATS_JOURNAL_ENCRYPTION_KEY"`
+}
+
+type AnomalyConfig struct {
+	// Compare the hourly activity of each channel the bot is in to the same hour in previous weeks
+	Enabled bool `json:"enabled" env:"STATS_ANOMALY_ENABLED"`
+
+	// The cron like string that dictates when the last complete hour is checked
+	// Defaults to "0 5 * * * *" - Five minutes past every hour
+	Schedule string `json:"schedule" env:"STATS_ANOMALY_SCHEDULE"`
+
+	// The number of previous weeks the baseline for an hour is calculated from. Defaults to 4
+	BaselineWeeks int `json:"baseline-weeks" env:"STATS_ANOMALY_BASELINE_WEEKS"`
+
+	// How many standard deviations from the baseline mean an hour must be to be an anomaly. Defaults to 3
+	Threshold float64 `json:"threshold" env:"STATS_ANOMALY_THRESHOLD"`
+
+	// The minimum number of messages (or negative messages) an hour must have to be a spike, and the
+	// minimum baseline mean for a quiet hour to be silence. Defaults to 5
+	MinCount int `json:"min-count" env:"STATS_ANOMALY_MIN_COUNT"`
+
+	// Where anomalies are delivered; any of 'operator' or 'slack'. 'operator' notifies the operator via
+	// the mailer, 'slack' posts to 'slack-channel'. Defaults to 'operator'
+	Destinations []string `json:"destinations" env:"STATS_ANOMALY_DESTINATIONS"`
+
+	// The channel name or id anomalies are posted to, required if destinations includes 'slack'
+	SlackChannel string `json:"slack-channel" env:"STATS_ANOMALY_SLACK_CHANNEL"`
+}
+
+type AlertConfig struct {
+	// The cron like string that dictates when the rules are evaluated
+	// Defaults to "0 */5 * * * *" - Every 5 minutes
+	Schedule string `json:"schedule" env:"STATS_ALERT_SCHEDULE"`
+
+	// Alert rules can only be provided via the config file, alerting is disabled if there are no rules
+	Rules []AlertRule `json:"rules"`
+}
+
+type AlertRule struct {
+	// Identifies the rule in notifications and /api/alerts, must be unique
+	Name string `json:"name"`
+
+	// The channel name the rule measures
+	Channel string `json:"channel"`
+
+	// The counter the rule measures, e.g. 'negative'
+	Counter string `json:"counter"`
+
+	// Either the 'sum' of the counter or the 'percentage' of messages counted by the counter. Defaults to 'sum'
+	Measure string `json:"measure"`
+
+	// How far back from the current hour the rule measures. Defaults to 24h
+	Window clock.DurationJSON `json:"window"`
+
+	// Whether the alert fires when the measure is 'above' or 'below' the threshold. Defaults to 'above'
+	Condition string  `json:"condition"`
+	Threshold float64 `json:"threshold"`
+
+	// Measure each user in the channel on their own instead of the whole channel
+	PerUser bool `json:"per-user"`
+
+	// Ignore users (or the channel) with fewer messages than this during the window
+	MinMessages int64 `json:"min-messages"`
+
+	// After notifying that an alert is firing, do not notify again for the same alert until
+	// the cooldown has passed, such that flapping alerts do not flood the destinations. Defaults to 1h
+	Cooldown clock.DurationJSON `json:"cooldown"`
+
+	// Where the alert is delivered; any of 'operator' or 'slack'. Defaults to 'operator'
+	Destinations []string `json:"destinations"`
+
+	// The channel name or id the alert is posted to, required if destinations includes 'slack'
+	SlackChannel string `json:"slack-channel"`
 }
 
 func LoadConfig() (Config, error) {
@@ -110,28 +356,241 @@ func LoadConfig() (Config, error) {
 	}
 
 	if conf.Mailgun.Enabled {
+		holster.SetDefault(&conf.Mail.Backend, MailBackendMailgun)
+	}
+	holster.SetDefault(&conf.Mail.Backend, MailBackendNone)
+
+	switch conf.Mail.Backend {
+	case MailBackendNone:
+	case MailBackendMailgun:
 		// Ensure mailgun required fields are provided
 		if err := RequiredFields(conf.Mailgun, []string{"APIKey", "Domain", "From"}); err != nil {
-			return conf, fmt.Errorf("config mailgun.%s if mailgun.enabled = true", err)
+			return conf, fmt.Errorf("config mailgun.%s if mail.backend = 'mailgun'", err)
+		}
+	case MailBackendSMTP:
+		if err := RequiredFields(conf.Mail.SMTP, []string{"Host", "From"}); err != nil {
+			return conf, fmt.Errorf("config mail.smtp.%s if mail.backend = 'smtp'", err)
 		}
+		holster.SetDefault(&conf.Mail.SMTP.TLS, SMTPStartTLS)
+		holster.SetDefault(&conf.Mail.SMTP.Auth, SMTPAuthPlain)
+		if !slice.ContainsString(conf.Mail.SMTP.TLS, []string{SMTPStartTLS, SMTPImplicitTLS, SMTPNoTLS}, nil) {
+			return conf, fmt.Errorf("config mail.smtp.tls '%s' is invalid; must be one of 'starttls', 'tls' or 'none'",
+				conf.Mail.SMTP.TLS)
+		}
+		if !slice.ContainsString(conf.Mail.SMTP.Auth, []string{SMTPAuthPlain, SMTPAuthLogin}, nil) {
+			return conf, fmt.Errorf("config mail.smtp.auth '%s' is invalid; must be one of 'plain' or 'login'",
+				conf.Mail.SMTP.Auth)
+		}
+		if conf.Mail.SMTP.TLS == SMTPImplicitTLS {
+			holster.SetDefault(&conf.Mail.SMTP.Port, 465)
+		}
+		holster.SetDefault(&conf.Mail.SMTP.Port, 587)
+		holster.SetDefault(&conf.Mail.SMTP.Timeout.Duration, time.Second*20)
+	default:
+		return conf, fmt.Errorf("config mail.backend '%s' is invalid; must be one of 'mailgun', 'smtp' or 'none'",
+			conf.Mail.Backend)
 	}
 
 	if err := RequiredFields(conf.Slack, []string{"Token"}); err != nil {
 		return conf, fmt.Errorf("config slack.%s", err)
 	}
 
+	holster.SetDefault(&conf.Slack.Mode, SlackModeRTM)
+	switch conf.Slack.Mode {
+	case SlackModeRTM:
+	case SlackModeEvents:
+		if err := RequiredFields(conf.Slack, []string{"SigningSecret"}); err != nil {
+			return conf, fmt.Errorf("config slack.%s if slack.mode = 'events'", err)
+		}
+	case SlackModeSocket:
+		if err := RequiredFields(conf.Slack, []string{"AppToken"}); err != nil {
+			return conf, fmt.Errorf("config slack.%s if slack.mode = 'socket'", err)
+		}
+	default:
+		return conf, fmt.Errorf("config slack.mode '%s' is invalid; must be one of 'rtm', 'events' or 'socket'",
+			conf.Slack.Mode)
+	}
+
+	holster.SetDefault(&conf.Slack.ReconnectMin.Duration, time.Second)
+	holster.SetDefault(&conf.Slack.ReconnectMax.Duration, time.Minute*5)
+	holster.SetDefault(&conf.Slack.APIURL, "https://slack.com/api")
+	conf.Slack.APIURL = strings.TrimSuffix(conf.Slack.APIURL, "/")
+
+	holster.SetDefault(&conf.Store.Backend, "badger")
+	if conf.Store.Backend == "sqlite" {
+		holster.SetDefault(&conf.Store.DSN, "./channel-stats.db")
+	}
+	if conf.Store.Backend == "postgres" {
+		if err := RequiredFields(conf.Store, []string{"DSN"}); err != nil {
+			return conf, fmt.Errorf("config store.%s if store.backend = postgres", err)
+		}
+	}
+
 	holster.SetDefault(&conf.Store.DataDir, "./badger-db")
 	holster.SetDefault(&conf.Store.CacheTTL.Duration, time.Second*30)
 	holster.SetDefault(&conf.Store.CacheSize, 100)
 
 	holster.SetDefault(&conf.Report.Schedule, "0 0 0 * * SUN")
 	holster.SetDefault(&conf.Report.ReportDuration.Duration, time.Hour*168)
+	if len(conf.Report.Destinations) == 0 {
+		conf.Report.Destinations = []string{ReportDestinationEmail}
+	}
+	if err := checkReportConfig(conf.Report); err != nil {
+		return conf, err
+	}
 
 	holster.SetDefault(&conf.Mailgun.Timeout.Duration, time.Second*20)
 
+	holster.SetDefault(&conf.Backfill.LookBack.Duration, time.Hour*720)
+
+	holster.SetDefault(&conf.Anomaly.Schedule, "0 5 * * * *")
+	holster.SetDefault(&conf.Anomaly.BaselineWeeks, 4)
+	holster.SetDefault(&conf.Anomaly.Threshold, 3.0)
+	holster.SetDefault(&conf.Anomaly.MinCount, 5)
+	if len(conf.Anomaly.Destinations) == 0 {
+		conf.Anomaly.Destinations = []string{NotifyOperator}
+	}
+	if err := checkAnomalyConfig(conf.Anomaly); err != nil {
+		return conf, err
+	}
+
+	holster.SetDefault(&conf.Alert.Schedule, "0 */5 * * * *")
+	for i := range conf.Alert.Rules {
+		rule := &conf.Alert.Rules[i]
+		holster.SetDefault(&rule.Measure, AlertMeasureSum)
+		holster.SetDefault(&rule.Window.Duration, time.Hour*24)
+		holster.SetDefault(&rule.Condition, AlertAbove)
+		holster.SetDefault(&rule.Cooldown.Duration, time.Hour)
+		if len(rule.Destinations) == 0 {
+			rule.Destinations = []string{NotifyOperator}
+		}
+	}
+	if err := checkAlertConfig(conf.Alert); err != nil {
+		return conf, err
+	}
+
+	holster.SetDefault(&conf.Journal.Dir, "./journal")
+	if conf.Journal.IncludeText {
+		if err := RequiredFields(conf.Journal, []string{"EncryptionKey"}); err != nil {
+			return conf, fmt.Errorf("config journal.%s if journal.include-text = true", err)
+		}
+	}
+
 	return conf, nil
 }
 
+func checkReportConfig(conf ReportConfig) error {
+	if _, err := cron.Parse(conf.Schedule); err != nil {
+		return fmt.Errorf("config report.schedule '%s' is invalid; %s", conf.Schedule, err)
+	}
+	if err := checkDestinations("report.destinations", conf.Destinations); err != nil {
+		return err
+	}
+
+	for name, channel := range conf.Channels {
+		prefix := fmt.Sprintf("report.channels.%s", name)
+		// Rules are looked up by the channel name slack reports, which never begins with '#'
+		if strings.HasPrefix(name, "#") {
+			return fmt.Errorf("config %s is invalid; use the channel name without the leading '#'", prefix)
+		}
+		if channel.Schedule != "" {
+			if _, err := cron.Parse(channel.Schedule); err != nil {
+				return fmt.Errorf("config %s.schedule '%s' is invalid; %s", prefix, channel.Schedule, err)
+			}
+		}
+		if channel.ReportDuration.Duration < 0 {
+			return fmt.Errorf("config %s.report-duration must be positive", prefix)
+		}
+		if err := checkDestinations(prefix+".destinations", channel.Destinations); err != nil {
+			return err
+		}
+		for _, section := range channel.Sections {
+			if !slice.ContainsString(section, reportSections(), nil) {
+				return fmt.Errorf("config %s.sections '%s' is invalid; must be one of '%s'",
+					prefix, section, strings.Join(reportSections(), "', '"))
+			}
+		}
+	}
+	return nil
+}
+
+func checkAnomalyConfig(conf AnomalyConfig) error {
+	if _, err := cron.Parse(conf.Schedule); err != nil {
+		return fmt.Errorf("config anomaly.schedule '%s' is invalid; %s", conf.Schedule, err)
+	}
+	if conf.BaselineWeeks < 1 {
+		return fmt.Errorf("config anomaly.baseline-weeks must be at least 1")
+	}
+	if conf.Threshold <= 0 {
+		return fmt.Errorf("config anomaly.threshold must be positive")
+	}
+	if err := checkNotify("anomaly", conf.Destinations, conf.SlackChannel); err != nil {
+		return err
+	}
+	return nil
+}
+
+func checkAlertConfig(conf AlertConfig) error {
+	if _, err := cron.Parse(conf.Schedule); err != nil {
+		return fmt.Errorf("config alert.schedule '%s' is invalid; %s", conf.Schedule, err)
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range conf.Rules {
+		field := fmt.Sprintf("alert.rules[%d]", i)
+		if err := RequiredFields(rule, []string{"Name", "Channel", "Counter"}); err != nil {
+			return fmt.Errorf("config %s.%s", field, err)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("config %s.name '%s' is not unique", field, rule.Name)
+		}
+		names[rule.Name] = true
+
+		if !slice.ContainsString(rule.Counter, validCounters, nil) {
+			return fmt.Errorf("config %s.counter '%s' is invalid; must be one of '%s'",
+				field, rule.Counter, strings.Join(validCounters, "', '"))
+		}
+		if !slice.ContainsString(rule.Measure, alertMeasures, nil) {
+			return fmt.Errorf("config %s.measure '%s' is invalid; must be one of '%s'",
+				field, rule.Measure, strings.Join(alertMeasures, "', '"))
+		}
+		if !slice.ContainsString(rule.Condition, alertConditions, nil) {
+			return fmt.Errorf("config %s.condition '%s' is invalid; must be one of '%s'",
+				field, rule.Condition, strings.Join(alertConditions, "', '"))
+		}
+		if rule.Window.Duration < time.Hour {
+			return fmt.Errorf("config %s.window must be at least 1h", field)
+		}
+		if err := checkNotify(field, rule.Destinations, rule.SlackChannel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkNotify(field string, destinations []string, slackChannel string) error {
+	for _, dest := range destinations {
+		if !slice.ContainsString(dest, notifyDestinations, nil) {
+			return fmt.Errorf("config %s.destinations '%s' is invalid; must be one of '%s'",
+				field, dest, strings.Join(notifyDestinations, "', '"))
+		}
+	}
+	if slice.ContainsString(NotifySlack, destinations, nil) && slackChannel == "" {
+		return fmt.Errorf("config %s.slack-channel is required if %s.destinations includes 'slack'", field, field)
+	}
+	return nil
+}
+
+func checkDestinations(field string, destinations []string) error {
+	for _, dest := range destinations {
+		if !slice.ContainsString(dest, reportDestinations, nil) {
+			return fmt.Errorf("config %s '%s' is invalid; must be one of '%s'",
+				field, dest, strings.Join(reportDestinations, "', '"))
+		}
+	}
+	return nil
+}
+
 func RequiredFields(obj interface{}, fields []string) error {
 	s := structs.New(obj)
 
@@ -185,6 +644,16 @@ func SrcFromEnv(obj interface{}) {
 				panic(fmt.Sprintf("While converting '%s' to an integer - %s", field.Name(), err))
 			}
 			val = int(val64)
+		case reflect.Float64:
+			strVal := os.Getenv(field.Tag("env"))
+			if strVal == "" {
+				continue
+			}
+			val64, err := strconv.ParseFloat(strVal, 64)
+			if err != nil {
+				panic(fmt.Sprintf("While converting '%s' to a float - %s", field.Name(), err))
+			}
+			val = val64
 		case reflect.Bool:
 			strVal := strings.ToLower(os.Getenv(field.Tag("env")))
 			if strVal == "true" || strVal == "yes" {
@@ -192,6 +661,20 @@ func SrcFromEnv(obj interface{}) {
 			} else {
 				val = false
 			}
+		case reflect.Slice:
+			// A comma separated list like 'email,slack'
+			strVal := os.Getenv(field.Tag("env"))
+			if strVal == "" {
+				continue
+			}
+			var items []string
+			for _, item := range strings.Split(strVal, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+			val = items
+		case reflect.Map:
+			// Maps can only be provided via the config file
+			continue
 		default:
 			val = os.Getenv(field.Tag("env"))
 		}
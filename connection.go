@@ -0,0 +1,148 @@
+package channelstats
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The states of the connection to slack
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+	// Slack rejected our credentials, the bot no longer attempts to connect
+	StateAuthFailed = "auth-failed"
+	StateStopped    = "stopped"
+	// The API is served without a slack bot
+	StateDisabled = "disabled"
+)
+
+const (
+	// Notify the operator if we are disconnected from slack for longer than this
+	disconnectNotifyAfter = time.Second * 30
+	// How often the connection is checked for 'disconnectNotifyAfter'
+	connectionCheckInterval = time.Second * 5
+)
+
+type ConnectionStatus struct {
+	State string `json:"state"`
+	// When the connection entered the current state
+	Since time.Time `json:"since"`
+	// When the connection was lost, zero while connected
+	DownSince time.Time `json:"down-since,omitempty"`
+	// Attempts to connect since the connection was last established
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last-error,omitempty"`
+}
+
+// Tracks the state of the connection to slack, safe for concurrent use
+type connectionState struct {
+	mutex  sync.Mutex
+	status ConnectionStatus
+	// Signaled when the state changes
+	changed chan struct{}
+}
+
+func newConnectionState() *connectionState {
+	now := time.Now()
+	return &connectionState{
+		status:  ConnectionStatus{State: StateDisconnected, Since: now, DownSince: now},
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Transition to the state, recording the error as the last error if provided
+func (c *connectionState) Set(state string, err error) {
+	c.mutex.Lock()
+	switch state {
+	case StateConnecting:
+		c.status.Attempts++
+	case StateConnected:
+		c.status.Attempts = 0
+		c.status.LastError = ""
+		c.status.DownSince = time.Time{}
+	}
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+	if c.status.State != state {
+		if c.status.State == StateConnected {
+			c.status.DownSince = time.Now()
+		}
+		c.status.State = state
+		c.status.Since = time.Now()
+	}
+	c.mutex.Unlock()
+
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (c *connectionState) Status() ConnectionStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.status
+}
+
+// Exponential backoff with jitter
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Returns a backoff which defaults to 1 second doubling to a maximum of 5 minutes
+func NewBackoff(min, max time.Duration) Backoff {
+	if min <= 0 {
+		min = time.Second
+	}
+	if max < min {
+		max = time.Minute * 5
+	}
+	return Backoff{Min: min, Max: max}
+}
+
+// Returns how long to wait before the attempt. The wait doubles with each attempt up to 'Max' and
+// is randomised between half and the full duration, such that clients do not reconnect in lock step.
+func (b Backoff) Duration(attempt int) time.Duration {
+	d := b.Min
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+type HealthResp struct {
+	Status string           `json:"status"`
+	Slack  ConnectionStatus `json:"slack"`
+}
+
+type healthHandler struct {
+	bot *SlackBot
+}
+
+// Reports the state of the connection to slack, responds with 503 unless connected or the bot is nil
+func NewHealthHandler(bot *SlackBot) http.Handler {
+	return &healthHandler{bot: bot}
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := HealthResp{Status: "ok", Slack: ConnectionStatus{State: StateDisabled}}
+	if h.bot != nil {
+		resp.Slack = h.bot.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Slack.State != StateConnected && resp.Slack.State != StateDisabled {
+		resp.Status = "unhealthy"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
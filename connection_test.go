@@ -0,0 +1,68 @@
+package channelstats_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestConnection(t *testing.T) {
+	suite.Run(t, new(ConnectionSuite))
+}
+
+type ConnectionSuite struct {
+	suite.Suite
+}
+
+func (s *ConnectionSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *ConnectionSuite) TestBackoff() {
+	backoff := channelstats.NewBackoff(time.Second, time.Minute)
+
+	for i := 0; i < 100; i++ {
+		for attempt, expected := range map[int]time.Duration{
+			0:  time.Second,
+			1:  time.Second,
+			2:  time.Second * 2,
+			4:  time.Second * 8,
+			20: time.Minute,
+		} {
+			wait := backoff.Duration(attempt)
+			s.True(wait >= expected/2 && wait <= expected,
+				"attempt %d waited %s; expected between %s and %s", attempt, wait, expected/2, expected)
+		}
+	}
+}
+
+func (s *ConnectionSuite) TestBackoffDefaults() {
+	s.Equal(channelstats.Backoff{Min: time.Second, Max: time.Minute * 5}, channelstats.NewBackoff(0, 0))
+}
+
+func (s *ConnectionSuite) TestHealthBeforeConnected() {
+	var conf channelstats.Config
+	conf.Store.Backend = "memory"
+	store, err := channelstats.NewStore(conf, nil)
+	s.Require().NoError(err)
+
+	bot := channelstats.NewSlackBot(conf, store, nil, &channelstats.NullMailer{})
+	s.Equal(channelstats.StateDisconnected, bot.Status().State)
+	s.False(bot.Status().DownSince.IsZero())
+}
+
+func (s *ConnectionSuite) TestHealthWithoutBot() {
+	resp := httptest.NewRecorder()
+	channelstats.NewHealthHandler(nil).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	s.Equal(http.StatusOK, resp.Code)
+
+	var health channelstats.HealthResp
+	s.Require().NoError(json.Unmarshal(resp.Body.Bytes(), &health))
+	s.Equal("ok", health.Status)
+	s.Equal(channelstats.StateDisabled, health.Slack.State)
+}
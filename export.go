@@ -0,0 +1,114 @@
+package channelstats
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mailgun/holster/slice"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Trailer which holds the cursor to request the next page of data points
+	NextCursorHeader = "X-Next-Cursor"
+
+	// Flush the response to the client after this many data points
+	exportFlushCount = 1000
+)
+
+var (
+	exportParams = []string{"start-hour", "end-hour", "channel", "counter", "cursor", "limit"}
+	errLimit     = errors.New("limit reached")
+)
+
+// Stream every data point matching the filters as newline delimited JSON in key order.
+// If 'limit' is reached the key of the last data point is returned in the 'X-Next-Cursor'
+// trailer, which can be passed as 'cursor' to fetch the next page.
+func (s *Server) getAll(w http.ResponseWriter, r *http.Request) {
+	if err := isValidParams(r, exportParams, []string{}); err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	filter, err := s.filterFromRequest(r)
+	if err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var limit int
+	if value := r.FormValue("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			abort(w, errors.New("'limit' must be a positive number"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Trailer", NextCursorHeader)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	var count int
+	var last DataPoint
+
+	err = s.store.ForEach(r.Context(), filter, func(dp DataPoint) error {
+		// Only hand out a cursor if there is at least one more data point to fetch
+		if limit != 0 && count == limit {
+			return errLimit
+		}
+		if err := enc.Encode(dp); err != nil {
+			return err
+		}
+		count++
+		last = dp
+		if flusher != nil && count%exportFlushCount == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	if err == errLimit {
+		w.Header().Set(NextCursorHeader, string(last.Key()))
+		return
+	}
+	if err != nil {
+		// Headers are likely already sent, log the error and end the stream
+		s.log.Errorf("while streaming data points: %s", err)
+	}
+}
+
+// Build a DataPointFilter from the export request parameters
+func (s *Server) filterFromRequest(r *http.Request) (DataPointFilter, error) {
+	filter := DataPointFilter{
+		StartHour: r.FormValue("start-hour"),
+		EndHour:   r.FormValue("end-hour"),
+		Counter:   r.FormValue("counter"),
+		After:     r.FormValue("cursor"),
+	}
+
+	for _, hour := range []string{filter.StartHour, filter.EndHour} {
+		if hour == "" {
+			continue
+		}
+		if _, err := time.Parse(RFC3339Short, hour); err != nil {
+			return filter, errors.Wrapf(err, "hour is not in the format '%s'", RFC3339Short)
+		}
+	}
+
+	if filter.Counter != "" && !slice.ContainsString(filter.Counter, validCounters, nil) {
+		return filter, errors.Errorf("invalid 'counter' '%s'", filter.Counter)
+	}
+
+	if channel := r.FormValue("channel"); channel != "" {
+		var err error
+		filter.ChannelID, err = s.idMgr.GetChannelID(channel)
+		if err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,138 @@
+package channelstats_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestExport(t *testing.T) {
+	suite.Run(t, new(ExportSuite))
+}
+
+type ExportSuite struct {
+	suite.Suite
+	store  channelstats.Storer
+	server *channelstats.Server
+	http   *httptest.Server
+}
+
+func (s *ExportSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *ExportSuite) SetupTest() {
+	var conf channelstats.Config
+	conf.Store.Backend = "memory"
+	idMgr := &channelstats.MockIDManage{UserByID: map[string]string{"U1": "joe"}}
+
+	var err error
+	s.store, err = channelstats.NewStore(conf, idMgr)
+	s.Require().NoError(err)
+	s.server = channelstats.NewServer(conf, s.store, idMgr, nil, nil, nil, nil)
+	s.http = httptest.NewServer(s.server)
+}
+
+func (s *ExportSuite) TearDownTest() {
+	s.http.Close()
+	s.server.Stop()
+	s.store.Close()
+}
+
+// Add a 'messages' data point for each user in the first hour of the day
+func (s *ExportSuite) addDataPoints(users int) []string {
+	var dps []channelstats.DataPoint
+	var keys []string
+	for i := 0; i < users; i++ {
+		dp := channelstats.DataPoint{Hour: "2018-12-13T00", Counter: "messages",
+			ChannelID: "C1", UserID: fmt.Sprintf("U%03d", i), Value: 1}
+		dps = append(dps, dp)
+		keys = append(keys, string(dp.Key()))
+	}
+	s.Require().NoError(s.store.(channelstats.Reindexer).AddDataPoints(dps))
+	return keys
+}
+
+// Fetch a page of data points, returns the keys of the data points and the cursor for the next page
+func (s *ExportSuite) page(params url.Values) ([]string, string) {
+	resp, err := http.Get(s.http.URL + "/api/all?" + params.Encode())
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	var keys []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var dp channelstats.DataPoint
+		s.Require().NoError(json.Unmarshal(scanner.Bytes(), &dp))
+		keys = append(keys, string(dp.Key()))
+	}
+	s.Require().NoError(scanner.Err())
+
+	// The trailer is only available once the body has been read
+	return keys, resp.Trailer.Get(channelstats.NextCursorHeader)
+}
+
+// Fetch every page of data points 'limit' at a time, returns the keys and the number of pages fetched
+func (s *ExportSuite) pageAll(limit int) ([]string, int) {
+	var keys []string
+	var pages int
+	params := url.Values{"limit": {fmt.Sprint(limit)}}
+	for {
+		page, cursor := s.page(params)
+		s.True(len(page) <= limit, "page %d returned %d data points", pages, len(page))
+		keys = append(keys, page...)
+		pages++
+		if cursor == "" {
+			return keys, pages
+		}
+		s.Require().True(pages < 10, "too many pages")
+		s.Equal(page[len(page)-1], cursor)
+		params.Set("cursor", cursor)
+	}
+}
+
+func (s *ExportSuite) TestPagination() {
+	expected := s.addDataPoints(25)
+
+	// Every data point is returned once and in order
+	keys, pages := s.pageAll(10)
+	s.Equal(expected, keys)
+	s.Equal(3, pages)
+}
+
+func (s *ExportSuite) TestPaginationFullPages() {
+	expected := s.addDataPoints(20)
+
+	// The last full page does not hand out a cursor to an empty page
+	keys, pages := s.pageAll(10)
+	s.Equal(expected, keys)
+	s.Equal(2, pages)
+}
+
+func (s *ExportSuite) TestWithoutLimit() {
+	expected := s.addDataPoints(25)
+
+	keys, cursor := s.page(url.Values{})
+	s.Equal(expected, keys)
+	s.Empty(cursor)
+}
+
+func (s *ExportSuite) TestInvalidLimit() {
+	for _, limit := range []string{"0", "-1", "ten"} {
+		resp, err := http.Get(s.http.URL + "/api/all?limit=" + limit)
+		s.Require().NoError(err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		s.Equal(http.StatusBadRequest, resp.StatusCode, string(body))
+	}
+}
@@ -0,0 +1,263 @@
+package channelstats
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+
+	formatDesc = "response format one of 'json', 'csv' or 'parquet', can also be selected with the 'Accept' header"
+)
+
+var (
+	ValidFormats = []string{FormatJSON, FormatCSV, FormatParquet}
+
+	formatContentTypes = map[string]string{
+		FormatJSON:    "application/json",
+		FormatCSV:     "text/csv",
+		FormatParquet: "application/vnd.apache.parquet",
+	}
+)
+
+// Flat representations of the query results written as CSV and Parquet rows,
+// the parquet 'name' is also used as the CSV column header
+type DataPointRow struct {
+	Hour        string `parquet:"name=hour, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Counter     string `parquet:"name=counter, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ChannelID   string `parquet:"name=channel_id, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ChannelName string `parquet:"name=channel_name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	UserID      string `parquet:"name=user_id, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	UserName    string `parquet:"name=user_name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Value       int64  `parquet:"name=value, type=INT64"`
+}
+
+type SumRow struct {
+	User string `parquet:"name=user, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Sum  int64  `parquet:"name=sum, type=INT64"`
+}
+
+type PercentageRow struct {
+	User    string `parquet:"name=user, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Total   int64  `parquet:"name=total, type=INT64"`
+	Count   int64  `parquet:"name=count, type=INT64"`
+	Percent int64  `parquet:"name=percentage, type=INT64"`
+}
+
+type CompareRow struct {
+	User          string  `parquet:"name=user, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value         int64   `parquet:"name=value, type=INT64"`
+	Previous      int64   `parquet:"name=previous, type=INT64"`
+	Change        int64   `parquet:"name=change, type=INT64"`
+	PercentChange float64 `parquet:"name=percent_change, type=DOUBLE"`
+	Status        string  `parquet:"name=status, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// Returns the row which represents the query result item
+func toRow(item interface{}) (interface{}, error) {
+	switch v := item.(type) {
+	case DataPoint:
+		return DataPointRow{
+			Hour:        v.Hour,
+			Counter:     v.Counter,
+			ChannelID:   v.ChannelID,
+			ChannelName: v.ChannelName,
+			UserID:      v.UserID,
+			UserName:    v.UserName,
+			Value:       v.Value,
+		}, nil
+	case SumResp:
+		return SumRow{User: v.User, Sum: v.Sum}, nil
+	case PercentageResp:
+		return PercentageRow{User: v.User, Total: v.Total, Count: v.Count, Percent: v.Percent}, nil
+	case CompareResp:
+		return CompareRow{
+			User:          v.User,
+			Value:         v.Value,
+			Previous:      v.Previous,
+			Change:        v.Change,
+			PercentChange: v.PercentChange,
+			Status:        v.Status,
+		}, nil
+	}
+	return nil, errors.Errorf("no row format for type '%T'", item)
+}
+
+// Writes query result items (DataPoint, SumResp, PercentageResp or CompareResp) in one of the ValidFormats
+type RowWriter interface {
+	Write(item interface{}) error
+	// Flushes any buffered rows, Close() does not close the underlying io.Writer
+	Close() error
+}
+
+// Create a RowWriter for the format, 'sample' is a value of the type of item
+// that will be written and is used to determine the columns
+func NewRowWriter(w io.Writer, format string, sample interface{}) (RowWriter, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonRowWriter{enc: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return newCSVRowWriter(w, sample)
+	case FormatParquet:
+		return newParquetRowWriter(w, sample)
+	}
+	return nil, errors.Errorf("invalid format '%s'; must be one of '%s'", format, strings.Join(ValidFormats, ","))
+}
+
+// Writes newline delimited JSON
+type jsonRowWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonRowWriter) Write(item interface{}) error {
+	return j.enc.Encode(item)
+}
+
+func (j *jsonRowWriter) Close() error { return nil }
+
+type csvRowWriter struct {
+	csv *csv.Writer
+}
+
+func newCSVRowWriter(w io.Writer, sample interface{}) (*csvRowWriter, error) {
+	proto, err := toRow(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &csvRowWriter{csv: csv.NewWriter(w)}
+	if err := c.csv.Write(columnNames(reflect.TypeOf(proto))); err != nil {
+		return nil, errors.Wrap(err, "while writing CSV header")
+	}
+	return c, nil
+}
+
+func (c *csvRowWriter) Write(item interface{}) error {
+	row, err := toRow(item)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(row)
+	record := make([]string, v.NumField())
+	for i := range record {
+		record[i] = fmt.Sprint(v.Field(i).Interface())
+	}
+	return c.csv.Write(record)
+}
+
+func (c *csvRowWriter) Close() error {
+	c.csv.Flush()
+	return c.csv.Error()
+}
+
+type parquetRowWriter struct {
+	pw *writer.ParquetWriter
+}
+
+func newParquetRowWriter(w io.Writer, sample interface{}) (*parquetRowWriter, error) {
+	proto, err := toRow(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := writer.NewParquetWriterFromWriter(w, reflect.New(reflect.TypeOf(proto)).Interface(), 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "while creating parquet writer")
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetRowWriter{pw: pw}, nil
+}
+
+func (p *parquetRowWriter) Write(item interface{}) error {
+	row, err := toRow(item)
+	if err != nil {
+		return err
+	}
+	return p.pw.Write(row)
+}
+
+func (p *parquetRowWriter) Close() error {
+	return errors.Wrap(p.pw.WriteStop(), "while writing parquet footer")
+}
+
+// Returns the 'name' from the parquet tag of each field in the row
+func columnNames(t reflect.Type) []string {
+	var results []string
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		for _, opt := range strings.Split(t.Field(i).Tag.Get("parquet"), ",") {
+			opt = strings.TrimSpace(opt)
+			if strings.HasPrefix(opt, "name=") {
+				name = strings.TrimPrefix(opt, "name=")
+			}
+		}
+		results = append(results, name)
+	}
+	return results
+}
+
+// Choose the response format from the 'format' parameter or the 'Accept' header, defaults to JSON
+func formatFromRequest(r *http.Request) (string, error) {
+	if format := r.FormValue("format"); format != "" {
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", errors.Errorf("invalid 'format' must be one of '%s'", strings.Join(ValidFormats, ","))
+		}
+		return format, nil
+	}
+
+	accept := r.Header.Get("Accept")
+	for _, format := range []string{FormatCSV, FormatParquet} {
+		if strings.Contains(accept, formatContentTypes[format]) {
+			return format, nil
+		}
+	}
+	return FormatJSON, nil
+}
+
+// Write the query results to the client in the requested format. JSON responses
+// include the time range, CSV and Parquet responses contain only the items.
+func writeItems(w http.ResponseWriter, format, name string, resp ItemResp) {
+	if format == FormatJSON {
+		toJSON(w, resp)
+		return
+	}
+
+	// Buffer the response so we can still report an error to the client
+	var buf bytes.Buffer
+	items := reflect.ValueOf(resp.Items)
+	rw, err := NewRowWriter(&buf, format, reflect.Zero(items.Type().Elem()).Interface())
+	if err != nil {
+		abort(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	for i := 0; i < items.Len(); i++ {
+		if err := rw.Write(items.Index(i).Interface()); err != nil {
+			abort(w, errors.Wrapf(err, "while writing %s row", format), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := rw.Close(); err != nil {
+		abort(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s-%s.%s\"",
+		name, resp.StartHour, resp.EndHour, format))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,74 @@
+package channelstats_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestRowWriter(t *testing.T) {
+	suite.Run(t, new(RowWriterSuite))
+}
+
+type RowWriterSuite struct {
+	suite.Suite
+}
+
+func (s *RowWriterSuite) write(format string, items ...interface{}) string {
+	var buf bytes.Buffer
+	rw, err := channelstats.NewRowWriter(&buf, format, items[0])
+	s.Require().NoError(err)
+	for _, item := range items {
+		s.Require().NoError(rw.Write(item))
+	}
+	s.Require().NoError(rw.Close())
+	return buf.String()
+}
+
+func (s *RowWriterSuite) TestCSVDataPoints() {
+	out := s.write(channelstats.FormatCSV, channelstats.DataPoint{
+		Hour:        "2018-12-13T00",
+		Counter:     "messages",
+		ChannelID:   "C1",
+		ChannelName: "general",
+		UserID:      "U1",
+		UserName:    "joe",
+		Value:       10,
+	})
+	s.Equal("hour,counter,channel_id,channel_name,user_id,user_name,value\n"+
+		"2018-12-13T00,messages,C1,general,U1,joe,10\n", out)
+}
+
+func (s *RowWriterSuite) TestCSVPercentage() {
+	out := s.write(channelstats.FormatCSV,
+		channelstats.PercentageResp{User: "joe", Total: 4, Count: 1, Percent: 25},
+		channelstats.PercentageResp{User: "scott, jr", Total: 2, Count: 2, Percent: 100},
+	)
+	s.Equal("user,total,count,percentage\njoe,4,1,25\n\"scott, jr\",2,2,100\n", out)
+}
+
+func (s *RowWriterSuite) TestJSONSum() {
+	out := s.write(channelstats.FormatJSON,
+		channelstats.SumResp{User: "joe", Sum: 1},
+		channelstats.SumResp{User: "scott", Sum: 2},
+	)
+	s.Equal("{\"user\":\"joe\",\"sum\":1}\n{\"user\":\"scott\",\"sum\":2}\n", out)
+}
+
+func (s *RowWriterSuite) TestParquet() {
+	out := s.write(channelstats.FormatParquet, channelstats.SumResp{User: "joe", Sum: 1})
+	// Parquet files begin and end with the magic number
+	s.True(len(out) > 8)
+	s.Equal("PAR1", out[:4])
+	s.Equal("PAR1", out[len(out)-4:])
+}
+
+func (s *RowWriterSuite) TestInvalid() {
+	_, err := channelstats.NewRowWriter(&bytes.Buffer{}, "xml", channelstats.SumResp{})
+	s.Error(err)
+
+	_, err = channelstats.NewRowWriter(&bytes.Buffer{}, channelstats.FormatCSV, "not a row")
+	s.Error(err)
+}
@@ -12,24 +12,27 @@ require (
 	github.com/go-chi/chi v4.0.0+incompatible
 	github.com/gobuffalo/envy v1.6.11 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
-	github.com/gorilla/websocket v1.2.0 // indirect
+	github.com/gorilla/websocket v1.2.0
+	github.com/lib/pq v1.1.1
 	github.com/lusis/go-slackbot v0.0.0-20180109053408-401027ccfef5 // indirect
 	github.com/lusis/slack-test v0.0.0-20180109053238-3c758769bfa6 // indirect
 	github.com/mailgun/holster v2.3.1+incompatible
 	github.com/mailgun/mailgun-go v2.0.0+incompatible
 	github.com/mailgun/mailgun-go/v3 v3.0.0-rc.2
 	github.com/mattn/go-colorable v0.0.9 // indirect
-	github.com/mattn/go-isatty v0.0.4 // indirect
+	github.com/mattn/go-isatty v0.0.12 // indirect
 	github.com/mgutz/ansi v0.0.0-20170206155736-9520e82c474b // indirect
 	github.com/nlopes/slack v0.0.0-20180413165149-3472fa2dd034
-	github.com/pkg/errors v0.8.1
+	github.com/pkg/errors v0.9.1
 	github.com/robfig/cron v0.0.0-20180505203441-b41be1df6967
 	github.com/sirupsen/logrus v1.2.0
-	github.com/stretchr/testify v1.2.2
+	github.com/stretchr/testify v1.7.0
 	github.com/tmthrgd/go-bindata v0.0.0-20180829002824-c8d03665bae9 // indirect
 	github.com/wcharczuk/go-chart v2.0.1+incompatible
 	github.com/x-cray/logrus-prefixed-formatter v0.5.2
-	golang.org/x/image v0.0.0-20181116024801-cd38e8056d9b // indirect
-	google.golang.org/appengine v1.2.0 // indirect
+	github.com/xitongsys/parquet-go v1.6.2
+	golang.org/x/image v0.0.0-20190802002840-cff245a6509b // indirect
+	google.golang.org/appengine v1.6.5 // indirect
 	gopkg.in/ahmetb/go-linq.v3 v3.0.0 // indirect
+	modernc.org/sqlite v1.10.0
 )
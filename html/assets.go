@@ -4,7 +4,6 @@ package html
 
 import (
 	"bytes"
-	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,7 +14,6 @@ func Get(file string) ([]byte, error) {
 	if _, err := os.Stat(file); os.IsNotExist(err) {
 		filePath := strings.TrimPrefix(filepath.ToSlash(
 			strings.TrimPrefix(file, "html/")), "/")
-		fmt.Printf("asset file: %s\n", file)
 		return Asset(filePath)
 	}
 
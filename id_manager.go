@@ -51,12 +51,14 @@ type IDManage struct {
 	userByID      map[string]string
 	log           *logrus.Entry
 	token         string
+	apiURL        string
 }
 
 func NewIdManager(conf Config) (IDManager, error) {
 	im := IDManage{
-		log:   GetLogger().WithField("prefix", "id-manager"),
-		token: conf.Slack.Token,
+		log:    GetLogger().WithField("prefix", "id-manager"),
+		token:  conf.Slack.Token,
+		apiURL: conf.Slack.APIURL,
 	}
 	// Populate our channel listing
 	if err := im.UpdateChannels(); err != nil {
@@ -77,10 +79,10 @@ func (im *IDManage) UpdateUsers() error {
 	params.Add("token", im.token)
 
 	im.log.Info("Fetching User Listing...")
-	url := fmt.Sprintf("https://slack.com/api/users.list?%s", params.Encode())
+	url := fmt.Sprintf("%s/users.list?%s", im.apiURL, params.Encode())
 	resp, err := http.Get(url)
 	if err != nil {
-		return errors.Wrapf(err, "GET '%s' failed with '%d'", url, resp.StatusCode)
+		return errors.Wrapf(err, "GET '%s' failed", url)
 	}
 	defer resp.Body.Close()
 
@@ -113,10 +115,10 @@ func (im *IDManage) UpdateChannels() error {
 	params.Add("token", im.token)
 
 	im.log.Info("Fetching Channel Listing...")
-	url := fmt.Sprintf("https://slack.com/api/channels.list?%s", params.Encode())
+	url := fmt.Sprintf("%s/channels.list?%s", im.apiURL, params.Encode())
 	resp, err := http.Get(url)
 	if err != nil {
-		return errors.Wrapf(err, "GET '%s' failed with '%d'", url, resp.StatusCode)
+		return errors.Wrapf(err, "GET '%s' failed", url)
 	}
 	defer resp.Body.Close()
 
@@ -177,6 +179,8 @@ type MockIDManage struct {
 	UserByID   map[string]string
 }
 
+func (n *MockIDManage) Channels() []SlackChannelInfo { return nil }
+
 func (n *MockIDManage) UpdateUsers() error { return nil }
 
 func (n *MockIDManage) UpdateChannels() error { return nil }
@@ -198,3 +202,21 @@ func (n *MockIDManage) GetUserName(id string) (string, error) {
 	}
 	return "(unknown)", errors.Errorf("user id '%s' not found", id)
 }
+
+// Resolves every name to the id and every id to itself, for commands which run without
+// a connection to slack such that data points are identified by the slack ids
+type OfflineIDManager struct{}
+
+func (n *OfflineIDManager) Channels() []SlackChannelInfo { return nil }
+
+func (n *OfflineIDManager) UpdateUsers() error { return nil }
+
+func (n *OfflineIDManager) UpdateChannels() error { return nil }
+
+func (n *OfflineIDManager) GetChannelID(name string) (string, error) { return name, nil }
+
+func (n *OfflineIDManager) GetChannelName(id string) (string, error) { return id, nil }
+
+func (n *OfflineIDManager) GetUserID(name string) (string, error) { return name, nil }
+
+func (n *OfflineIDManager) GetUserName(id string) (string, error) { return id, nil }
@@ -0,0 +1,440 @@
+package channelstats
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailgun/holster/slice"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	JournalMessage  = "message"
+	JournalReaction = "reaction_added"
+
+	// Journal files hold the events for a single day and are named after that day
+	journalDateFormat = "2006-01-02"
+	journalFileSuffix = ".ndjson"
+	// Records when the journal was enabled, events counted before then are not journaled
+	journalEnabledFile = "enabled"
+)
+
+// The normalised metadata of a counted event, enough to count the event again
+type JournalEntry struct {
+	// One of 'message' or 'reaction_added'
+	Type string `json:"type"`
+	// The slack timestamp of the message or reaction event
+	Timestamp string `json:"ts"`
+	Channel   string `json:"channel"`
+	User      string `json:"user"`
+	// The name of the emoji for 'reaction_added' events
+	Reaction string `json:"reaction,omitempty"`
+	// The timestamp of the message the reaction was added to
+	ItemTimestamp string `json:"item_ts,omitempty"`
+	// The encrypted message text, only recorded if journal.include-text is true
+	Text string `json:"text,omitempty"`
+	// True if the message text was recorded, such that counters derived from the text can be recomputed
+	HasText bool `json:"has_text,omitempty"`
+}
+
+// An append-only record of every event counted by the store. Replaying the journal
+// allows counters to be recomputed after a counter is added or its calculation changes.
+type Journal struct {
+	log         *logrus.Entry
+	dir         string
+	includeText bool
+	enabled     time.Time
+	aead        cipher.AEAD
+	mutex       sync.Mutex
+	date        string
+	fd          *os.File
+}
+
+// Returns nil if the journal is not enabled, all methods on a nil *Journal are no-ops
+func NewJournal(conf Config) (*Journal, error) {
+	if !conf.Journal.Enabled {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(conf.Journal.Dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "while creating journal directory '%s'", conf.Journal.Dir)
+	}
+
+	j := &Journal{
+		log:         GetLogger().WithField("prefix", "journal"),
+		dir:         conf.Journal.Dir,
+		includeText: conf.Journal.IncludeText,
+	}
+
+	if conf.Journal.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(conf.Journal.EncryptionKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "journal.encryption-key must be base64 encoded")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "journal.encryption-key must be a 16, 24 or 32 byte key")
+		}
+		if j.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, errors.Wrap(err, "while creating journal cipher")
+		}
+	}
+
+	if j.includeText && j.aead == nil {
+		return nil, errors.New("journal.encryption-key is required if journal.include-text = true")
+	}
+
+	var err error
+	if j.enabled, err = j.readEnabled(); err != nil {
+		return nil, err
+	}
+	j.log.Infof("Journaling counted events to '%s'", j.dir)
+	return j, nil
+}
+
+// Record a message which added the data points to the counters
+func (j *Journal) Message(ev *slack.MessageEvent, dps []DataPoint) error {
+	if j == nil || len(dps) == 0 {
+		return nil
+	}
+
+	entry := JournalEntry{
+		Type:      JournalMessage,
+		Timestamp: ev.Timestamp,
+		Channel:   ev.Channel,
+		User:      ev.User,
+	}
+
+	if j.includeText {
+		text, err := j.encrypt(ev.Text)
+		if err != nil {
+			return err
+		}
+		entry.Text = text
+		entry.HasText = true
+	}
+	return j.append(entry)
+}
+
+// Record a reaction which added the data points to the counters
+func (j *Journal) Reaction(ev *slack.ReactionAddedEvent, dps []DataPoint) error {
+	if j == nil || len(dps) == 0 {
+		return nil
+	}
+
+	return j.append(JournalEntry{
+		Type:          JournalReaction,
+		Timestamp:     ev.EventTimestamp,
+		Channel:       ev.Item.Channel,
+		User:          ev.User,
+		Reaction:      ev.Reaction,
+		ItemTimestamp: ev.Item.Timestamp,
+	})
+}
+
+func (j *Journal) append(entry JournalEntry) error {
+	t, err := timeFromTimeStamp(entry.Timestamp)
+	if err != nil {
+		return errors.Wrap(err, "while journaling event")
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "while marshalling journal entry")
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	// Events are appended to the file for the day the event occurred
+	date := t.Format(journalDateFormat)
+	if j.fd == nil || j.date != date {
+		if j.fd != nil {
+			j.fd.Close()
+		}
+		path := filepath.Join(j.dir, date+journalFileSuffix)
+		j.fd, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			j.fd = nil
+			return errors.Wrapf(err, "while opening journal file '%s'", path)
+		}
+		j.date = date
+	}
+
+	if _, err := j.fd.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "while writing journal entry")
+	}
+	return nil
+}
+
+// Returns when the journal was first enabled. Backfill and import journal events from before
+// then, but events counted before the journal was enabled are never journaled.
+func (j *Journal) Start() (time.Time, error) {
+	if j == nil {
+		return time.Time{}, errors.New("journal is not enabled")
+	}
+	return j.enabled, nil
+}
+
+// Read the time the journal was enabled, recording the current time if this is the first time it is opened
+func (j *Journal) readEnabled() (time.Time, error) {
+	path := filepath.Join(j.dir, journalEnabledFile)
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(content)))
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "invalid time in journal file '%s'", path)
+		}
+		return t.UTC(), nil
+	}
+	if !os.IsNotExist(err) {
+		return time.Time{}, errors.Wrapf(err, "while reading journal file '%s'", path)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := ioutil.WriteFile(path, []byte(now.Format(time.RFC3339)+"\n"), 0600); err != nil {
+		return time.Time{}, errors.Wrapf(err, "while writing journal file '%s'", path)
+	}
+	return now, nil
+}
+
+// Call fn() for each journal entry that occurred at or after 'since' in the order
+// they were recorded for each day. Encrypted text is decrypted before calling fn()
+func (j *Journal) Replay(ctx context.Context, since time.Time, fn func(JournalEntry) error) error {
+	if j == nil {
+		return errors.New("journal is not enabled")
+	}
+
+	dates, err := j.dates()
+	if err != nil {
+		return err
+	}
+
+	sinceDate := since.UTC().Format(journalDateFormat)
+	for _, date := range dates {
+		if date < sinceDate {
+			continue
+		}
+		if err := j.replayFile(ctx, date, since, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Journal) replayFile(ctx context.Context, date string, since time.Time, fn func(JournalEntry) error) error {
+	path := filepath.Join(j.dir, date+journalFileSuffix)
+	fd, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "while opening journal file '%s'", path)
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return errors.Wrapf(err, "while decoding entry in journal file '%s'", path)
+		}
+
+		t, err := timeFromTimeStamp(entry.Timestamp)
+		if err != nil {
+			return errors.Wrapf(err, "invalid entry in journal file '%s'", path)
+		}
+		if t.Before(since) {
+			continue
+		}
+
+		if entry.HasText {
+			if entry.Text, err = j.decrypt(entry.Text); err != nil {
+				return errors.Wrapf(err, "while decrypting entry in journal file '%s'", path)
+			}
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return errors.Wrapf(scanner.Err(), "while reading journal file '%s'", path)
+}
+
+// Returns the days in the journal in order
+func (j *Journal) dates() ([]string, error) {
+	files, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while listing journal directory '%s'", j.dir)
+	}
+
+	var results []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), journalFileSuffix) {
+			results = append(results, strings.TrimSuffix(file.Name(), journalFileSuffix))
+		}
+	}
+	sort.Strings(results)
+	return results, nil
+}
+
+func (j *Journal) encrypt(text string) (string, error) {
+	nonce := make([]byte, j.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "while generating nonce")
+	}
+	return base64.StdEncoding.EncodeToString(j.aead.Seal(nonce, nonce, []byte(text), nil)), nil
+}
+
+func (j *Journal) decrypt(text string) (string, error) {
+	if j.aead == nil {
+		return "", errors.New("journal.encryption-key is required to read the message text")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < j.aead.NonceSize() {
+		return "", errors.New("encrypted text is too short")
+	}
+	plain, err := j.aead.Open(nil, data[:j.aead.NonceSize()], data[j.aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "wrong journal.encryption-key?")
+	}
+	return string(plain), nil
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.fd == nil {
+		return nil
+	}
+	err := j.fd.Close()
+	j.fd = nil
+	return err
+}
+
+// Returns the data points the journal entry adds to the counters. Messages recorded
+// without text only count towards the 'messages' counter.
+func (e JournalEntry) DataPoints() ([]DataPoint, error) {
+	switch e.Type {
+	case JournalMessage:
+		if !e.HasText {
+			hour, err := hourFromTimeStamp(e.Timestamp)
+			if err != nil {
+				return nil, err
+			}
+			return []DataPoint{{Hour: hour, Counter: "messages", ChannelID: e.Channel, UserID: e.User, Value: 1}}, nil
+		}
+		return dataPointsFromMessage(&slack.MessageEvent{
+			Msg: slack.Msg{Timestamp: e.Timestamp, Channel: e.Channel, User: e.User, Text: e.Text},
+		})
+	case JournalReaction:
+		ev := &slack.ReactionAddedEvent{User: e.User, Reaction: e.Reaction, EventTimestamp: e.Timestamp}
+		ev.Item.Channel = e.Channel
+		ev.Item.Timestamp = e.ItemTimestamp
+		return dataPointsFromReaction(ev)
+	}
+	return nil, errors.Errorf("unknown journal entry type '%s'", e.Type)
+}
+
+// Any Storer whose counters can be rebuilt from the journal
+type Reindexer interface {
+	// Remove every data point for the counter at or after the hour
+	DeleteCounter(ctx context.Context, counter, startHour string) error
+	// Add the data points to the counters without journaling them
+	AddDataPoints(dps []DataPoint) error
+}
+
+type ReindexStats struct {
+	Events     int `json:"events"`
+	DataPoints int `json:"data-points"`
+}
+
+// Rebuild the counter from the journal for every hour at or after 'since'. The journal is checked
+// before any data points are removed, as a counter can only be rebuilt if the journal includes
+// every event since that hour and, for counters derived from the message text, includes the text.
+func Reindex(ctx context.Context, store Storer, journal *Journal, counter string, since time.Time) (ReindexStats, error) {
+	var stats ReindexStats
+
+	if !slice.ContainsString(counter, validCounters, nil) {
+		return stats, errors.Errorf("invalid counter; must be one of '%s'", strings.Join(validCounters, ","))
+	}
+
+	reindexer, ok := store.(Reindexer)
+	if !ok {
+		return stats, errors.New("configured store does not support reindexing")
+	}
+
+	since = since.UTC().Truncate(time.Hour)
+	start, err := journal.Start()
+	if err != nil {
+		return stats, err
+	}
+	// The hour the journal was enabled in may include events counted before the journal was enabled
+	if since.Before(start) {
+		return stats, errors.Errorf("the journal was enabled at '%s'; cannot reindex before the "+
+			"journal was enabled", start.Format(time.RFC3339))
+	}
+
+	// Ensure the journal has what we need before we remove anything
+	err = journal.Replay(ctx, since, func(entry JournalEntry) error {
+		if entry.Type == JournalMessage && !entry.HasText && counter != "messages" {
+			return errors.Errorf("journal entry '%s' in channel '%s' does not include the message text "+
+				"required to recompute the '%s' counter", entry.Timestamp, entry.Channel, counter)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if err := reindexer.DeleteCounter(ctx, counter, since.Format(RFC3339Short)); err != nil {
+		return stats, errors.Wrapf(err, "while removing '%s' data points", counter)
+	}
+
+	err = journal.Replay(ctx, since, func(entry JournalEntry) error {
+		dps, err := entry.DataPoints()
+		if err != nil {
+			return err
+		}
+
+		var matched []DataPoint
+		for _, dp := range dps {
+			if dp.Counter == counter {
+				matched = append(matched, dp)
+			}
+		}
+
+		stats.Events++
+		if len(matched) == 0 {
+			return nil
+		}
+		stats.DataPoints += len(matched)
+		return reindexer.AddDataPoints(matched)
+	})
+	return stats, err
+}
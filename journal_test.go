@@ -0,0 +1,240 @@
+package channelstats_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestJournal(t *testing.T) {
+	suite.Run(t, new(JournalSuite))
+}
+
+type JournalSuite struct {
+	suite.Suite
+	conf    channelstats.Config
+	store   channelstats.Storer
+	journal *channelstats.Journal
+}
+
+func (s *JournalSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *JournalSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "channel-stats-journal")
+	s.Require().NoError(err)
+
+	s.conf = channelstats.Config{}
+	s.conf.Store.Backend = "memory"
+	s.conf.Journal.Enabled = true
+	s.conf.Journal.Dir = dir
+	s.conf.Journal.IncludeText = true
+	s.conf.Journal.EncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	s.enable("2018-12-13T00:00:00Z")
+	s.open()
+}
+
+func (s *JournalSuite) TearDownTest() {
+	s.NoError(s.store.Close())
+	s.NoError(s.journal.Close())
+	os.RemoveAll(s.conf.Journal.Dir)
+}
+
+func (s *JournalSuite) open() {
+	var err error
+	s.store, err = channelstats.NewStore(s.conf, &channelstats.MockIDManage{
+		UserByID: map[string]string{"U1": "joe", "U2": "scott"},
+	})
+	s.Require().NoError(err)
+	s.journal, err = channelstats.NewJournal(s.conf)
+	s.Require().NoError(err)
+}
+
+// Record the journal as enabled at the time
+func (s *JournalSuite) enable(at string) {
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.conf.Journal.Dir, "enabled"), []byte(at), 0600))
+}
+
+func (s *JournalSuite) reopen() {
+	s.Require().NoError(s.store.Close())
+	s.Require().NoError(s.journal.Close())
+	s.open()
+}
+
+func (s *JournalSuite) message(ts, text string) {
+	s.Require().NoError(s.store.HandleMessage(&slack.MessageEvent{
+		Msg: slack.Msg{Timestamp: ts, Channel: "C1", User: "U1", Text: text},
+	}))
+}
+
+func (s *JournalSuite) values(counter string) []string {
+	return s.channelValues("C1", counter)
+}
+
+func (s *JournalSuite) channelValues(channelID, counter string) []string {
+	var results []string
+	filter := channelstats.DataPointFilter{ChannelID: channelID, Counter: counter}
+	err := s.store.ForEach(context.Background(), filter, func(dp channelstats.DataPoint) error {
+		results = append(results, fmt.Sprintf("%s/%s/%d", dp.Hour, dp.UserID, dp.Value))
+		return nil
+	})
+	s.Require().NoError(err)
+	return results
+}
+
+func (s *JournalSuite) since(hour string) time.Time {
+	t, err := time.Parse(channelstats.RFC3339Short, hour)
+	s.Require().NoError(err)
+	return t
+}
+
+func (s *JournalSuite) TestReplay() {
+	s.message("1544659200.000100", "a secret link http://google.com")
+	// Empty messages are not counted so are not journaled
+	s.message("1544659200.000200", "")
+	ev := &slack.ReactionAddedEvent{User: "U2", Reaction: "smile", EventTimestamp: "1544745600.000300"}
+	ev.Item.Channel = "C1"
+	ev.Item.Timestamp = "1544659200.000100"
+	s.Require().NoError(s.store.HandleReactionAdded(ev))
+
+	// The journal is split by day and the text is encrypted
+	content, err := ioutil.ReadFile(filepath.Join(s.conf.Journal.Dir, "2018-12-13.ndjson"))
+	s.Require().NoError(err)
+	s.NotContains(string(content), "secret")
+	s.FileExists(filepath.Join(s.conf.Journal.Dir, "2018-12-14.ndjson"))
+
+	var entries []channelstats.JournalEntry
+	err = s.journal.Replay(context.Background(), time.Time{}, func(entry channelstats.JournalEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	s.Require().NoError(err)
+	s.Equal([]channelstats.JournalEntry{
+		{Type: channelstats.JournalMessage, Timestamp: "1544659200.000100", Channel: "C1", User: "U1",
+			Text: "a secret link http://google.com", HasText: true},
+		{Type: channelstats.JournalReaction, Timestamp: "1544745600.000300", Channel: "C1", User: "U2",
+			Reaction: "smile", ItemTimestamp: "1544659200.000100"},
+	}, entries)
+
+	// The journal begins when it was enabled
+	start, err := s.journal.Start()
+	s.Require().NoError(err)
+	s.Equal(s.since("2018-12-13T00"), start)
+}
+
+func (s *JournalSuite) TestRecordsWhenEnabled() {
+	s.Require().NoError(os.Remove(filepath.Join(s.conf.Journal.Dir, "enabled")))
+	s.reopen()
+
+	start, err := s.journal.Start()
+	s.Require().NoError(err)
+	s.WithinDuration(time.Now(), start, time.Minute)
+
+	// Opening the journal again does not move the start
+	s.reopen()
+	again, err := s.journal.Start()
+	s.Require().NoError(err)
+	s.Equal(start, again)
+}
+
+func (s *JournalSuite) TestReindex() {
+	s.message("1544659200.000100", "http://google.com")
+	s.message("1544662800.000200", "http://google.com")
+	s.message("1544662800.000300", "no link")
+
+	// Lose the 'link' counter for the second hour
+	reindexer := s.store.(channelstats.Reindexer)
+	s.Require().NoError(reindexer.DeleteCounter(context.Background(), "link", "2018-12-13T01"))
+	s.Equal([]string{"2018-12-13T00/U1/1"}, s.values("link"))
+
+	stats, err := channelstats.Reindex(context.Background(), s.store, s.journal, "link", s.since("2018-12-13T01"))
+	s.Require().NoError(err)
+	s.Equal(channelstats.ReindexStats{Events: 2, DataPoints: 1}, stats)
+	s.Equal([]string{"2018-12-13T00/U1/1", "2018-12-13T01/U1/1"}, s.values("link"))
+
+	// Reindexing is repeatable and does not touch other counters
+	_, err = channelstats.Reindex(context.Background(), s.store, s.journal, "link", s.since("2018-12-13T01"))
+	s.Require().NoError(err)
+	s.Equal([]string{"2018-12-13T00/U1/1", "2018-12-13T01/U1/1"}, s.values("link"))
+	s.Equal([]string{"2018-12-13T00/U1/1", "2018-12-13T01/U1/2"}, s.values("messages"))
+}
+
+func (s *JournalSuite) TestReindexRequiresJournal() {
+	s.enable("2018-12-13T01:30:00Z")
+	s.reopen()
+	s.message("1544664600.000100", "hello")
+
+	// Cannot reindex hours before the journal begins
+	_, err := channelstats.Reindex(context.Background(), s.store, s.journal, "messages", s.since("2018-12-12T23"))
+	s.Error(err)
+
+	// Nor the hour the journal began in, as it may include events counted before the journal was enabled
+	_, err = channelstats.Reindex(context.Background(), s.store, s.journal, "messages", s.since("2018-12-13T00"))
+	s.Error(err)
+	_, err = channelstats.Reindex(context.Background(), s.store, s.journal, "messages", s.since("2018-12-13T01"))
+	s.Error(err)
+	s.Equal([]string{"2018-12-13T01/U1/1"}, s.values("messages"))
+
+	_, err = channelstats.Reindex(context.Background(), s.store, s.journal, "not-a-counter", s.since("2018-12-13T00"))
+	s.Error(err)
+}
+
+func (s *JournalSuite) TestReindexRequiresText() {
+	s.conf.Journal.IncludeText = false
+	s.reopen()
+
+	s.message("1544659200.000100", "http://google.com")
+	s.message("1544662800.000100", "http://google.com")
+
+	// Counters derived from the text cannot be recomputed and nothing is removed
+	_, err := channelstats.Reindex(context.Background(), s.store, s.journal, "link", s.since("2018-12-13T01"))
+	s.Error(err)
+	s.Equal([]string{"2018-12-13T00/U1/1", "2018-12-13T01/U1/1"}, s.values("link"))
+
+	stats, err := channelstats.Reindex(context.Background(), s.store, s.journal, "messages", s.since("2018-12-13T01"))
+	s.Require().NoError(err)
+	s.Equal(channelstats.ReindexStats{Events: 1, DataPoints: 1}, stats)
+	s.Equal([]string{"2018-12-13T00/U1/1", "2018-12-13T01/U1/1"}, s.values("messages"))
+}
+
+func (s *JournalSuite) TestReindexWithBackfill() {
+	// Counters must survive reopening the store
+	s.conf.Store.Backend = "badger"
+	s.conf.Store.DataDir = filepath.Join(s.conf.Journal.Dir, "db")
+
+	// Counted in 'C2' before the journal was enabled
+	s.conf.Journal.Enabled = false
+	s.Require().NoError(os.Remove(filepath.Join(s.conf.Journal.Dir, "enabled")))
+	s.reopen()
+	s.Require().NoError(s.store.HandleMessage(&slack.MessageEvent{
+		Msg: slack.Msg{Timestamp: "1544663400.000100", Channel: "C2", User: "U2", Text: "hello"},
+	}))
+
+	// The journal was enabled a week later, and 'C1' was backfilled with an event from before then
+	s.conf.Journal.Enabled = true
+	s.enable("2018-12-20T00:00:00Z")
+	s.reopen()
+	s.message("1544660400.000100", "hello")
+	s.message("1545264000.000100", "hello")
+
+	// The backfilled event does not move the start of the journal
+	_, err := channelstats.Reindex(context.Background(), s.store, s.journal, "messages", s.since("2018-12-13T01"))
+	s.Error(err)
+	s.Equal([]string{"2018-12-13T01/U2/1"}, s.channelValues("C2", "messages"))
+	s.Equal([]string{"2018-12-13T00/U1/1", "2018-12-20T00/U1/1"}, s.values("messages"))
+
+	stats, err := channelstats.Reindex(context.Background(), s.store, s.journal, "messages", s.since("2018-12-20T00"))
+	s.Require().NoError(err)
+	s.Equal(channelstats.ReindexStats{Events: 1, DataPoints: 1}, stats)
+	s.Equal([]string{"2018-12-13T01/U2/1"}, s.channelValues("C2", "messages"))
+	s.Equal([]string{"2018-12-13T00/U1/1", "2018-12-20T00/U1/1"}, s.values("messages"))
+}
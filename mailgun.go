@@ -14,10 +14,20 @@ import (
 type ReportData struct {
 	// The HTML that makes up the body of the report
 	Html []byte
+	// The plain text alternative of the report, optional
+	Text []byte
+	// Email addresses the report is sent to, the configured report address is used if empty
+	Recipients []string
 	// The images that are the rendered
 	Images map[string][]byte
 }
 
+const (
+	MailBackendNone    = "none"
+	MailBackendMailgun = "mailgun"
+	MailBackendSMTP    = "smtp"
+)
+
 type Mailer interface {
 	Operator(string) error
 	Report(string, ReportData) error
@@ -30,12 +40,14 @@ type Mailgun struct {
 }
 
 func NewMailer(conf Config) (Mailer, error) {
-	if !conf.Mailgun.Enabled {
-		return &NullMailer{}, nil
+	switch conf.Mail.Backend {
+	case MailBackendMailgun:
+		mailgun.Debug = conf.Debug
+		return NewMailgunNotifier(conf)
+	case MailBackendSMTP:
+		return NewSMTPMailer(conf)
 	}
-
-	mailgun.Debug = conf.Debug
-	return NewMailgunNotifier(conf)
+	return &NullMailer{}, nil
 }
 
 func NewMailgunNotifier(conf Config) (Mailer, error) {
@@ -48,15 +60,23 @@ func NewMailgunNotifier(conf Config) (Mailer, error) {
 
 // Send a report to the designated email address (could be mailing list)
 func (m *Mailgun) Report(channelName string, data ReportData) error {
-	if m.conf.Mailgun.ReportAddr == "" {
-		m.log.Errorf("mailgun.enabled = true; however mailgun.report-address is empty; skipping..")
-		return nil
+	recipients := data.Recipients
+	if len(recipients) == 0 {
+		if m.conf.Mailgun.ReportAddr == "" {
+			m.log.Errorf("mailgun.enabled = true; however mailgun.report-address is empty; skipping..")
+			return nil
+		}
+		recipients = []string{m.conf.Mailgun.ReportAddr}
 	}
 
 	// Create a subject for the report
 	subject := fmt.Sprintf("[channel-stats] Report for %s", channelName)
-	// Create a message with no text body
-	message := m.mg.NewMessage(m.conf.Mailgun.From, subject, "", m.conf.Mailgun.ReportAddr)
+	// Mail clients which do not render HTML show the plain text alternative
+	text := data.Text
+	if len(text) == 0 {
+		text = htmlToText(data.Html)
+	}
+	message := m.mg.NewMessage(m.conf.Mailgun.From, subject, string(text), recipients...)
 	// Send the HTML to mailgun for MIME encoding
 	message.SetHtml(string(data.Html))
 
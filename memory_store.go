@@ -0,0 +1,301 @@
+package channelstats
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// A Storer which keeps all counters in memory, suitable for
+// testing and demos where data does not need to survive a restart
+type MemoryStore struct {
+	idMgr IDManager
+	log   *logrus.Entry
+	mutex sync.RWMutex
+	// Counters by DataPoint.PrefixKey() then by user id
+	counters map[string]map[string]int64
+	// Ids of every counted event, such that no event is counted twice. Maps to the hour
+	// each live event occurred in, empty for events counted via the Deduper interface
+	events  map[string]string
+	reports map[string]ArchivedReport
+	journal *Journal
+}
+
+func NewMemoryStore(conf Config, idMgr IDManager) (Storer, error) {
+	journal, err := NewJournal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryStore{
+		log:      GetLogger().WithField("prefix", "store"),
+		counters: make(map[string]map[string]int64),
+		events:   make(map[string]string),
+		reports:  make(map[string]ArchivedReport),
+		journal:  journal,
+		idMgr:    idMgr,
+	}, nil
+}
+
+func (s *MemoryStore) GetDataPoints(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]DataPoint, error) {
+	s.log.Debugf("GetDataPoints(%+v, %s, %s)", *timeRange, counter, channelID)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var results []DataPoint
+	for _, hour := range timeRange.ByHour() {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, "while getting data points")
+		}
+		dp := DataPoint{Hour: hour, Counter: counter, ChannelID: channelID}
+		results = append(results, s.byPrefix(dp)...)
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) SumByUser(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]SumResp, error) {
+	dataPoints, err := s.GetDataPoints(ctx, timeRange, channelID, counter)
+	if err != nil {
+		return nil, err
+	}
+	return sumByUser(dataPoints), nil
+}
+
+func (s *MemoryStore) PercentageByUser(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]PercentageResp, error) {
+	// Get the total number of messages for the channel during this time
+	messages, err := s.SumByUser(ctx, timeRange, channelID, "messages")
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the data type counts during this time
+	counters, err := s.SumByUser(ctx, timeRange, channelID, counter)
+	if err != nil {
+		return nil, err
+	}
+
+	return percentageByUser(messages, counters), nil
+}
+
+func (s *MemoryStore) ForEach(ctx context.Context, filter DataPointFilter, fn func(DataPoint) error) error {
+	// Collect the matches first, so fn() is not called while holding the lock
+	var results []DataPoint
+	s.mutex.RLock()
+	for _, dp := range s.dataPoints() {
+		if filter.Match(dp) {
+			results = append(results, dp)
+		}
+	}
+	s.mutex.RUnlock()
+
+	// Visit the data points in key order like the other stores
+	sort.Slice(results, func(i, j int) bool {
+		return string(results[i].Key()) < string(results[j].Key())
+	})
+
+	for _, dp := range results {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "while iterating data points")
+		}
+		if err := fn(dp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) HandleReactionAdded(ev *slack.ReactionAddedEvent) error {
+	_, err := s.handleReactionAdded(ev, true)
+	return err
+}
+
+func (s *MemoryStore) HandleMessage(ev *slack.MessageEvent) error {
+	_, err := s.handleMessage(ev, true)
+	return err
+}
+
+func (s *MemoryStore) HandleReactionAddedOnce(ev *slack.ReactionAddedEvent) (bool, error) {
+	return s.handleReactionAdded(ev, false)
+}
+
+func (s *MemoryStore) HandleMessageOnce(ev *slack.MessageEvent) (bool, error) {
+	return s.handleMessage(ev, false)
+}
+
+func (s *MemoryStore) handleReactionAdded(ev *slack.ReactionAddedEvent, live bool) (bool, error) {
+	dps, err := dataPointsFromReaction(ev)
+	if err != nil {
+		return false, errors.Wrap(err, "while handling reaction added")
+	}
+	if !s.saveDataPoints(reactionEventID(ev), liveEventHour(ev.EventTimestamp, live), dps) {
+		return false, nil
+	}
+	return true, s.journal.Reaction(ev, dps)
+}
+
+func (s *MemoryStore) handleMessage(ev *slack.MessageEvent, live bool) (bool, error) {
+	dps, err := dataPointsFromMessage(ev)
+	if err != nil {
+		return false, errors.Wrap(err, "while handling message")
+	}
+	if !s.saveDataPoints(messageEventID(ev), liveEventHour(ev.Timestamp, live), dps) {
+		return false, nil
+	}
+	return true, s.journal.Message(ev, dps)
+}
+
+func (s *MemoryStore) AddDataPoints(dps []DataPoint) error {
+	s.saveDataPoints("", "", dps)
+	return nil
+}
+
+func (s *MemoryStore) DeleteCounter(ctx context.Context, counter, startHour string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for prefix := range s.counters {
+		parts := strings.Split(prefix, "/")
+		if parts[0] >= startHour && parts[1] == counter {
+			delete(s.counters, prefix)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SaveReport(report ArchivedReport) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reports[report.ID] = report
+	return nil
+}
+
+func (s *MemoryStore) ListReports(ctx context.Context, channelID string) ([]ArchivedReport, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var results []ArchivedReport
+	for _, report := range s.reports {
+		if channelID != "" && report.ChannelID != channelID {
+			continue
+		}
+		summary, _ := report.split()
+		results = append(results, summary)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+	return results, nil
+}
+
+func (s *MemoryStore) GetReport(ctx context.Context, id string) (ArchivedReport, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	report, ok := s.reports[id]
+	if !ok {
+		return report, ErrReportNotFound
+	}
+	return report, nil
+}
+
+func (s *MemoryStore) DeleteReports(ctx context.Context, before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var deleted int
+	for id := range s.reports {
+		if reportCreatedBefore(id, before) {
+			delete(s.reports, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return s.journal.Close()
+}
+
+func (s *MemoryStore) PruneEvents(ctx context.Context, before time.Time) (int, error) {
+	beforeHour := before.UTC().Format(RFC3339Short)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var pruned int
+	for id, hour := range s.events {
+		if hour != "" && hour < beforeHour {
+			delete(s.events, id)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// Add the data points to the counters, if an eventID is provided the data points are only added if
+// the event has not been counted before. Live events record the hour they occurred in, such that
+// they can be pruned.
+func (s *MemoryStore) saveDataPoints(eventID, liveHour string, dps []DataPoint) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if eventID != "" {
+		if _, ok := s.events[eventID]; ok {
+			return false
+		}
+		s.events[eventID] = liveHour
+	}
+
+	for _, dp := range dps {
+		prefix := string(dp.PrefixKey())
+		users, ok := s.counters[prefix]
+		if !ok {
+			users = make(map[string]int64)
+			s.counters[prefix] = users
+		}
+		users[dp.UserID] += dp.Value
+	}
+	return true
+}
+
+// Returns every data point in the store, the caller must hold the read lock
+func (s *MemoryStore) dataPoints() []DataPoint {
+	var results []DataPoint
+	for prefix := range s.counters {
+		parts := strings.Split(prefix, "/")
+		dp := DataPoint{Hour: parts[0], Counter: parts[1], ChannelID: parts[2]}
+		results = append(results, s.byPrefix(dp)...)
+	}
+	return results
+}
+
+// Returns the data points for each user under the prefix sorted by user id,
+// the caller must hold the read lock
+func (s *MemoryStore) byPrefix(prefix DataPoint) []DataPoint {
+	users, ok := s.counters[string(prefix.PrefixKey())]
+	if !ok {
+		return nil
+	}
+
+	var results []DataPoint
+	for userID, value := range users {
+		dp := prefix
+		dp.UserID = userID
+		dp.Value = value
+		if err := dp.ResolveID(s.idMgr); err != nil {
+			s.log.Debugf("while resolving data point ids for '%+v': %s", dp, err)
+		}
+		results = append(results, dp)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].UserID < results[j].UserID
+	})
+	return results
+}
@@ -0,0 +1,54 @@
+package channelstats
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Notify the operator via the Mailer
+	NotifyOperator = "operator"
+	// Post to a slack channel
+	NotifySlack = "slack"
+)
+
+var notifyDestinations = []string{NotifyOperator, NotifySlack}
+
+// Delivers notifications meant for the people running channel-stats, such as anomalies and alerts
+type notifier struct {
+	list  ChanLister
+	mail  Mailer
+	slack *SlackAPI
+}
+
+func newNotifier(conf Config, list ChanLister, mail Mailer) *notifier {
+	return &notifier{
+		list:  list,
+		mail:  mail,
+		slack: NewSlackAPI(conf),
+	}
+}
+
+// Send the message to each of the destinations, slackChannel is the channel name or id the
+// message is posted to if the destinations include 'slack'
+func (n *notifier) Notify(destinations []string, slackChannel, msg string) error {
+	var failed []string
+	for _, dest := range destinations {
+		var err error
+		switch dest {
+		case NotifyOperator:
+			err = n.mail.Operator(msg)
+		case NotifySlack:
+			_, err = n.slack.PostMessage(SlackMessage{Channel: channelIDByName(n.list, slackChannel), Text: msg})
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", dest, err))
+		}
+	}
+	if len(failed) != 0 {
+		return errors.Errorf("failed to notify %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package channelstats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+// The slack events the bot handles which can be decoded from a recording or the Events API,
+// other events (connecting, latency reports, etc..) are skipped as they do not change the counters
+var eventMapping = map[string]interface{}{
+	"hello":           slack.HelloEvent{},
+	"message":         slack.MessageEvent{},
+	"reaction_added":  slack.ReactionAddedEvent{},
+	"channel_joined":  slack.ChannelJoinedEvent{},
+	"channel_rename":  slack.ChannelRenameEvent{},
+	"team_join":       slack.TeamJoinEvent{},
+	"user_change":     slack.UserChangeEvent{},
+	"channel_created": slack.ChannelCreatedEvent{},
+}
+
+// A single line in a recording
+type RecordedEvent struct {
+	// The time the event was received from slack
+	Time time.Time       `json:"time"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Records every incoming RTM event to a newline delimited JSON file
+type EventRecorder struct {
+	mutex sync.Mutex
+	fd    *os.File
+}
+
+// Returns nil if path is empty, all methods on a nil *EventRecorder are no-ops
+func NewEventRecorder(path string) (*EventRecorder, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	fd, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while opening event recording '%s'", path)
+	}
+	return &EventRecorder{fd: fd}, nil
+}
+
+func (r *EventRecorder) Record(ev slack.RTMEvent) error {
+	if r == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return errors.Wrapf(err, "while marshalling '%s' event", ev.Type)
+	}
+
+	line, err := json.Marshal(RecordedEvent{Time: time.Now().UTC(), Type: ev.Type, Data: data})
+	if err != nil {
+		return errors.Wrapf(err, "while marshalling '%s' event", ev.Type)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, err := r.fd.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "while writing event recording")
+	}
+	return nil
+}
+
+func (r *EventRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.fd.Close()
+}
+
+// Parse a replay speed like '10x' or '0.5x'. 'max' replays the events without waiting
+// between them and is returned as a speed of 0.
+func ParseReplaySpeed(speed string) (float64, error) {
+	if speed == "max" {
+		return 0, nil
+	}
+
+	result, err := strconv.ParseFloat(strings.TrimSuffix(speed, "x"), 64)
+	if err != nil || result <= 0 {
+		return 0, errors.Errorf("invalid speed '%s'; must be a multiplier like '10x' or 'max'", speed)
+	}
+	return result, nil
+}
+
+// Send the recorded events to the channel, waiting between each event as long as the time between
+// them when recorded divided by 'speed'. A speed of 0 sends the events as fast as they are consumed.
+// Returns the number of events sent.
+func ReplayEvents(ctx context.Context, r io.Reader, speed float64, events chan<- slack.RTMEvent) (int, error) {
+	var count int
+	var last time.Time
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var recorded RecordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &recorded); err != nil {
+			return count, errors.Wrapf(err, "while decoding event on line %d", line)
+		}
+
+		data, err := decodeEvent(recorded.Type, recorded.Data)
+		if err != nil {
+			return count, errors.Wrapf(err, "on line %d", line)
+		}
+		if data == nil {
+			continue
+		}
+
+		if speed != 0 && !last.IsZero() && recorded.Time.After(last) {
+			select {
+			case <-time.After(time.Duration(float64(recorded.Time.Sub(last)) / speed)):
+			case <-ctx.Done():
+				return count, ctx.Err()
+			}
+		}
+		last = recorded.Time
+
+		select {
+		case events <- slack.RTMEvent{Type: recorded.Type, Data: data}:
+			count++
+		case <-ctx.Done():
+			return count, ctx.Err()
+		}
+	}
+	return count, errors.Wrap(scanner.Err(), "while reading event recording")
+}
+
+// Decode the event into the type the bot expects, returns nil if the event type is not in 'eventMapping'
+func decodeEvent(eventType string, payload []byte) (interface{}, error) {
+	kind, ok := eventMapping[eventType]
+	if !ok {
+		return nil, nil
+	}
+
+	data := reflect.New(reflect.TypeOf(kind)).Interface()
+	if err := json.Unmarshal(payload, data); err != nil {
+		return nil, errors.Wrapf(err, "while decoding '%s' event", eventType)
+	}
+	return data, nil
+}
+
+// Feed the recorded events into the bot event handler as if they were received from slack,
+// counting them in the store. Returns the number of events replayed.
+func ReplayRecording(ctx context.Context, conf Config, store Storer, r io.Reader, speed float64) (int, error) {
+	// Replay must not reach out to slack
+	conf.Backfill.Enabled = false
+	bot := NewSlackBot(conf, store, &replayIDManage{}, &NullMailer{})
+	// Commands in the recording must not be answered again
+	bot.commands = nil
+	defer bot.backfill.Stop()
+
+	var count int
+	var err error
+	events := make(chan slack.RTMEvent)
+	go func() {
+		count, err = ReplayEvents(ctx, r, speed, events)
+		close(events)
+	}()
+
+	bot.handleEvents(events)
+	// Drain any remaining events should handleEvents() return early
+	for range events {
+	}
+	return count, err
+}
+
+// Resolves every user id to itself such that recorded events can be replayed without
+// the slack API. Messages without a user (such as bot messages) are still ignored.
+type replayIDManage struct {
+	MockIDManage
+}
+
+func (r *replayIDManage) GetUserName(id string) (string, error) {
+	if id == "" {
+		return "(unknown)", errors.New("message has no user")
+	}
+	return id, nil
+}
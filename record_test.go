@@ -0,0 +1,117 @@
+package channelstats_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestRecord(t *testing.T) {
+	suite.Run(t, new(RecordSuite))
+}
+
+type RecordSuite struct {
+	suite.Suite
+	store channelstats.Storer
+}
+
+func (s *RecordSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *RecordSuite) SetupTest() {
+	var conf channelstats.Config
+	conf.Store.Backend = "memory"
+
+	var err error
+	s.store, err = channelstats.NewStore(conf, &channelstats.MockIDManage{
+		UserByID: map[string]string{"U1": "joe", "U2": "scott"},
+	})
+	s.Require().NoError(err)
+}
+
+func (s *RecordSuite) sum(counter string) []channelstats.SumResp {
+	timeRange, err := channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T23")
+	s.Require().NoError(err)
+	sums, err := s.store.SumByUser(context.Background(), timeRange, "C1", counter)
+	s.Require().NoError(err)
+	return sums
+}
+
+func (s *RecordSuite) TestRecordAndReplay() {
+	dir, err := ioutil.TempDir("", "channel-stats-record")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.ndjson")
+
+	recorder, err := channelstats.NewEventRecorder(path)
+	s.Require().NoError(err)
+
+	reaction := &slack.ReactionAddedEvent{Type: "reaction_added", User: "U2", Reaction: "smile",
+		EventTimestamp: "1544659200.000200"}
+	reaction.Item.Channel = "C1"
+	reaction.Item.Timestamp = "1544659200.000100"
+
+	for _, ev := range []slack.RTMEvent{
+		{Type: "connecting", Data: &slack.ConnectingEvent{Attempt: 1}},
+		{Type: "message", Data: &slack.MessageEvent{Msg: slack.Msg{
+			Type: "message", Timestamp: "1544659200.000100", Channel: "C1", User: "U1", Text: "hello"}}},
+		{Type: "message", Data: &slack.MessageEvent{Msg: slack.Msg{
+			Type: "message", SubType: "bot_message", Timestamp: "1544659200.000300", Channel: "C1", Text: "beep"}}},
+		{Type: "reaction_added", Data: reaction},
+		{Type: "latency_report", Data: &slack.LatencyReport{Value: time.Second}},
+	} {
+		s.Require().NoError(recorder.Record(ev))
+	}
+	s.Require().NoError(recorder.Close())
+
+	fd, err := os.Open(path)
+	s.Require().NoError(err)
+	defer fd.Close()
+
+	count, err := channelstats.ReplayRecording(context.Background(), channelstats.Config{}, s.store, fd, 0)
+	s.Require().NoError(err)
+	s.Equal(3, count)
+
+	s.Equal([]channelstats.SumResp{{User: "joe", Sum: 1}}, s.sum("messages"))
+	s.Equal([]channelstats.SumResp{{User: "scott", Sum: 1}}, s.sum("emoji"))
+}
+
+func (s *RecordSuite) TestReplaySpeed() {
+	recording := `{"time": "2018-12-13T00:00:00Z", "type": "message", "data": {"type": "message", "ts": "1544659200.000100", "channel": "C1", "user": "U1", "text": "one"}}
+{"time": "2018-12-13T00:00:02Z", "type": "message", "data": {"type": "message", "ts": "1544659202.000100", "channel": "C1", "user": "U1", "text": "two"}}
+`
+	events := make(chan slack.RTMEvent, 2)
+	start := time.Now()
+	count, err := channelstats.ReplayEvents(context.Background(), bytes.NewBufferString(recording), 10, events)
+	s.Require().NoError(err)
+	s.Equal(2, count)
+
+	// 2 seconds at 10x is 200 milliseconds
+	s.True(time.Since(start) >= time.Millisecond*200, "replay did not wait between events")
+	s.True(time.Since(start) < time.Second, "replay waited too long between events")
+
+	ev := (<-events).Data.(*slack.MessageEvent)
+	s.Equal("one", ev.Text)
+}
+
+func (s *RecordSuite) TestParseReplaySpeed() {
+	for speed, expected := range map[string]float64{"10x": 10, "0.5x": 0.5, "2": 2, "max": 0} {
+		result, err := channelstats.ParseReplaySpeed(speed)
+		s.Require().NoError(err, speed)
+		s.Equal(expected, result, speed)
+	}
+
+	for _, speed := range []string{"", "0x", "-1x", "fast"} {
+		_, err := channelstats.ParseReplaySpeed(speed)
+		s.Error(err, speed)
+	}
+}
@@ -1,9 +1,11 @@
 package channelstats
 
 import (
+	"context"
 	"github.com/wcharczuk/go-chart"
 	"github.com/wcharczuk/go-chart/drawing"
 	"io"
+	"math"
 	"sort"
 )
 
@@ -22,18 +24,18 @@ func counterToColor(counter string) string {
 	}
 }
 
-func RenderPercentage(store Storer, w io.Writer, timeRange *TimeRange, channelID, counter string) error {
-	totals, err := store.PercentageByUser(timeRange, channelID, counter)
+func RenderPercentage(ctx context.Context, store Storer, w io.Writer, timeRange *TimeRange, channelID, counter string) error {
+	totals, err := store.PercentageByUser(ctx, timeRange, channelID, counter)
 	if err != nil {
 		return err
 	}
 
 	var dps []chart.Value
 
-	// Get at most 4 bars of data, if there are less than 4 use length instead
+	// Get at most 4 bars of data, if there are less than 4 use them all
 	offset := len(totals) - 4
-	if offset < 1 {
-		offset = len(totals)
+	if offset < 0 {
+		offset = 0
 	}
 
 	for _, item := range totals[offset:] {
@@ -47,18 +49,18 @@ func RenderPercentage(store Storer, w io.Writer, timeRange *TimeRange, channelID
 	return renderBarChart(w, dps, counterToColor(counter))
 }
 
-func RenderSum(store Storer, w io.Writer, timeRange *TimeRange, channelID, counter string) error {
-	totals, err := store.SumByUser(timeRange, channelID, counter)
+func RenderSum(ctx context.Context, store Storer, w io.Writer, timeRange *TimeRange, channelID, counter string) error {
+	totals, err := store.SumByUser(ctx, timeRange, channelID, counter)
 	if err != nil {
 		return err
 	}
 
 	var dps []chart.Value
 
-	// Get at most 4 bars of data, if there are less than 4 use length instead
+	// Get at most 4 bars of data, if there are less than 4 use them all
 	offset := len(totals) - 4
-	if offset < 1 {
-		offset = len(totals)
+	if offset < 0 {
+		offset = 0
 	}
 
 	for _, item := range totals[offset:] {
@@ -68,6 +70,22 @@ func RenderSum(store Storer, w io.Writer, timeRange *TimeRange, channelID, count
 	return renderBarChart(w, dps, counterToColor(counter))
 }
 
+// Render the total of the counter for each channel
+func RenderChannelTotals(ctx context.Context, store Storer, idMgr IDManager, w io.Writer, timeRange *TimeRange,
+	channels []string, counter string) error {
+	totals, err := ChannelTotals(ctx, store, timeRange, channels, counter)
+	if err != nil {
+		return err
+	}
+
+	var dps []chart.Value
+	for _, item := range totals {
+		dps = append(dps, chart.Value{Label: "#" + channelName(idMgr, item.ChannelID), Value: float64(item.Sum)})
+	}
+
+	return renderBarChart(w, dps, counterToColor(counter))
+}
+
 func renderBarChart(w io.Writer, bars []chart.Value, color string) error {
 
 	sbc := chart.BarChart{
@@ -100,6 +118,16 @@ func renderBarChart(w io.Writer, bars []chart.Value, color string) error {
 		},
 		Bars: bars,
 	}
+
+	// go-chart refuses to render a range of zero, as is the case for a single bar or
+	// bars of the same value, so start the axis at zero instead
+	min, max := math.MaxFloat64, -math.MaxFloat64
+	for _, bar := range bars {
+		min, max = math.Min(bar.Value, min), math.Max(bar.Value, max)
+	}
+	if min == max {
+		sbc.YAxis.Range = &chart.ContinuousRange{Min: math.Min(min, 0), Max: math.Max(max, 1)}
+	}
 	return sbc.Render(chart.PNG, w)
 }
 
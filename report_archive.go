@@ -0,0 +1,78 @@
+package channelstats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Returned by ReportArchiver.GetReport() if no report has the id
+var ErrReportNotFound = errors.New("report not found")
+
+// Archived report ids begin with the time the report was created in this format
+const reportIDTime = "20060102T150405Z"
+
+// A generated report as it was archived
+type ArchivedReport struct {
+	// Sorts by creation time, such that reports list in the order they were generated. The id
+	// ends with a random suffix, such that reports created in the same second do not collide.
+	ID        string    `json:"id"`
+	Channel   string    `json:"channel"`
+	ChannelID string    `json:"channel-id"`
+	StartHour string    `json:"start-hour"`
+	EndHour   string    `json:"end-hour"`
+	Created   time.Time `json:"created"`
+	// The numbers the report was rendered from
+	Model ReportModel `json:"model"`
+	// The rendered report, not included when listing reports
+	Html   []byte            `json:"html,omitempty"`
+	Images map[string][]byte `json:"images,omitempty"`
+}
+
+// The rendered report is stored apart from the rest of the report such that
+// listing reports does not load every image
+type archivedContent struct {
+	Html   []byte            `json:"html"`
+	Images map[string][]byte `json:"images"`
+}
+
+// Any Storer that can archive generated reports
+type ReportArchiver interface {
+	SaveReport(report ArchivedReport) error
+	// Returns the archived reports without the rendered report, oldest first.
+	// If channelID is not empty only reports for the channel are returned.
+	ListReports(ctx context.Context, channelID string) ([]ArchivedReport, error)
+	// Returns the archived report including the rendered report
+	GetReport(ctx context.Context, id string) (ArchivedReport, error)
+	// Delete the reports created before the time, returns the number of reports deleted
+	DeleteReports(ctx context.Context, before time.Time) (int, error)
+}
+
+func NewArchivedReport(report ChannelReport, created time.Time) ArchivedReport {
+	created = created.UTC()
+	return ArchivedReport{
+		ID:        fmt.Sprintf("%s-%s-%s", created.Format(reportIDTime), report.Channel.Id, randomID()[:8]),
+		Channel:   report.Channel.Name,
+		ChannelID: report.Channel.Id,
+		StartHour: report.TimeRange.StartDate(),
+		EndHour:   report.TimeRange.EndDate(),
+		Created:   created,
+		Model:     report.Model,
+		Html:      report.Data.Html,
+		Images:    report.Data.Images,
+	}
+}
+
+// Returns true if the report with the id was created before the time
+func reportCreatedBefore(id string, before time.Time) bool {
+	return id < before.UTC().Format(reportIDTime)
+}
+
+// Returns the report without the rendered report and the rendered report
+func (r ArchivedReport) split() (ArchivedReport, archivedContent) {
+	content := archivedContent{Html: r.Html, Images: r.Images}
+	r.Html, r.Images = nil, nil
+	return r, content
+}
@@ -0,0 +1,95 @@
+package channelstats
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Email the report via the Mailer
+	ReportDestinationEmail = "email"
+	// Post the report to a slack channel
+	ReportDestinationSlack = "slack"
+)
+
+var reportDestinations = []string{ReportDestinationEmail, ReportDestinationSlack}
+
+// A report generated for a channel
+type ChannelReport struct {
+	Channel   SlackChannelInfo
+	TimeRange *TimeRange
+	// The data the report templates were rendered with
+	Model ReportModel
+	Data  ReportData
+}
+
+// Delivers generated reports
+type ReportDestination interface {
+	Send(ctx context.Context, report ChannelReport) error
+}
+
+// Emails the report via the Mailer
+type EmailDestination struct {
+	mail Mailer
+}
+
+func NewEmailDestination(mail Mailer) *EmailDestination {
+	return &EmailDestination{mail: mail}
+}
+
+func (d *EmailDestination) Send(ctx context.Context, report ChannelReport) error {
+	return d.mail.Report(report.Channel.Name, report.Data)
+}
+
+// Posts the report to a slack channel as a Block Kit message, the charts are uploaded
+// to the thread of the message such that the report does not flood the channel.
+type SlackDestination struct {
+	api       *SlackAPI
+	channelID string
+}
+
+// Returns a destination which posts reports to the channel
+func NewSlackDestination(conf Config, channelID string) *SlackDestination {
+	return &SlackDestination{api: NewSlackAPI(conf), channelID: channelID}
+}
+
+func (d *SlackDestination) Send(ctx context.Context, report ChannelReport) error {
+	title := fmt.Sprintf("Channel report for #%s", report.Channel.Name)
+	period := fmt.Sprintf("%s to %s", report.TimeRange.Start.Format("Jan 2 15:04"),
+		report.TimeRange.End.Format("Jan 2 15:04 MST"))
+
+	var lines []string
+	for i, item := range report.Model.TopUsers["messages"] {
+		lines = append(lines, fmt.Sprintf("%d. *%s* — %d messages", i+1, item.User, item.Sum))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No messages were counted")
+	}
+
+	ts, err := d.api.PostMessage(SlackMessage{
+		Channel: d.channelID,
+		Text:    title,
+		Blocks: []Block{
+			headerBlock(title),
+			contextBlock(period),
+			sectionBlock("*Most Active*\n" + strings.Join(lines, "\n")),
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "while posting report to slack")
+	}
+
+	for _, chart := range reportCharts {
+		image, ok := report.Data.Images[chart.File()]
+		if !ok || len(image) == 0 {
+			continue
+		}
+		if err := d.api.UploadFile(d.channelID, ts, chart.File(), chart.Title, image); err != nil {
+			return errors.Wrapf(err, "while uploading '%s' to slack", chart.File())
+		}
+	}
+	return nil
+}
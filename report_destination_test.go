@@ -0,0 +1,116 @@
+package channelstats_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestReportDestination(t *testing.T) {
+	suite.Run(t, new(ReportDestinationSuite))
+}
+
+type ReportDestinationSuite struct {
+	suite.Suite
+	slack   *httptest.Server
+	conf    channelstats.Config
+	posts   []channelstats.SlackMessage
+	uploads []string
+	// Return this error from chat.postMessage
+	postErr string
+}
+
+func (s *ReportDestinationSuite) SetupTest() {
+	s.posts = nil
+	s.uploads = nil
+	s.postErr = ""
+
+	// A fake slack web API, requests are handled serially by the destination
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+		if s.postErr != "" {
+			fmt.Fprintf(w, `{"ok": false, "error": "%s"}`, s.postErr)
+			return
+		}
+		var msg channelstats.SlackMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_json"}`)
+			return
+		}
+		s.posts = append(s.posts, msg)
+		fmt.Fprint(w, `{"ok": true, "ts": "1544659200.000100"}`)
+	})
+	mux.HandleFunc("/files.upload", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_form_data"}`)
+			return
+		}
+		s.uploads = append(s.uploads, fmt.Sprintf("%s %s %s %s", r.FormValue("channels"),
+			r.FormValue("thread_ts"), r.FormValue("filename"), r.FormValue("title")))
+		fmt.Fprint(w, `{"ok": true}`)
+	})
+	s.slack = httptest.NewServer(mux)
+
+	s.conf = channelstats.Config{}
+	s.conf.Slack.APIURL = s.slack.URL
+}
+
+func (s *ReportDestinationSuite) TearDownTest() {
+	s.slack.Close()
+}
+
+func (s *ReportDestinationSuite) report() channelstats.ChannelReport {
+	return channelstats.ChannelReport{
+		Channel: channelstats.SlackChannelInfo{Name: "general", Id: "C1", IsMember: true},
+		TimeRange: &channelstats.TimeRange{
+			Start: time.Date(2018, 12, 6, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2018, 12, 13, 0, 0, 0, 0, time.UTC),
+		},
+		Model: channelstats.ReportModel{
+			TopUsers: map[string][]channelstats.SumResp{
+				"messages": {{User: "joe", Sum: 42}, {User: "scott", Sum: 7}},
+			},
+		},
+		Data: channelstats.ReportData{
+			Images: map[string][]byte{
+				"top-emoji.png":     []byte("emoji"),
+				"most-active.png":   []byte("active"),
+				"most-positive.png": nil,
+			},
+		},
+	}
+}
+
+func (s *ReportDestinationSuite) TestSlack() {
+	dest := channelstats.NewSlackDestination(s.conf, "C9")
+	s.Require().NoError(dest.Send(context.Background(), s.report()))
+
+	s.Require().Len(s.posts, 1)
+	msg := s.posts[0]
+	s.Equal("C9", msg.Channel)
+	s.Equal("Channel report for #general", msg.Text)
+	s.Require().Len(msg.Blocks, 3)
+	s.Equal("Dec 6 00:00 to Dec 13 00:00 UTC", msg.Blocks[1].Elements[0].Text)
+	s.Equal("*Most Active*\n1. *joe* — 42 messages\n2. *scott* — 7 messages", msg.Blocks[2].Text.Text)
+
+	// Charts are uploaded to the report thread in report order, empty charts are skipped
+	s.Equal([]string{
+		"C9 1544659200.000100 most-active.png Most Active",
+		"C9 1544659200.000100 top-emoji.png Top Emoji",
+	}, s.uploads)
+}
+
+func (s *ReportDestinationSuite) TestSlackError() {
+	s.postErr = "not_in_channel"
+	dest := channelstats.NewSlackDestination(s.conf, "C9")
+	err := dest.Send(context.Background(), s.report())
+	s.EqualError(err, "while posting report to slack: POST 'chat.postMessage' failed with slack error 'not_in_channel'")
+	s.Empty(s.uploads)
+}
@@ -0,0 +1,257 @@
+package channelstats
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"text/template"
+	"time"
+
+	"github.com/mailgun/holster/slice"
+	"github.com/pkg/errors"
+	"github.com/thrawn01/channel-stats/html"
+)
+
+const (
+	defaultReportTemplate     = "html/templates/email.tmpl"
+	defaultReportTextTemplate = "html/templates/email.txt.tmpl"
+	// Number of users listed for each counter in the report
+	reportTopUsers = 5
+)
+
+// The data passed to the report templates
+type ReportModel struct {
+	// Name of the channel the report is for
+	Channel   string `json:"channel"`
+	ChannelID string `json:"channel-id"`
+	// The period the report covers
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// The sections included in the report
+	Include map[string]bool `json:"include"`
+	// The charts included in the report, in report order
+	Charts []ReportChartModel `json:"charts"`
+	// The channel total for each counter keyed by counter name
+	Totals map[string]CounterTotal `json:"totals"`
+	// The users with the highest count for each counter keyed by counter name, highest first
+	TopUsers map[string][]SumResp `json:"top-users"`
+	// The top users for each counter compared to the previous period keyed by counter name
+	Changes map[string][]CompareResp `json:"changes"`
+	// The users who sent messages this period but not the previous period
+	Appeared []string `json:"appeared"`
+	// The users who sent messages the previous period but not this period
+	Disappeared []string  `json:"disappeared"`
+	Sentiment   Sentiment `json:"sentiment"`
+}
+
+type ReportChartModel struct {
+	Section string `json:"section"`
+	Title   string `json:"title"`
+	// The file name of the chart image, referenced in the HTML via 'cid:<file>'
+	File    string `json:"file"`
+	Counter string `json:"counter"`
+}
+
+// The total of a counter compared to the total for the previous period of the same length
+type CounterTotal struct {
+	Value    int64 `json:"value"`
+	Previous int64 `json:"previous"`
+	// Value - Previous
+	Change int64 `json:"change"`
+	// The change as a percentage of the previous period, zero if there was no previous value
+	PercentChange float64 `json:"percent-change"`
+}
+
+// Returns the change from the previous period formatted like '+12.5%', 'new' if the counter
+// had no previous value or 'no change'
+func (c CounterTotal) Delta() string {
+	switch {
+	case c.Previous == 0 && c.Value == 0:
+		return "no change"
+	case c.Previous == 0:
+		return "new"
+	case c.Change == 0:
+		return "no change"
+	}
+	return fmt.Sprintf("%+.1f%%", c.PercentChange)
+}
+
+// The percentage of messages counted as positive or negative
+type Sentiment struct {
+	Positive         float64 `json:"positive"`
+	Negative         float64 `json:"negative"`
+	PreviousPositive float64 `json:"previous-positive"`
+	PreviousNegative float64 `json:"previous-negative"`
+}
+
+// Build the report model for the channel, totals are compared to the period of the same length
+// immediately before the time range
+func NewReportModel(ctx context.Context, store Storer, channel SlackChannelInfo, timeRange *TimeRange,
+	sections []string) (ReportModel, error) {
+
+	model := ReportModel{
+		Channel:   channel.Name,
+		ChannelID: channel.Id,
+		Start:     timeRange.Start,
+		End:       timeRange.End,
+		Include:   make(map[string]bool),
+		Totals:    make(map[string]CounterTotal),
+		TopUsers:  make(map[string][]SumResp),
+		Changes:   make(map[string][]CompareResp),
+	}
+
+	for _, chart := range reportCharts {
+		if !slice.ContainsString(chart.Section, sections, nil) {
+			continue
+		}
+		model.Include[chart.Section] = true
+		model.Charts = append(model.Charts, ReportChartModel{
+			Section: chart.Section,
+			Title:   chart.Title,
+			File:    chart.File(),
+			Counter: chart.Counter,
+		})
+	}
+
+	previous, err := CompareRange(timeRange, ComparePrevious)
+	if err != nil {
+		return model, err
+	}
+
+	for _, counter := range validCounters {
+		current, err := ChannelTotals(ctx, store, timeRange, []string{channel.Id}, counter)
+		if err != nil {
+			return model, errors.Wrapf(err, "while fetching '%s' total", counter)
+		}
+		prev, err := ChannelTotals(ctx, store, previous, []string{channel.Id}, counter)
+		if err != nil {
+			return model, errors.Wrapf(err, "while fetching previous '%s' total", counter)
+		}
+		model.Totals[counter] = newCounterTotal(current[0].Sum, prev[0].Sum)
+
+		model.TopUsers[counter], err = TopUsers(ctx, store, timeRange, channel.Id, counter, reportTopUsers)
+		if err != nil {
+			return model, errors.Wrapf(err, "while fetching top users for '%s'", counter)
+		}
+
+		changes, err := compareUsers(ctx, store, timeRange, previous, channel.Id, counter)
+		if err != nil {
+			return model, err
+		}
+		if counter == "messages" {
+			model.Appeared = usersWithStatus(changes, UserAppeared)
+			model.Disappeared = usersWithStatus(changes, UserDisappeared)
+		}
+		// Users who disappeared have no value and sort last
+		for _, change := range changes {
+			if len(model.Changes[counter]) == reportTopUsers || change.Value == 0 {
+				break
+			}
+			model.Changes[counter] = append(model.Changes[counter], change)
+		}
+	}
+
+	messages := model.Totals["messages"]
+	model.Sentiment = Sentiment{
+		Positive:         percentOf(model.Totals["positive"].Value, messages.Value),
+		Negative:         percentOf(model.Totals["negative"].Value, messages.Value),
+		PreviousPositive: percentOf(model.Totals["positive"].Previous, messages.Previous),
+		PreviousNegative: percentOf(model.Totals["negative"].Previous, messages.Previous),
+	}
+	return model, nil
+}
+
+func compareUsers(ctx context.Context, store Storer, timeRange, previous *TimeRange,
+	channelID, counter string) ([]CompareResp, error) {
+
+	current, err := store.SumByUser(ctx, timeRange, channelID, counter)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching '%s' by user", counter)
+	}
+	prev, err := store.SumByUser(ctx, previous, channelID, counter)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching previous '%s' by user", counter)
+	}
+	return CompareSums(current, prev), nil
+}
+
+func newCounterTotal(value, previous int64) CounterTotal {
+	total := CounterTotal{Value: value, Previous: previous, Change: value - previous}
+	if previous != 0 {
+		total.PercentChange = float64(total.Change) / float64(previous) * 100
+	}
+	return total
+}
+
+func percentOf(value, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(value) / float64(total) * 100
+}
+
+// Functions available to the report templates
+var reportFuncs = map[string]interface{}{
+	// Format a percentage like '12.5%'
+	"percent": func(value float64) string {
+		return fmt.Sprintf("%.1f%%", value)
+	},
+	// Format a number with a sign like '+12'
+	"signed": func(value int64) string {
+		return fmt.Sprintf("%+d", value)
+	},
+}
+
+// Render the HTML and text versions of the report. The templates default to the
+// bundled email templates if the report config does not provide a template path.
+func RenderReport(conf ReportConfig, model ReportModel) ([]byte, []byte, error) {
+	htmlFile, textFile := conf.Template, conf.TextTemplate
+	if htmlFile == "" {
+		htmlFile = defaultReportTemplate
+	}
+	if textFile == "" {
+		textFile = defaultReportTextTemplate
+	}
+
+	content, err := html.Get(htmlFile)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "while reading template '%s'", htmlFile)
+	}
+	ht, err := htmltemplate.New("email").Funcs(htmltemplate.FuncMap(reportFuncs)).Parse(string(content))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "while parsing template '%s'", htmlFile)
+	}
+	htmlContent, err := executeTemplate(ht.Execute, model)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "while executing template '%s'", htmlFile)
+	}
+
+	content, err = html.Get(textFile)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "while reading template '%s'", textFile)
+	}
+	tt, err := template.New("email").Funcs(template.FuncMap(reportFuncs)).Parse(string(content))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "while parsing template '%s'", textFile)
+	}
+	textContent, err := executeTemplate(tt.Execute, model)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "while executing template '%s'", textFile)
+	}
+	return htmlContent, textContent, nil
+}
+
+func executeTemplate(execute func(w io.Writer, data interface{}) error, model ReportModel) ([]byte, error) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := execute(w, model); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, errors.Wrap(err, "while flushing template buffer")
+	}
+	return buf.Bytes(), nil
+}
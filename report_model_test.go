@@ -0,0 +1,141 @@
+package channelstats_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestReportModel(t *testing.T) {
+	suite.Run(t, new(ReportModelSuite))
+}
+
+type ReportModelSuite struct {
+	suite.Suite
+	store     channelstats.Storer
+	timeRange *channelstats.TimeRange
+}
+
+func (s *ReportModelSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *ReportModelSuite) SetupTest() {
+	var conf channelstats.Config
+	conf.Store.Backend = "memory"
+	idMgr := &channelstats.MockIDManage{UserByID: map[string]string{"U1": "joe", "U2": "scott"}}
+
+	var err error
+	s.store, err = channelstats.NewStore(conf, idMgr)
+	s.Require().NoError(err)
+
+	s.timeRange, err = channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T23")
+	s.Require().NoError(err)
+
+	reindexer, ok := s.store.(channelstats.Reindexer)
+	s.Require().True(ok)
+	s.Require().NoError(reindexer.AddDataPoints([]channelstats.DataPoint{
+		// The report period
+		{Hour: "2018-12-13T00", Counter: "messages", ChannelID: "C1", UserID: "U1", Value: 3},
+		{Hour: "2018-12-13T23", Counter: "messages", ChannelID: "C1", UserID: "U2", Value: 1},
+		{Hour: "2018-12-13T00", Counter: "positive", ChannelID: "C1", UserID: "U1", Value: 1},
+		{Hour: "2018-12-13T23", Counter: "negative", ChannelID: "C1", UserID: "U2", Value: 1},
+		{Hour: "2018-12-13T00", Counter: "link", ChannelID: "C1", UserID: "U1", Value: 2},
+		// The previous period
+		{Hour: "2018-12-12T00", Counter: "messages", ChannelID: "C1", UserID: "U1", Value: 2},
+		{Hour: "2018-12-12T23", Counter: "positive", ChannelID: "C1", UserID: "U1", Value: 2},
+		// Outside of both periods
+		{Hour: "2018-12-11T23", Counter: "messages", ChannelID: "C1", UserID: "U1", Value: 10},
+	}))
+}
+
+func (s *ReportModelSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *ReportModelSuite) model(sections []string) channelstats.ReportModel {
+	model, err := channelstats.NewReportModel(context.Background(), s.store,
+		channelstats.SlackChannelInfo{Name: "general", Id: "C1"}, s.timeRange, sections)
+	s.Require().NoError(err)
+	return model
+}
+
+func (s *ReportModelSuite) TestModel() {
+	model := s.model([]string{"most-active", "top-links"})
+
+	s.Equal("general", model.Channel)
+	s.Equal(map[string]bool{"most-active": true, "top-links": true}, model.Include)
+	s.Equal([]channelstats.ReportChartModel{
+		{Section: "most-active", Title: "Most Active", File: "most-active.png", Counter: "messages"},
+		{Section: "top-links", Title: "Top Links", File: "top-links.png", Counter: "link"},
+	}, model.Charts)
+
+	s.Equal(channelstats.CounterTotal{Value: 4, Previous: 2, Change: 2, PercentChange: 100}, model.Totals["messages"])
+	s.Equal("+100.0%", model.Totals["messages"].Delta())
+	s.Equal("-50.0%", model.Totals["positive"].Delta())
+	s.Equal("new", model.Totals["negative"].Delta())
+	s.Equal("no change", model.Totals["emoji"].Delta())
+
+	s.Equal([]channelstats.SumResp{{User: "joe", Sum: 3}, {User: "scott", Sum: 1}}, model.TopUsers["messages"])
+	s.Equal([]channelstats.SumResp{{User: "joe", Sum: 2}}, model.TopUsers["link"])
+	s.Empty(model.TopUsers["emoji"])
+
+	s.Equal([]channelstats.CompareResp{
+		{User: "joe", CounterTotal: channelstats.CounterTotal{Value: 3, Previous: 2, Change: 1, PercentChange: 50}},
+		{User: "scott", CounterTotal: channelstats.CounterTotal{Value: 1, Change: 1}, Status: "appeared"},
+	}, model.Changes["messages"])
+	// Users who disappeared are not listed with the top users
+	s.Equal([]channelstats.CompareResp{
+		{User: "joe", CounterTotal: channelstats.CounterTotal{Value: 1, Previous: 2, Change: -1, PercentChange: -50}},
+	}, model.Changes["positive"])
+	s.Equal([]string{"scott"}, model.Appeared)
+	s.Empty(model.Disappeared)
+
+	s.Equal(channelstats.Sentiment{Positive: 25, Negative: 25, PreviousPositive: 100}, model.Sentiment)
+}
+
+func (s *ReportModelSuite) TestRender() {
+	model := s.model([]string{"most-active", "most-positive", "most-negative", "top-links", "top-emoji"})
+
+	html, text, err := channelstats.RenderReport(channelstats.ReportConfig{}, model)
+	s.Require().NoError(err)
+
+	// Every chart is referenced by the HTML
+	for _, file := range []string{"most-active.png", "most-positive.png", "most-negative.png",
+		"top-links.png", "top-emoji.png"} {
+		s.Contains(string(html), `src="cid:`+file+`"`)
+	}
+	s.Contains(string(html), "Dec 13 00:00 to Dec 13 23:00 UTC")
+	s.Contains(string(html), "-50.0%")
+
+	s.Contains(string(text), "Channel Report for #general")
+	s.Contains(string(text), "messages: 4 (+100.0%)")
+	s.Contains(string(text), "Positive: 25.0% (was 100.0%)")
+	s.Contains(string(text), "Joined the conversation: scott\n")
+	s.Contains(string(text), "Most Active\n  joe: 3 (+50.0%)\n  scott: 1 (new)\n")
+	s.Contains(string(text), "Top Emoji\n  Nothing was counted\n")
+}
+
+func (s *ReportModelSuite) TestCustomTemplate() {
+	dir, err := ioutil.TempDir("", "report-template")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "report.txt.tmpl")
+	s.Require().NoError(ioutil.WriteFile(file,
+		[]byte(`{{ .Channel }} sent {{ .Totals.messages.Value }} messages ({{ .Totals.messages.Delta }})`), 0644))
+
+	_, text, err := channelstats.RenderReport(channelstats.ReportConfig{TextTemplate: file}, s.model(nil))
+	s.Require().NoError(err)
+	s.Equal("general sent 4 messages (+100.0%)", string(text))
+
+	s.Require().NoError(ioutil.WriteFile(file, []byte(`{{ .Channel `), 0644))
+	_, _, err = channelstats.RenderReport(channelstats.ReportConfig{TextTemplate: file}, s.model(nil))
+	s.Require().Error(err)
+	s.Contains(err.Error(), "while parsing template")
+}
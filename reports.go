@@ -3,16 +3,21 @@ package channelstats
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/mailgun/holster/slice"
 	"github.com/pkg/errors"
 	"github.com/robfig/cron"
 	"github.com/sirupsen/logrus"
-	"github.com/thrawn01/channel-stats/html"
-	"html/template"
 	"io"
+	"mime"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
-type RenderFunc func(store Storer, w io.Writer, timeRange *TimeRange, channelID, counter string) error
+type RenderFunc func(ctx context.Context, store Storer, w io.Writer, timeRange *TimeRange, channelID, counter string) error
 
 // Any struct that can return a list of channels to create reports for
 type ChanLister interface {
@@ -20,6 +25,11 @@ type ChanLister interface {
 }
 
 type Reporter interface {
+	// Generate the report for the channel name; if the time range is nil the report
+	// covers the report duration of the channel up until now
+	Generate(channelName string, timeRange *TimeRange) (ChannelReport, error)
+	// Deliver the report to the destinations configured for the channel
+	Send(report ChannelReport) error
 	Stop()
 }
 
@@ -32,8 +42,19 @@ type Report struct {
 	store Storer
 }
 
+// Create a reporter which sends reports on the configured schedules
 func NewReporter(conf Config, list ChanLister, notify Mailer, store Storer) (Reporter, error) {
-	r := Report{
+	r := newReport(conf, list, notify, store)
+	return r, r.start()
+}
+
+// Create a reporter which only generates and sends reports when asked, such as from the command line
+func NewManualReporter(conf Config, list ChanLister, notify Mailer, store Storer) Reporter {
+	return newReport(conf, list, notify, store)
+}
+
+func newReport(conf Config, list ChanLister, notify Mailer, store Storer) *Report {
+	return &Report{
 		log:   GetLogger().WithField("prefix", "reporter"),
 		cron:  cron.New(),
 		mail:  notify,
@@ -41,95 +62,300 @@ func NewReporter(conf Config, list ChanLister, notify Mailer, store Storer) (Rep
 		conf:  conf,
 		list:  list,
 	}
-	return &r, r.start()
 }
 
-func (r *Report) start() error {
-	err := r.cron.AddFunc(r.conf.Report.Schedule, func() {
-		timeRange := toTimeRange(r.conf.Report.ReportDuration.Duration)
-		r.log.Debugf("Creating report for %s to %s", timeRange.Start, timeRange.End)
-
-		for _, channel := range r.list.Channels() {
-			// Skip channels the bot is not in
-			if !channel.IsMember {
-				continue
-			}
+// The charts included in reports, each chart is a section which can be excluded via config
+var reportCharts = []reportChart{
+	{Section: "most-active", Title: "Most Active", Render: RenderSum, Counter: "messages"},
+	{Section: "most-positive", Title: "Most Positive", Render: RenderPercentage, Counter: "positive"},
+	{Section: "most-negative", Title: "Most Negative", Render: RenderPercentage, Counter: "negative"},
+	{Section: "top-links", Title: "Top Links", Render: RenderSum, Counter: "link"},
+	{Section: "top-emoji", Title: "Top Emoji", Render: RenderSum, Counter: "emoji"},
+}
 
-			html, err := r.genHtml("html/templates/email.tmpl", channel.Name)
-			if err != nil {
-				r.log.Errorf("during email generate: %s", err)
-				return
-			}
+type reportChart struct {
+	// The name of the section, the chart image is named '<section>.png'
+	Section string
+	Title   string
+	Render  RenderFunc
+	Counter string
+}
 
-			data := ReportData{
-				Images: make(map[string][]byte),
-				Html:   html,
-			}
+func (c reportChart) File() string {
+	return c.Section + ".png"
+}
 
-			// Generate the images for the report
-			data.Images["most-active.png"] = r.genImage(RenderSum, timeRange, channel.Id, "messages")
-			data.Images["top-links.png"] = r.genImage(RenderSum, timeRange, channel.Id, "link")
-			data.Images["top-emoji.png"] = r.genImage(RenderSum, timeRange, channel.Id, "emoji")
-			data.Images["most-negative.png"] = r.genImage(RenderPercentage, timeRange, channel.Id, "negative")
-			data.Images["most-positive.png"] = r.genImage(RenderPercentage, timeRange, channel.Id, "positive")
+// Returns the names of the report sections
+func reportSections() []string {
+	var results []string
+	for _, chart := range reportCharts {
+		results = append(results, chart.Section)
+	}
+	return results
+}
 
-			// Email the report
-			if err := r.mail.Report(channel.Name, data); err != nil {
-				r.log.Errorf("while sending report: %s", err)
-			}
+// The report settings for a channel
+type ReportRule struct {
+	Enabled      bool
+	Schedule     string
+	Duration     time.Duration
+	Destinations []string
+	SlackChannel string
+	// Email addresses the report is sent to, the mailer report address if empty
+	Recipients []string
+	Sections   []string
+}
+
+// Returns the report settings for the channel, settings not provided for
+// the channel in 'report.channels' default to the global report settings
+func (c ReportConfig) ChannelRule(channelName string) ReportRule {
+	rule := ReportRule{
+		Enabled:      true,
+		Schedule:     c.Schedule,
+		Duration:     c.ReportDuration.Duration,
+		Destinations: c.Destinations,
+		SlackChannel: c.SlackChannel,
+		Sections:     reportSections(),
+	}
+
+	channel, ok := c.Channels[channelName]
+	if !ok {
+		return rule
+	}
+	if channel.Enabled != nil {
+		rule.Enabled = *channel.Enabled
+	}
+	if channel.Schedule != "" {
+		rule.Schedule = channel.Schedule
+	}
+	if channel.ReportDuration.Duration != 0 {
+		rule.Duration = channel.ReportDuration.Duration
+	}
+	if len(channel.Destinations) != 0 {
+		rule.Destinations = channel.Destinations
+	}
+	if channel.SlackChannel != "" {
+		rule.SlackChannel = channel.SlackChannel
+	}
+	if len(channel.Recipients) != 0 {
+		rule.Recipients = channel.Recipients
+	}
+	if len(channel.Sections) != 0 {
+		rule.Sections = channel.Sections
+	}
+	return rule
+}
+
+func (r *Report) start() error {
+	// Schedule each distinct schedule once, each run reports on the channels with that schedule
+	schedules := []string{r.conf.Report.Schedule}
+	for _, channel := range r.conf.Report.Channels {
+		if channel.Schedule != "" && !slice.ContainsString(channel.Schedule, schedules, nil) {
+			schedules = append(schedules, channel.Schedule)
+		}
+	}
+
+	for _, schedule := range schedules {
+		schedule := schedule
+		err := r.cron.AddFunc(schedule, func() { r.run(schedule) })
+		if err != nil {
+			return errors.Wrapf(err, "while scheduling reports for '%s'", schedule)
 		}
-	})
-	if err != nil {
-		return err
 	}
 
 	r.cron.Start()
 	return nil
 }
 
-func (r *Report) Stop() {
-	r.cron.Stop()
-}
+// Generate and send reports for every channel with the schedule
+func (r *Report) run(schedule string) {
+	for _, channel := range r.list.Channels() {
+		// Skip channels the bot is not in
+		if !channel.IsMember {
+			continue
+		}
 
-func (r *Report) genImage(render RenderFunc, timeRange *TimeRange, channelID, counter string) []byte {
-	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
+		rule := r.conf.Report.ChannelRule(channel.Name)
+		if !rule.Enabled || rule.Schedule != schedule {
+			continue
+		}
 
-	if err := render(r.store, w, timeRange, channelID, counter); err != nil {
-		r.log.Errorf("while rendering image for channel: '%s' '%s': %s", channelID, counter, err)
+		timeRange := toTimeRange(rule.Duration)
+		r.log.Debugf("Creating report for '%s' from %s to %s", channel.Name, timeRange.Start, timeRange.End)
+
+		report, err := r.generate(channel, timeRange, rule)
+		if err != nil {
+			r.log.Errorf("during report generate: %s", err)
+			continue
+		}
+		if err := r.send(report, rule); err != nil {
+			r.log.Error(err)
+		}
 	}
+}
 
-	w.Flush()
+// Generate the report for the channel name now instead of waiting for the schedule
+func (r *Report) Generate(channelName string, timeRange *TimeRange) (ChannelReport, error) {
+	channel, ok := findChannel(r.list, channelName)
+	if !ok {
+		return ChannelReport{}, errors.Errorf("unknown channel '%s'", strings.TrimPrefix(channelName, "#"))
+	}
 
-	return buf.Bytes()
+	rule := r.conf.Report.ChannelRule(channel.Name)
+	if timeRange == nil {
+		timeRange = toTimeRange(rule.Duration)
+	}
+	return r.generate(channel, timeRange, rule)
 }
 
-func (r *Report) genHtml(file string, chanName string) ([]byte, error) {
-	type Data struct {
-		Name string
+// Deliver the report now to the destinations configured for the channel
+func (r *Report) Send(report ChannelReport) error {
+	return r.send(report, r.conf.Report.ChannelRule(report.Channel.Name))
+}
+
+// Generate the report for the channel including the sections of the rule
+func (r *Report) generate(channel SlackChannelInfo, timeRange *TimeRange, rule ReportRule) (ChannelReport, error) {
+	report := ChannelReport{Channel: channel, TimeRange: timeRange}
+
+	var err error
+	report.Model, err = NewReportModel(context.Background(), r.store, channel, timeRange, rule.Sections)
+	if err != nil {
+		return report, err
 	}
 
-	content, err := html.Get(file)
+	report.Data = ReportData{
+		Images:     make(map[string][]byte),
+		Recipients: rule.Recipients,
+	}
+	report.Data.Html, report.Data.Text, err = RenderReport(r.conf.Report, report.Model)
 	if err != nil {
-		return nil, errors.Wrapf(err, "while reading asset file '%s'", file)
+		return report, err
 	}
 
-	t, err := template.New("email").Parse(string(content))
+	// Generate the images for the report
+	for _, chart := range reportCharts {
+		if !report.Model.Include[chart.Section] {
+			continue
+		}
+		report.Data.Images[chart.File()] = r.genImage(chart.Render, timeRange, channel.Id, chart.Counter)
+	}
+	return report, nil
+}
+
+// Deliver the report to each of the destinations of the rule, a failed
+// destination does not prevent delivery to the remaining destinations
+func (r *Report) send(report ChannelReport, rule ReportRule) error {
+	r.archive(report)
+
+	var failed []string
+	for _, name := range rule.Destinations {
+		var dest ReportDestination
+		switch name {
+		case ReportDestinationEmail:
+			dest = NewEmailDestination(r.mail)
+		case ReportDestinationSlack:
+			channelID := report.Channel.Id
+			if rule.SlackChannel != "" {
+				channelID = r.channelID(rule.SlackChannel)
+			}
+			dest = NewSlackDestination(r.conf, channelID)
+		default:
+			r.log.Errorf("unknown report destination '%s'", name)
+			failed = append(failed, name)
+			continue
+		}
+
+		if err := dest.Send(context.Background(), report); err != nil {
+			r.log.Errorf("while sending report for '%s' to %s: %s", report.Channel.Name, name, err)
+			failed = append(failed, name)
+		}
+	}
+
+	if len(failed) != 0 {
+		return errors.Errorf("failed to send report for '%s' to '%s'", report.Channel.Name,
+			strings.Join(failed, "', '"))
+	}
+	return nil
+}
+
+// Archive the report if the store supports archiving, failing to archive does not prevent delivery
+func (r *Report) archive(report ChannelReport) {
+	archiver, ok := r.store.(ReportArchiver)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if err := archiver.SaveReport(NewArchivedReport(report, now)); err != nil {
+		r.log.Errorf("while archiving report for '%s': %s", report.Channel.Name, err)
+	}
+
+	retention := r.conf.Report.ArchiveRetention.Duration
+	if retention <= 0 {
+		return
+	}
+	deleted, err := archiver.DeleteReports(context.Background(), now.Add(-retention))
 	if err != nil {
-		return nil, errors.Wrapf(err, "while parsing template '%s'", file)
+		r.log.Errorf("while deleting archived reports older than %s: %s", retention, err)
+		return
+	}
+	if deleted != 0 {
+		r.log.Infof("Deleted %d archived reports older than %s", deleted, retention)
 	}
+}
+
+// Returns the id of the channel name, or the name if it is not a known channel name
+func (r *Report) channelID(name string) string {
+	return channelIDByName(r.list, name)
+}
+
+// Returns the id of the channel name, or the name if it is not a known channel name
+func channelIDByName(list ChanLister, name string) string {
+	if channel, ok := findChannel(list, name); ok {
+		return channel.Id
+	}
+	return strings.TrimPrefix(name, "#")
+}
+
+// Returns the channel with the name, the name may start with '#'
+func findChannel(list ChanLister, name string) (SlackChannelInfo, bool) {
+	name = strings.TrimPrefix(name, "#")
+	for _, channel := range list.Channels() {
+		if channel.Name == name {
+			return channel, true
+		}
+	}
+	return SlackChannelInfo{}, false
+}
+
+func (r *Report) Stop() {
+	r.cron.Stop()
+}
 
+func (r *Report) genImage(render RenderFunc, timeRange *TimeRange, channelID, counter string) []byte {
 	var buf bytes.Buffer
 	w := bufio.NewWriter(&buf)
-	if err = t.Execute(w, Data{Name: chanName}); err != nil {
-		return nil, errors.Wrapf(err, "while executing template '%s'", file)
-	}
 
-	if err = w.Flush(); err != nil {
-		return nil, errors.Wrap(err, "while flushing template buffer")
+	if err := render(context.Background(), r.store, w, timeRange, channelID, counter); err != nil {
+		r.log.Errorf("while rendering image for channel: '%s' '%s': %s", channelID, counter, err)
 	}
 
-	return buf.Bytes(), nil
+	w.Flush()
+
+	return buf.Bytes()
+}
+
+// Returns the report HTML with the images referenced via 'cid:<file>' replaced by data URIs
+// such that the report can be viewed in a browser
+func InlineImages(html []byte, images map[string][]byte) []byte {
+	for file, image := range images {
+		contentType := mime.TypeByExtension(filepath.Ext(file))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		uri := fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(image))
+		html = bytes.Replace(html, []byte("cid:"+file), []byte(uri), -1)
+	}
+	return html
 }
 
 func toTimeRange(duration time.Duration) *TimeRange {
@@ -0,0 +1,202 @@
+package channelstats_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mailgun/holster/clock"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestReports(t *testing.T) {
+	suite.Run(t, new(ReportsSuite))
+}
+
+type ReportsSuite struct {
+	suite.Suite
+	store  channelstats.Storer
+	mailer *reportMailer
+	conf   channelstats.Config
+}
+
+// Records the reports emailed
+type reportMailer struct {
+	reports map[string]channelstats.ReportData
+	err     error
+}
+
+func (m *reportMailer) Report(channelName string, data channelstats.ReportData) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.reports[channelName] = data
+	return nil
+}
+
+func (m *reportMailer) Operator(msg string) error { return nil }
+
+type channelList []channelstats.SlackChannelInfo
+
+func (l channelList) Channels() []channelstats.SlackChannelInfo { return l }
+
+func (s *ReportsSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *ReportsSuite) SetupTest() {
+	s.conf = channelstats.Config{}
+	s.conf.Store.Backend = "memory"
+	s.conf.Report.Schedule = "0 0 0 * * SUN"
+	s.conf.Report.ReportDuration = clock.DurationJSON{Duration: time.Hour * 168}
+	s.conf.Report.Destinations = []string{"email"}
+	s.conf.Report.Channels = map[string]channelstats.ReportChannelConfig{
+		"incidents": {Sections: []string{"most-negative"}},
+	}
+
+	var err error
+	idMgr := &channelstats.MockIDManage{UserByID: map[string]string{"U1": "joe"}}
+	s.store, err = channelstats.NewStore(s.conf, idMgr)
+	s.Require().NoError(err)
+	s.mailer = &reportMailer{reports: make(map[string]channelstats.ReportData)}
+
+	reindexer, ok := s.store.(channelstats.Reindexer)
+	s.Require().True(ok)
+	s.Require().NoError(reindexer.AddDataPoints([]channelstats.DataPoint{
+		{Hour: "2018-12-13T00", Counter: "messages", ChannelID: "C2", UserID: "U1", Value: 3},
+	}))
+}
+
+func (s *ReportsSuite) TearDownTest() {
+	s.store.Close()
+}
+
+func (s *ReportsSuite) reporter() channelstats.Reporter {
+	list := channelList{
+		{Name: "general", Id: "C1", IsMember: true},
+		{Name: "incidents", Id: "C2", IsMember: true},
+	}
+	reporter, err := channelstats.NewReporter(s.conf, list, s.mailer, s.store)
+	s.Require().NoError(err)
+	return reporter
+}
+
+func (s *ReportsSuite) TestChannelRule() {
+	disabled := false
+	conf := channelstats.ReportConfig{
+		Schedule:       "0 0 0 * * SUN",
+		ReportDuration: clock.DurationJSON{Duration: time.Hour * 168},
+		Destinations:   []string{"email"},
+		Channels: map[string]channelstats.ReportChannelConfig{
+			"incidents": {
+				Schedule:       "0 0 9 * * *",
+				ReportDuration: clock.DurationJSON{Duration: time.Hour * 24},
+				Recipients:     []string{"sre@example.com"},
+				Sections:       []string{"most-active", "most-negative"},
+				Destinations:   []string{"email", "slack"},
+			},
+			"random": {Enabled: &disabled},
+		},
+	}
+
+	// Channels without settings use the global settings
+	s.Equal(channelstats.ReportRule{
+		Enabled:      true,
+		Schedule:     "0 0 0 * * SUN",
+		Duration:     time.Hour * 168,
+		Destinations: []string{"email"},
+		Sections:     []string{"most-active", "most-positive", "most-negative", "top-links", "top-emoji"},
+	}, conf.ChannelRule("general"))
+
+	s.Equal(channelstats.ReportRule{
+		Enabled:      true,
+		Schedule:     "0 0 9 * * *",
+		Duration:     time.Hour * 24,
+		Destinations: []string{"email", "slack"},
+		Recipients:   []string{"sre@example.com"},
+		Sections:     []string{"most-active", "most-negative"},
+	}, conf.ChannelRule("incidents"))
+
+	random := conf.ChannelRule("random")
+	s.False(random.Enabled)
+	s.Equal("0 0 0 * * SUN", random.Schedule)
+}
+
+func (s *ReportsSuite) TestGenerateAndSend() {
+	reporter := s.reporter()
+	defer reporter.Stop()
+
+	timeRange, err := channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T23")
+	s.Require().NoError(err)
+
+	report, err := reporter.Generate("#incidents", timeRange)
+	s.Require().NoError(err)
+	s.Equal("C2", report.Channel.Id)
+	s.Equal(timeRange, report.TimeRange)
+	s.Equal(int64(3), report.Model.Totals["messages"].Value)
+
+	// Only the sections configured for the channel are generated
+	s.Equal([]string{"most-negative.png"}, imageFiles(report.Data.Images))
+	s.Contains(string(report.Data.Html), "cid:most-negative.png")
+
+	s.Require().NoError(reporter.Send(report))
+	s.Contains(s.mailer.reports, "incidents")
+
+	// Sent reports are archived
+	reports, err := s.store.(channelstats.ReportArchiver).ListReports(context.Background(), "C2")
+	s.Require().NoError(err)
+	s.Require().Len(reports, 1)
+	s.Equal("2018-12-13T00", reports[0].StartHour)
+
+	// The report covers the report duration when no time range is provided
+	report, err = reporter.Generate("general", nil)
+	s.Require().NoError(err)
+	s.Equal(time.Hour*168, report.TimeRange.End.Sub(report.TimeRange.Start))
+
+	_, err = reporter.Generate("unknown", nil)
+	s.EqualError(err, "unknown channel 'unknown'")
+
+	s.mailer.err = errors.New("mailgun is down")
+	s.EqualError(reporter.Send(report), "failed to send report for 'general' to 'email'")
+}
+
+func (s *ReportsSuite) TestArchiveRetention() {
+	s.conf.Report.ArchiveRetention = clock.DurationJSON{Duration: time.Hour * 24}
+	reporter := s.reporter()
+	defer reporter.Stop()
+
+	timeRange, err := channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T23")
+	s.Require().NoError(err)
+
+	archiver := s.store.(channelstats.ReportArchiver)
+	s.Require().NoError(archiver.SaveReport(channelstats.NewArchivedReport(channelstats.ChannelReport{
+		Channel:   channelstats.SlackChannelInfo{Name: "incidents", Id: "C2"},
+		TimeRange: timeRange,
+	}, time.Now().Add(-time.Hour*48))))
+
+	report, err := reporter.Generate("incidents", timeRange)
+	s.Require().NoError(err)
+	s.Require().NoError(reporter.Send(report))
+
+	// Archiving the report deletes the reports older than the retention
+	reports, err := archiver.ListReports(context.Background(), "")
+	s.Require().NoError(err)
+	s.Require().Len(reports, 1)
+	s.WithinDuration(time.Now(), reports[0].Created, time.Minute)
+}
+
+func (s *ReportsSuite) TestInlineImages() {
+	html := channelstats.InlineImages([]byte(`<img src="cid:most-active.png"/><img src="cid:top-links.png"/>`),
+		map[string][]byte{"most-active.png": []byte("PNG")})
+	s.Equal(`<img src="data:image/png;base64,UE5H"/><img src="cid:top-links.png"/>`, string(html))
+}
+
+func imageFiles(images map[string][]byte) []string {
+	var files []string
+	for file := range images {
+		files = append(files, file)
+	}
+	return files
+}
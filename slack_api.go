@@ -0,0 +1,160 @@
+package channelstats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A Block Kit layout block (See https://api.slack.com/reference/block-kit/blocks)
+type Block struct {
+	Type     string       `json:"type"`
+	Text     *TextObject  `json:"text,omitempty"`
+	Fields   []TextObject `json:"fields,omitempty"`
+	Elements []TextObject `json:"elements,omitempty"`
+}
+
+type TextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func markdown(text string) *TextObject {
+	return &TextObject{Type: "mrkdwn", Text: text}
+}
+
+func headerBlock(text string) Block {
+	return Block{Type: "header", Text: &TextObject{Type: "plain_text", Text: text}}
+}
+
+func sectionBlock(text string) Block {
+	return Block{Type: "section", Text: markdown(text)}
+}
+
+func contextBlock(text string) Block {
+	return Block{Type: "context", Elements: []TextObject{*markdown(text)}}
+}
+
+// A message posted via chat.postMessage or in response to a slash command
+type SlackMessage struct {
+	Channel  string `json:"channel,omitempty"`
+	ThreadTS string `json:"thread_ts,omitempty"`
+	// Either 'ephemeral' or 'in_channel' when responding to a slash command
+	ResponseType string `json:"response_type,omitempty"`
+	// Shown in notifications and by clients that can not display blocks
+	Text   string  `json:"text"`
+	Blocks []Block `json:"blocks,omitempty"`
+}
+
+// The fields common to every slack web API response
+type slackResp struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// A minimal client for the slack web API methods the bot uses to post messages and charts
+type SlackAPI struct {
+	token  string
+	apiURL string
+	client *http.Client
+}
+
+func NewSlackAPI(conf Config) *SlackAPI {
+	return &SlackAPI{
+		token:  conf.Slack.Token,
+		apiURL: conf.Slack.APIURL,
+		client: &http.Client{Timeout: time.Second * 30},
+	}
+}
+
+// Post the message to the channel, returns the timestamp of the posted message
+func (a *SlackAPI) PostMessage(msg SlackMessage) (string, error) {
+	var resp struct {
+		slackResp
+		TS string `json:"ts"`
+	}
+	return resp.TS, a.postJSON("chat.postMessage", msg, &resp, &resp.slackResp)
+}
+
+// Returns the id of the direct message channel between the bot and the user
+func (a *SlackAPI) OpenDM(userID string) (string, error) {
+	var resp struct {
+		slackResp
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	err := a.postJSON("conversations.open", map[string]string{"users": userID}, &resp, &resp.slackResp)
+	return resp.Channel.ID, err
+}
+
+// Returns the user id of the bot
+func (a *SlackAPI) AuthTest() (string, error) {
+	var resp struct {
+		slackResp
+		UserID string `json:"user_id"`
+	}
+	return resp.UserID, a.postJSON("auth.test", struct{}{}, &resp, &resp.slackResp)
+}
+
+// Upload the file and share it in the channel, or the thread if 'threadTS' is provided
+func (a *SlackAPI) UploadFile(channelID, threadTS, filename, title string, content []byte) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("channels", channelID)
+	mw.WriteField("filename", filename)
+	mw.WriteField("title", title)
+	if threadTS != "" {
+		mw.WriteField("thread_ts", threadTS)
+	}
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return errors.Wrap(err, "while creating files.upload request")
+	}
+	fw.Write(content)
+	if err := mw.Close(); err != nil {
+		return errors.Wrap(err, "while creating files.upload request")
+	}
+
+	var resp slackResp
+	return a.post("files.upload", mw.FormDataContentType(), &body, &resp, &resp)
+}
+
+func (a *SlackAPI) postJSON(method string, req interface{}, resp interface{}, status *slackResp) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrapf(err, "while marshalling '%s' request", method)
+	}
+	return a.post(method, "application/json; charset=utf-8", bytes.NewReader(body), resp, status)
+}
+
+func (a *SlackAPI) post(method, contentType string, body io.Reader, resp interface{}, status *slackResp) error {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s", a.apiURL, method), body)
+	if err != nil {
+		return errors.Wrapf(err, "while creating request for '%s'", method)
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("Content-Type", contentType)
+
+	r, err := a.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "POST '%s' failed", method)
+	}
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return errors.Wrapf(err, "POST '%s' failed with '%d' during json decode", method, r.StatusCode)
+	}
+
+	// Handle slack error
+	if !status.Ok {
+		return errors.Errorf("POST '%s' failed with slack error '%s'", method, status.Error)
+	}
+	return nil
+}
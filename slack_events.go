@@ -0,0 +1,185 @@
+package channelstats
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Receive events via the legacy RTM websocket
+	SlackModeRTM = "rtm"
+	// Receive events via HTTP push to /slack/events
+	SlackModeEvents = "events"
+
+	// Requests with a timestamp older than this are rejected to prevent replay attacks
+	slackSignatureMaxAge = time.Minute * 5
+	// Largest request body we accept from slack
+	slackMaxBodySize = 1 << 20
+	// Slack expects an ack within 3 seconds, give up queuing the event before then
+	slackQueueTimeout = time.Second * 2
+)
+
+// The outer payload of every Events API request
+type slackEventsPayload struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+	// The bot user the event was delivered for
+	AuthedUsers    []string `json:"authed_users"`
+	Authorizations []struct {
+		UserID string `json:"user_id"`
+	} `json:"authorizations"`
+}
+
+// Sent by the Events API when a user joins a channel, RTM sends 'channel_joined' when the bot joins instead
+type memberJoinedChannelEvent struct {
+	User    string `json:"user"`
+	Channel string `json:"channel"`
+}
+
+// Receives events pushed from slack via the Events API and dispatches them to the bot
+type SlackEventsHandler struct {
+	log  *logrus.Entry
+	conf Config
+	bot  *SlackBot
+}
+
+func NewSlackEventsHandler(conf Config, bot *SlackBot) *SlackEventsHandler {
+	return &SlackEventsHandler{
+		log:  GetLogger().WithField("prefix", "slack-events"),
+		conf: conf,
+		bot:  bot,
+	}
+}
+
+func (h *SlackEventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := verifySlackRequest(r, h.conf.Slack.SigningSecret)
+	if err != nil {
+		abort(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var payload slackEventsPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		abort(w, errors.Wrap(err, "while decoding slack event"), http.StatusBadRequest)
+		return
+	}
+
+	switch payload.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(payload.Challenge))
+		return
+	case "event_callback":
+	default:
+		// Acknowledge payloads we do not handle ('app_rate_limited', etc..) so slack does not retry them
+		h.log.Debugf("Ignoring '%s' payload", payload.Type)
+		return
+	}
+
+	ev, err := payload.rtmEvent()
+	if err != nil {
+		abort(w, err, http.StatusBadRequest)
+		return
+	}
+	if ev == nil {
+		return
+	}
+
+	if err := h.bot.Dispatch(payload.EventID, *ev); err != nil {
+		abort(w, err, http.StatusServiceUnavailable)
+	}
+}
+
+// Convert the event into the RTM event the bot expects, returns nil if the bot does not handle the event
+func (p slackEventsPayload) rtmEvent() (*slack.RTMEvent, error) {
+	var inner struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(p.Event, &inner); err != nil {
+		return nil, errors.Wrap(err, "while decoding slack event")
+	}
+
+	if inner.Type == "member_joined_channel" {
+		var joined memberJoinedChannelEvent
+		if err := json.Unmarshal(p.Event, &joined); err != nil {
+			return nil, errors.Wrap(err, "while decoding 'member_joined_channel' event")
+		}
+		if !p.isBotUser(joined.User) {
+			return nil, nil
+		}
+		ev := &slack.ChannelJoinedEvent{Type: "channel_joined"}
+		ev.Channel.ID = joined.Channel
+		return &slack.RTMEvent{Type: ev.Type, Data: ev}, nil
+	}
+
+	data, err := decodeEvent(inner.Type, p.Event)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return &slack.RTMEvent{Type: inner.Type, Data: data}, nil
+}
+
+func (p slackEventsPayload) isBotUser(userID string) bool {
+	for _, id := range p.AuthedUsers {
+		if id == userID {
+			return true
+		}
+	}
+	for _, auth := range p.Authorizations {
+		if auth.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify the request was signed by slack with the signing secret and return the request body
+// (See https://api.slack.com/authentication/verifying-requests-from-slack)
+func verifySlackRequest(r *http.Request, secret string) ([]byte, error) {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	signature := r.Header.Get("X-Slack-Signature")
+	if timestamp == "" || signature == "" {
+		return nil, errors.New("missing 'X-Slack-Signature' or 'X-Slack-Request-Timestamp'")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid 'X-Slack-Request-Timestamp'")
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > slackSignatureMaxAge || age < -slackSignatureMaxAge {
+		return nil, errors.New("'X-Slack-Request-Timestamp' is too old")
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, slackMaxBodySize))
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading slack request")
+	}
+
+	if !hmac.Equal([]byte(SlackSignature(secret, timestamp, body)), []byte(signature)) {
+		return nil, errors.New("invalid 'X-Slack-Signature'")
+	}
+	return body, nil
+}
+
+// Returns the 'X-Slack-Signature' slack sends for the request body
+func SlackSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
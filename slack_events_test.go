@@ -0,0 +1,172 @@
+package channelstats_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestSlackEvents(t *testing.T) {
+	suite.Run(t, new(SlackEventsSuite))
+}
+
+type SlackEventsSuite struct {
+	suite.Suite
+	conf    channelstats.Config
+	store   channelstats.Storer
+	bot     *channelstats.SlackBot
+	handler http.Handler
+	stopped chan struct{}
+	slack   *httptest.Server
+	posts   chan channelstats.SlackMessage
+}
+
+const signingSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+func (s *SlackEventsSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *SlackEventsSuite) SetupTest() {
+	s.posts = make(chan channelstats.SlackMessage, 10)
+
+	// A fake slack web API
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth.test", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok": true, "user_id": "UBOT"}`)
+	})
+	mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+		var msg channelstats.SlackMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_json"}`)
+			return
+		}
+		s.posts <- msg
+		fmt.Fprint(w, `{"ok": true, "ts": "1544659200.000100"}`)
+	})
+	s.slack = httptest.NewServer(mux)
+
+	s.conf = channelstats.Config{}
+	s.conf.Slack.APIURL = s.slack.URL
+	s.conf.Store.Backend = "memory"
+	s.conf.Slack.Mode = channelstats.SlackModeEvents
+	s.conf.Slack.SigningSecret = signingSecret
+
+	idMgr := &channelstats.MockIDManage{
+		UserByID: map[string]string{"U1": "joe", "U2": "scott"},
+	}
+
+	var err error
+	s.store, err = channelstats.NewStore(s.conf, idMgr)
+	s.Require().NoError(err)
+	s.bot = channelstats.NewSlackBot(s.conf, s.store, idMgr, &channelstats.NullMailer{})
+	s.handler = channelstats.NewSlackEventsHandler(s.conf, s.bot)
+
+	s.stopped = make(chan struct{})
+	go func() {
+		s.NoError(s.bot.Start())
+		close(s.stopped)
+	}()
+}
+
+func (s *SlackEventsSuite) TearDownTest() {
+	s.bot.Stop()
+	<-s.stopped
+	s.slack.Close()
+}
+
+func (s *SlackEventsSuite) post(body string, timestamp time.Time, secret string) *httptest.ResponseRecorder {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/slack/events", bytes.NewBufferString(body))
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", channelstats.SlackSignature(secret, ts, []byte(body)))
+
+	resp := httptest.NewRecorder()
+	s.handler.ServeHTTP(resp, req)
+	return resp
+}
+
+func (s *SlackEventsSuite) messages() int64 {
+	timeRange, err := channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T23")
+	s.Require().NoError(err)
+	sums, err := s.store.SumByUser(context.Background(), timeRange, "C1", "messages")
+	s.Require().NoError(err)
+
+	var total int64
+	for _, sum := range sums {
+		total += sum.Sum
+	}
+	return total
+}
+
+func (s *SlackEventsSuite) TestURLVerification() {
+	resp := s.post(`{"type": "url_verification", "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`,
+		time.Now(), signingSecret)
+	s.Equal(http.StatusOK, resp.Code)
+	s.Equal("3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P", resp.Body.String())
+}
+
+func (s *SlackEventsSuite) TestInvalidSignature() {
+	body := `{"type": "url_verification", "challenge": "abc"}`
+
+	s.Equal(http.StatusUnauthorized, s.post(body, time.Now(), "wrong-secret").Code)
+	s.Equal(http.StatusUnauthorized, s.post(body, time.Now().Add(-time.Hour), signingSecret).Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/slack/events", bytes.NewBufferString(body))
+	resp := httptest.NewRecorder()
+	s.handler.ServeHTTP(resp, req)
+	s.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+func (s *SlackEventsSuite) TestEventCallback() {
+	message := `{"type": "event_callback", "event_id": "Ev1", "event": {"type": "message",
+		"channel": "C1", "user": "U1", "text": "hello", "ts": "1544659200.000100"}}`
+	reaction := `{"type": "event_callback", "event_id": "Ev2", "event": {"type": "reaction_added",
+		"user": "U2", "reaction": "smile", "item_user": "U1", "event_ts": "1544659200.000200",
+		"item": {"type": "message", "channel": "C1", "ts": "1544659200.000100"}}}`
+
+	s.Equal(http.StatusOK, s.post(message, time.Now(), signingSecret).Code)
+	s.Equal(http.StatusOK, s.post(reaction, time.Now(), signingSecret).Code)
+	// Slack retries are only counted once
+	s.Equal(http.StatusOK, s.post(message, time.Now(), signingSecret).Code)
+
+	s.Eventually(func() bool { return s.messages() == 1 }, time.Second, time.Millisecond*10)
+
+	// Give the bot a chance to count the retry should it not be ignored
+	time.Sleep(time.Millisecond * 50)
+	s.Equal(int64(1), s.messages())
+}
+
+func (s *SlackEventsSuite) TestIgnoresUnhandledEvents() {
+	for _, body := range []string{
+		`{"type": "app_rate_limited", "minute_rate_limited": 1518467820}`,
+		`{"type": "event_callback", "event_id": "Ev3", "event": {"type": "app_mention", "user": "U1"}}`,
+		`{"type": "event_callback", "event_id": "Ev4", "authed_users": ["UBOT"],
+			"event": {"type": "member_joined_channel", "user": "U1", "channel": "C1"}}`,
+	} {
+		s.Equal(http.StatusOK, s.post(body, time.Now(), signingSecret).Code, body)
+	}
+}
+
+func (s *SlackEventsSuite) TestAnswersMentions() {
+	mention := `{"type": "event_callback", "event_id": "Ev5", "event": {"type": "message",
+		"channel": "C1", "user": "U1", "text": "<@UBOT> help", "ts": "1544659200.000300"}}`
+	s.Equal(http.StatusOK, s.post(mention, time.Now(), signingSecret).Code)
+
+	select {
+	case msg := <-s.posts:
+		s.Equal("C1", msg.Channel)
+		s.Contains(msg.Text, "`help`")
+	case <-time.After(time.Second * 5):
+		s.Fail("timed out waiting for chat.postMessage")
+	}
+}
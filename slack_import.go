@@ -0,0 +1,197 @@
+package channelstats
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mailgun/holster/slice"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+type ImportOptions struct {
+	// Only import these channel names, imports all channels if empty
+	Channels []string
+	// Ignore messages at or after this time, such that history already counted live is not counted twice
+	Until time.Time
+}
+
+type ImportStats struct {
+	Channels   int `json:"channels"`
+	Messages   int `json:"messages"`
+	Reactions  int `json:"reactions"`
+	Duplicates int `json:"duplicates"`
+	Skipped    int `json:"skipped"`
+}
+
+// A channel or group listed in channels.json or groups.json
+type exportChannel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// A user listed in users.json
+type exportUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Import the messages and reactions from a standard Slack workspace export archive. Each
+// channel directory holds a JSON file per day, every message and reaction is counted through
+// the Deduper interface so importing the same archive more than once is harmless.
+//
+// The export does not include the time a reaction was added, so reactions are counted
+// in the hour of the message they were added to.
+func ImportSlackExport(ctx context.Context, store Storer, archive *zip.Reader, opts ImportOptions) (ImportStats, error) {
+	var stats ImportStats
+
+	deduper, ok := store.(Deduper)
+	if !ok {
+		return stats, errors.New("configured store does not support importing history")
+	}
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	// Like the slack bot, only count messages from users slack knows about
+	var users []exportUser
+	if err := readExportFile(files, "users.json", &users); err != nil {
+		return stats, err
+	}
+	knownUsers := make(map[string]bool, len(users))
+	for _, user := range users {
+		knownUsers[user.ID] = true
+	}
+
+	// Map the channel directory names to channel ids, private channels are listed in groups.json
+	var channels []exportChannel
+	if err := readExportFile(files, "channels.json", &channels); err != nil {
+		return stats, err
+	}
+	if _, ok := files["groups.json"]; ok {
+		var groups []exportChannel
+		if err := readExportFile(files, "groups.json", &groups); err != nil {
+			return stats, err
+		}
+		channels = append(channels, groups...)
+	}
+	channelByName := make(map[string]string, len(channels))
+	for _, channel := range channels {
+		if len(opts.Channels) != 0 && !slice.ContainsString(channel.Name, opts.Channels, nil) {
+			continue
+		}
+		channelByName[channel.Name] = channel.ID
+	}
+	stats.Channels = len(channelByName)
+
+	// Import the day files in order so the import progresses through time
+	var names []string
+	for name := range files {
+		if _, ok := channelByName[path.Dir(name)]; ok && strings.HasSuffix(name, ".json") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		var messages []slack.Msg
+		if err := readExportFile(files, name, &messages); err != nil {
+			return stats, err
+		}
+
+		channelID := channelByName[path.Dir(name)]
+		for _, msg := range messages {
+			if msg.Type != "message" || !knownUsers[msg.User] || !beforeTime(msg.Timestamp, opts.Until) {
+				stats.Skipped++
+				continue
+			}
+
+			if err := stats.count(deduper, channelID, msg); err != nil {
+				return stats, errors.Wrapf(err, "while importing '%s'", name)
+			}
+		}
+	}
+	return stats, nil
+}
+
+// Count the message from the channel history and each of its reactions exactly once
+func (stats *ImportStats) count(deduper Deduper, channelID string, msg slack.Msg) error {
+	msg.Channel = channelID
+	counted, err := deduper.HandleMessageOnce(&slack.MessageEvent{Msg: msg})
+	if err != nil {
+		return errors.Wrapf(err, "while counting message '%s'", msg.Timestamp)
+	}
+	if counted {
+		stats.Messages++
+	} else {
+		stats.Duplicates++
+	}
+
+	for _, reaction := range msg.Reactions {
+		for _, user := range reaction.Users {
+			ev := &slack.ReactionAddedEvent{
+				Type:           "reaction_added",
+				User:           user,
+				ItemUser:       msg.User,
+				Reaction:       reaction.Name,
+				EventTimestamp: msg.Timestamp,
+			}
+			ev.Item.Type = "message"
+			ev.Item.Channel = channelID
+			ev.Item.Timestamp = msg.Timestamp
+
+			counted, err := deduper.HandleReactionAddedOnce(ev)
+			if err != nil {
+				return errors.Wrapf(err, "while counting reaction to '%s'", msg.Timestamp)
+			}
+			if counted {
+				stats.Reactions++
+			} else {
+				stats.Duplicates++
+			}
+		}
+	}
+	return nil
+}
+
+func readExportFile(files map[string]*zip.File, name string, obj interface{}) error {
+	file, ok := files[name]
+	if !ok {
+		return errors.Errorf("'%s' not found in slack export; is this a slack export archive?", name)
+	}
+
+	r, err := file.Open()
+	if err != nil {
+		return errors.Wrapf(err, "while opening '%s' in slack export", name)
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(r).Decode(obj); err != nil {
+		return errors.Wrapf(err, "while decoding '%s' in slack export", name)
+	}
+	return nil
+}
+
+// Returns true if the slack timestamp is before 'until' or 'until' is not set
+func beforeTime(ts string, until time.Time) bool {
+	if until.IsZero() {
+		return true
+	}
+	t, err := timeFromTimeStamp(ts)
+	if err != nil {
+		// Let HandleMessageOnce() report the invalid timestamp
+		return true
+	}
+	return t.Before(until)
+}
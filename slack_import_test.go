@@ -0,0 +1,128 @@
+package channelstats_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestSlackImport(t *testing.T) {
+	suite.Run(t, new(SlackImportSuite))
+}
+
+type SlackImportSuite struct {
+	suite.Suite
+	store   channelstats.Storer
+	archive *zip.Reader
+}
+
+var exportFiles = map[string]string{
+	"users.json":    `[{"id": "U1", "name": "joe"}, {"id": "U2", "name": "scott"}]`,
+	"channels.json": `[{"id": "C1", "name": "general"}, {"id": "C2", "name": "random"}]`,
+	"general/2018-12-13.json": `[
+		{"type": "message", "user": "U1", "text": "hello", "ts": "1544659200.000100",
+			"reactions": [{"name": "smile", "users": ["U2"], "count": 1}]},
+		{"type": "message", "subtype": "bot_message", "bot_id": "B1", "text": "beep", "ts": "1544659200.000200"},
+		{"type": "message", "user": "U2", "text": "hello there", "ts": "1544662800.000300"}
+	]`,
+	"general/2018-12-14.json": `[
+		{"type": "message", "user": "U1", "text": "next day", "ts": "1544745600.000400"}
+	]`,
+	"random/2018-12-13.json": `[
+		{"type": "message", "user": "U2", "text": "random", "ts": "1544659200.000500"}
+	]`,
+}
+
+func (s *SlackImportSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range exportFiles {
+		w, err := zw.Create(name)
+		s.Require().NoError(err)
+		_, err = w.Write([]byte(content))
+		s.Require().NoError(err)
+	}
+	s.Require().NoError(zw.Close())
+
+	var err error
+	s.archive, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	s.Require().NoError(err)
+}
+
+func (s *SlackImportSuite) SetupTest() {
+	var conf channelstats.Config
+	conf.Store.Backend = "memory"
+
+	var err error
+	s.store, err = channelstats.NewStore(conf, &channelstats.MockIDManage{
+		UserByID: map[string]string{"U1": "joe", "U2": "scott"},
+	})
+	s.Require().NoError(err)
+}
+
+func (s *SlackImportSuite) messages(channelID string) []string {
+	var results []string
+	filter := channelstats.DataPointFilter{ChannelID: channelID, Counter: "messages"}
+	err := s.store.ForEach(context.Background(), filter, func(dp channelstats.DataPoint) error {
+		results = append(results, fmt.Sprintf("%s/%s/%d", dp.Hour, dp.UserID, dp.Value))
+		return nil
+	})
+	s.Require().NoError(err)
+	return results
+}
+
+func (s *SlackImportSuite) TestImport() {
+	stats, err := channelstats.ImportSlackExport(context.Background(), s.store, s.archive, channelstats.ImportOptions{})
+	s.Require().NoError(err)
+	s.Equal(channelstats.ImportStats{Channels: 2, Messages: 4, Reactions: 1, Skipped: 1}, stats)
+
+	s.Equal([]string{
+		"2018-12-13T00/U1/1",
+		"2018-12-13T01/U2/1",
+		"2018-12-14T00/U1/1",
+	}, s.messages("C1"))
+	s.Equal([]string{"2018-12-13T00/U2/1"}, s.messages("C2"))
+}
+
+func (s *SlackImportSuite) TestImportIsIdempotent() {
+	_, err := channelstats.ImportSlackExport(context.Background(), s.store, s.archive, channelstats.ImportOptions{})
+	s.Require().NoError(err)
+
+	stats, err := channelstats.ImportSlackExport(context.Background(), s.store, s.archive, channelstats.ImportOptions{})
+	s.Require().NoError(err)
+	s.Equal(channelstats.ImportStats{Channels: 2, Duplicates: 5, Skipped: 1}, stats)
+
+	s.Equal([]string{
+		"2018-12-13T00/U1/1",
+		"2018-12-13T01/U2/1",
+		"2018-12-14T00/U1/1",
+	}, s.messages("C1"))
+}
+
+func (s *SlackImportSuite) TestImportOptions() {
+	stats, err := channelstats.ImportSlackExport(context.Background(), s.store, s.archive, channelstats.ImportOptions{
+		Channels: []string{"general"},
+		Until:    time.Date(2018, 12, 14, 0, 0, 0, 0, time.UTC),
+	})
+	s.Require().NoError(err)
+	s.Equal(channelstats.ImportStats{Channels: 1, Messages: 2, Reactions: 1, Skipped: 2}, stats)
+	s.Nil(s.messages("C2"))
+}
+
+func (s *SlackImportSuite) TestNotAnExport() {
+	var buf bytes.Buffer
+	s.Require().NoError(zip.NewWriter(&buf).Close())
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	s.Require().NoError(err)
+
+	_, err = channelstats.ImportSlackExport(context.Background(), s.store, archive, channelstats.ImportOptions{})
+	s.Error(err)
+}
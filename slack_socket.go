@@ -0,0 +1,179 @@
+package channelstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mailgun/holster/slice"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Receive events via a Socket Mode websocket opened with an app-level token
+	SlackModeSocket = "socket"
+)
+
+// Slack errors which indicate the token will never be accepted
+var slackAuthErrors = []string{"not_authed", "invalid_auth", "account_inactive", "token_revoked", "token_expired"}
+
+// Returned when slack rejects our credentials
+type authError struct {
+	slackErr string
+}
+
+func (e *authError) Error() string {
+	return e.slackErr
+}
+
+// The response from apps.connections.open
+type socketConnectionResp struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+	URL   string `json:"url"`
+}
+
+// Every message slack sends over a Socket Mode connection is wrapped in an envelope
+type socketEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Reason     string          `json:"reason"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+type socketAck struct {
+	EnvelopeID string `json:"envelope_id"`
+}
+
+// Open a Socket Mode connection and handle events until slack asks us to reconnect or the
+// connection is lost. Returns true if we should reconnect.
+func (s *SlackBot) runSocketMode() bool {
+	s.log.Info("Opening Socket Mode WebSocket...")
+	conn, err := s.openSocket()
+	if err != nil {
+		s.log.Errorf("while opening socket mode connection: %s", err)
+		if _, ok := errors.Cause(err).(*authError); ok {
+			s.state.Set(StateAuthFailed, err)
+			return false
+		}
+		s.state.Set(StateDisconnected, err)
+		return true
+	}
+
+	events := make(chan slack.RTMEvent, 100)
+	go func() {
+		s.readSocket(conn, events)
+		close(events)
+	}()
+
+	s.handleEvents(events)
+	conn.Close()
+	// Wait for readSocket() to notice the connection is closed
+	for range events {
+	}
+
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Ask slack for a Socket Mode websocket URL and connect to it
+func (s *SlackBot) openSocket() (*websocket.Conn, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/apps.connections.open", s.conf.Slack.APIURL), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "while creating apps.connections.open request")
+	}
+	req.Header.Set("Authorization", "Bearer "+s.conf.Slack.AppToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: time.Second * 30}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "POST 'apps.connections.open' failed")
+	}
+	defer resp.Body.Close()
+
+	var connResp socketConnectionResp
+	if err := json.NewDecoder(resp.Body).Decode(&connResp); err != nil {
+		return nil, errors.Wrapf(err, "POST 'apps.connections.open' failed with '%d' during json decode", resp.StatusCode)
+	}
+	if !connResp.Ok {
+		if slice.ContainsString(connResp.Error, slackAuthErrors, nil) {
+			return nil, &authError{slackErr: connResp.Error}
+		}
+		return nil, errors.Errorf("POST 'apps.connections.open' failed with slack error '%s'", connResp.Error)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(connResp.URL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "while connecting to socket mode websocket")
+	}
+	return conn, nil
+}
+
+// Read envelopes from the connection, acknowledging each before sending the event to
+// the bot. Returns when the connection is closed or slack asks us to reconnect.
+func (s *SlackBot) readSocket(conn *websocket.Conn, events chan<- slack.RTMEvent) {
+	for {
+		var envelope socketEnvelope
+		if err := conn.ReadJSON(&envelope); err != nil {
+			select {
+			case <-s.done:
+			default:
+				s.log.Errorf("Socket mode connection lost: %s", err)
+				s.state.Set(StateDisconnected, err)
+			}
+			return
+		}
+
+		switch envelope.Type {
+		case "hello":
+			s.log.Info("Slack said hello... Connected via Socket Mode!")
+			s.state.Set(StateConnected, nil)
+			continue
+		case "disconnect":
+			s.log.Infof("Slack requested we reconnect (%s)", envelope.Reason)
+			return
+		}
+
+		// Slack re-sends envelopes which are not acknowledged
+		if envelope.EnvelopeID != "" {
+			if err := conn.WriteJSON(socketAck{EnvelopeID: envelope.EnvelopeID}); err != nil {
+				s.log.Errorf("while acknowledging envelope '%s': %s", envelope.EnvelopeID, err)
+				return
+			}
+		}
+
+		if envelope.Type != "events_api" {
+			s.log.Debugf("Ignoring '%s' envelope", envelope.Type)
+			continue
+		}
+
+		var payload slackEventsPayload
+		if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+			s.log.Errorf("while decoding envelope '%s': %s", envelope.EnvelopeID, err)
+			continue
+		}
+
+		ev, err := payload.rtmEvent()
+		if err != nil {
+			s.log.Errorf("while decoding envelope '%s': %s", envelope.EnvelopeID, err)
+			continue
+		}
+		if ev == nil {
+			continue
+		}
+
+		select {
+		case events <- *ev:
+		case <-s.done:
+			return
+		}
+	}
+}
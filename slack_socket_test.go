@@ -0,0 +1,235 @@
+package channelstats_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestSlackSocket(t *testing.T) {
+	suite.Run(t, new(SlackSocketSuite))
+}
+
+type SlackSocketSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	store    channelstats.Storer
+	bot      *channelstats.SlackBot
+	mail     *operatorMailer
+	opened   int32
+	authFail int32
+	acks     chan string
+	posts    chan channelstats.SlackMessage
+	// The text of the message delivered on the first connection
+	text string
+}
+
+// Records the messages sent to the operator
+type operatorMailer struct {
+	channelstats.NullMailer
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (m *operatorMailer) Operator(msg string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *operatorMailer) Messages() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string{}, m.messages...)
+}
+
+func (s *SlackSocketSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *SlackSocketSuite) SetupTest() {
+	atomic.StoreInt32(&s.opened, 0)
+	atomic.StoreInt32(&s.authFail, 0)
+	s.acks = make(chan string, 10)
+	s.posts = make(chan channelstats.SlackMessage, 10)
+	s.text = "hello"
+
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("Bearer xapp-test", r.Header.Get("Authorization"))
+		atomic.AddInt32(&s.opened, 1)
+		if atomic.LoadInt32(&s.authFail) == 1 {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_auth"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"ok": true, "url": "ws%s/socket"}`, strings.TrimPrefix(s.server.URL, "http"))
+	})
+	mux.HandleFunc("/auth.test", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok": true, "user_id": "UBOT"}`)
+	})
+	mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+		var msg channelstats.SlackMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_json"}`)
+			return
+		}
+		s.posts <- msg
+		fmt.Fprint(w, `{"ok": true, "ts": "1544659200.000100"}`)
+	})
+	mux.HandleFunc("/socket", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !s.NoError(err) {
+			return
+		}
+		defer conn.Close()
+
+		// Only the first connection delivers an event, then asks the bot to reconnect
+		first := atomic.LoadInt32(&s.opened) == 1
+
+		s.NoError(conn.WriteJSON(map[string]interface{}{"type": "hello"}))
+		if first {
+			s.NoError(conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"type": "events_api",
+				"envelope_id": "env-1", "payload": {"type": "event_callback", "event_id": "Ev1", "event": {
+				"type": "message", "channel": "C1", "user": "U1", "text": %q, "ts": "1544659200.000100"}}}`,
+				s.text))))
+		}
+
+		for {
+			var ack struct {
+				EnvelopeID string `json:"envelope_id"`
+			}
+			if err := conn.ReadJSON(&ack); err != nil {
+				return
+			}
+			s.acks <- ack.EnvelopeID
+			if first {
+				conn.WriteJSON(map[string]interface{}{"type": "disconnect", "reason": "refresh_requested"})
+			}
+		}
+	})
+	s.server = httptest.NewServer(mux)
+
+	var conf channelstats.Config
+	conf.Store.Backend = "memory"
+	conf.Slack.Mode = channelstats.SlackModeSocket
+	conf.Slack.AppToken = "xapp-test"
+	conf.Slack.APIURL = s.server.URL
+
+	idMgr := &channelstats.MockIDManage{UserByID: map[string]string{"U1": "joe"}}
+
+	var err error
+	s.store, err = channelstats.NewStore(conf, idMgr)
+	s.Require().NoError(err)
+	s.mail = &operatorMailer{}
+	s.bot = channelstats.NewSlackBot(conf, s.store, idMgr, s.mail)
+}
+
+func (s *SlackSocketSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *SlackSocketSuite) messages() int64 {
+	timeRange, err := channelstats.NewTimeRange("2018-12-13T00", "2018-12-13T23")
+	s.Require().NoError(err)
+	sums, err := s.store.SumByUser(context.Background(), timeRange, "C1", "messages")
+	s.Require().NoError(err)
+	if len(sums) == 0 {
+		return 0
+	}
+	return sums[0].Sum
+}
+
+func (s *SlackSocketSuite) TestSocketMode() {
+	stopped := make(chan error)
+	go func() { stopped <- s.bot.Start() }()
+
+	select {
+	case id := <-s.acks:
+		s.Equal("env-1", id)
+	case <-time.After(time.Second * 5):
+		s.FailNow("timed out waiting for envelope ack")
+	}
+
+	s.Eventually(func() bool { return s.messages() == 1 }, time.Second*5, time.Millisecond*10)
+
+	// The bot should reconnect when slack asks it to
+	s.Eventually(func() bool { return atomic.LoadInt32(&s.opened) == 2 }, time.Second*5, time.Millisecond*10)
+	s.Eventually(func() bool { return s.bot.Status().State == channelstats.StateConnected },
+		time.Second*5, time.Millisecond*10)
+
+	resp := httptest.NewRecorder()
+	channelstats.NewHealthHandler(s.bot).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	s.Equal(http.StatusOK, resp.Code)
+
+	s.bot.Stop()
+	select {
+	case err := <-stopped:
+		s.NoError(err)
+	case <-time.After(time.Second * 5):
+		s.Fail("timed out waiting for the bot to stop")
+	}
+}
+
+func (s *SlackSocketSuite) TestAnswersMentions() {
+	s.text = "<@UBOT> help"
+	stopped := make(chan error)
+	go func() { stopped <- s.bot.Start() }()
+
+	select {
+	case msg := <-s.posts:
+		s.Equal("C1", msg.Channel)
+		s.Contains(msg.Text, "`help`")
+	case <-time.After(time.Second * 5):
+		s.Fail("timed out waiting for chat.postMessage")
+	}
+
+	s.bot.Stop()
+	select {
+	case err := <-stopped:
+		s.NoError(err)
+	case <-time.After(time.Second * 5):
+		s.Fail("timed out waiting for the bot to stop")
+	}
+}
+
+func (s *SlackSocketSuite) TestAuthFailure() {
+	atomic.StoreInt32(&s.authFail, 1)
+
+	stopped := make(chan error)
+	go func() { stopped <- s.bot.Start() }()
+
+	s.Eventually(func() bool { return s.bot.Status().State == channelstats.StateAuthFailed },
+		time.Second*5, time.Millisecond*10)
+	s.Eventually(func() bool { return len(s.mail.Messages()) == 1 }, time.Second*5, time.Millisecond*10)
+	s.Contains(s.mail.Messages()[0], "invalid_auth")
+
+	// The bot should not retry credentials slack rejected
+	time.Sleep(time.Millisecond * 100)
+	s.Equal(int32(1), atomic.LoadInt32(&s.opened))
+
+	resp := httptest.NewRecorder()
+	channelstats.NewHealthHandler(s.bot).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	s.Equal(http.StatusServiceUnavailable, resp.Code)
+	s.Contains(resp.Body.String(), `"state":"auth-failed"`)
+
+	s.bot.Stop()
+	select {
+	case err := <-stopped:
+		s.NoError(err)
+	case <-time.After(time.Second * 5):
+		s.Fail("timed out waiting for the bot to stop")
+	}
+}
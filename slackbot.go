@@ -5,112 +5,233 @@ import (
 	"net/http"
 	"runtime/debug"
 	"sync"
-	"sync/atomic"
 	"time"
 
+	"github.com/mailgun/holster"
 	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 type SlackBot struct {
-	log    *logrus.Entry
-	done   chan struct{}
-	server *http.Server
-	rtm    *slack.RTM
-	idMgr  IDManager
-	mail   Mailer
-	store  Storer
-	conf   Config
+	log      *logrus.Entry
+	done     chan struct{}
+	server   *http.Server
+	rtm      *slack.RTM
+	idMgr    IDManager
+	mail     Mailer
+	store    Storer
+	backfill *Backfiller
+	recorder *EventRecorder
+	commands *Commander
+	conf     Config
+	// Events received via the Events API
+	incoming chan slack.RTMEvent
+	// Ids of recently dispatched events, slack may deliver the same event more than once
+	dispatched *holster.LRUCache
+	state      *connectionState
+	backoff    Backoff
 }
 
 func NewSlackBot(conf Config, store Storer, idMgr IDManager, mail Mailer) *SlackBot {
 	return &SlackBot{
-		log:   GetLogger().WithField("prefix", "slack"),
-		mail:  mail,
-		idMgr: idMgr,
-		store: store,
-		conf:  conf,
+		log:        GetLogger().WithField("prefix", "slack"),
+		done:       make(chan struct{}),
+		mail:       mail,
+		idMgr:      idMgr,
+		store:      store,
+		backfill:   NewBackfiller(conf, store, idMgr),
+		commands:   NewCommander(conf, store, idMgr),
+		conf:       conf,
+		incoming:   make(chan slack.RTMEvent, 100),
+		dispatched: holster.NewLRUCache(1000),
+		state:      newConnectionState(),
+		backoff:    NewBackoff(conf.Slack.ReconnectMin.Duration, conf.Slack.ReconnectMax.Duration),
 	}
 }
 
 func (s *SlackBot) Start() error {
-	s.done = make(chan struct{})
-	var wg sync.WaitGroup
-	var connected int32
+	var err error
+	s.recorder, err = NewEventRecorder(s.conf.Slack.RecordFile)
+	if err != nil {
+		return err
+	}
+	defer s.recorder.Close()
+	defer s.state.Set(StateStopped, nil)
 
-	go func() {
-		ticker := time.Tick(time.Second * 30)
-		var disconnected bool
-		var notified bool
-
-		for {
-			select {
-			case <-ticker:
-				if disconnected {
-					// If we are still disconnected
-					if atomic.LoadInt32(&connected) == 0 {
-						// Only notify once
-						if notified {
-							continue
-						}
-
-						err := s.mail.Operator("channel-stats has been disconnected from " +
-							"slack for more than 30 seconds")
-						if err != nil {
-							s.log.Errorf("while sending notification - %s", err)
-							continue
-						}
-						notified = true
-					}
-				}
+	// RTM provides the bot user id once connected, other modes must ask slack for it
+	if s.conf.Slack.Mode != SlackModeRTM {
+		s.fetchBotUserID()
+	}
 
-				// If we are disconnected, Wait another 30 seconds
-				if atomic.LoadInt32(&connected) == 0 {
-					disconnected = true
-				} else {
-					disconnected = false
-					notified = false
-				}
-			}
+	if s.conf.Slack.Mode == SlackModeEvents {
+		s.log.Info("Receiving events from slack via /slack/events")
+		s.state.Set(StateConnected, nil)
+		// Keep handling events should handleEvents() recover from a panic
+		for s.handleEvents(s.incoming) {
 		}
-	}()
+		return nil
+	}
+
+	go s.watchConnection()
 
 	// In a for loop because poorly written gorilla garbage panics occasionally
 	for {
-		s.log.Info("Opening RTM WebSocket...")
-		atomic.StoreInt32(&connected, 1)
-
-		api := slack.New(s.conf.Slack.Token)
-		s.rtm = api.NewRTM()
-
-		wg.Add(1)
-		go func() {
-			s.rtm.ManageConnection()
-			wg.Done()
-			s.log.Debug("ManageConnection() done")
-		}()
-
-		// Return true if we wish to reconnect
-		if s.handleEvents() {
-			atomic.StoreInt32(&connected, 0)
-			s.log.Debug("Reconnecting...")
-			s.rtm.Disconnect()
-			wg.Wait()
-			continue
+		s.state.Set(StateConnecting, nil)
+
+		var reconnect bool
+		if s.conf.Slack.Mode == SlackModeSocket {
+			reconnect = s.runSocketMode()
+		} else {
+			reconnect = s.runRTM()
+		}
+
+		status := s.state.Status()
+		if status.State == StateAuthFailed {
+			s.authFailed(status)
+			return nil
+		}
+		if !reconnect {
+			s.log.Debug("Disconnecting...")
+			return nil
+		}
+		s.state.Set(StateDisconnected, nil)
+
+		// Wait longer after each failed attempt, such that we do not hammer slack during an outage
+		wait := s.backoff.Duration(status.Attempts)
+		s.log.Infof("Reconnecting in %s...", wait)
+		select {
+		case <-time.After(wait):
+		case <-s.done:
+			return nil
 		}
-		atomic.StoreInt32(&connected, 0)
-		s.log.Debug("Disconnecting...")
+	}
+}
+
+func (s *SlackBot) Status() ConnectionStatus {
+	return s.state.Status()
+}
+
+// Fetch the user id of the bot from slack, mentions of the bot are not answered until it is known
+func (s *SlackBot) fetchBotUserID() {
+	id, err := NewSlackAPI(s.conf).AuthTest()
+	if err != nil {
+		s.log.Errorf("while fetching bot user id; mentions will not be answered: %s", err)
+		return
+	}
+	s.commands.SetBotUserID(id)
+}
+
+// Alert the operator and stop connecting, retrying with credentials slack has rejected will not
+// succeed and may get us rate limited. Waits until the bot is stopped.
+func (s *SlackBot) authFailed(status ConnectionStatus) {
+	s.log.Errorf("Slack rejected our credentials (%s); no longer connecting to slack", status.LastError)
+	err := s.mail.Operator(fmt.Sprintf("channel-stats has stopped connecting to slack; "+
+		"slack rejected the credentials (%s)", status.LastError))
+	if err != nil {
+		s.log.Errorf("while sending notification - %s", err)
+	}
+	<-s.done
+}
+
+// Notify the operator if we are disconnected from slack for more than 30 seconds, and again when we recover
+func (s *SlackBot) watchConnection() {
+	ticker := time.NewTicker(connectionCheckInterval)
+	defer ticker.Stop()
+	var notified bool
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.state.changed:
+		case <-s.done:
+			return
+		}
+
+		status := s.state.Status()
+		switch status.State {
+		case StateConnected:
+			if !notified {
+				continue
+			}
+			err := s.mail.Operator("channel-stats has reconnected to slack")
+			if err != nil {
+				s.log.Errorf("while sending notification - %s", err)
+				continue
+			}
+			notified = false
+		case StateConnecting, StateDisconnected:
+			// Only notify once
+			if notified || time.Since(status.DownSince) < disconnectNotifyAfter {
+				continue
+			}
+			err := s.mail.Operator(fmt.Sprintf("channel-stats has been disconnected from "+
+				"slack for more than %s", disconnectNotifyAfter))
+			if err != nil {
+				s.log.Errorf("while sending notification - %s", err)
+				continue
+			}
+			notified = true
+		}
+	}
+}
+
+// Open an RTM connection and handle events until the connection is closed. Returns true if we should reconnect.
+func (s *SlackBot) runRTM() bool {
+	var wg sync.WaitGroup
+
+	s.log.Info("Opening RTM WebSocket...")
+	api := slack.New(s.conf.Slack.Token)
+	s.rtm = api.NewRTM()
+
+	wg.Add(1)
+	go func() {
+		s.rtm.ManageConnection()
+		wg.Done()
+		s.log.Debug("ManageConnection() done")
+	}()
+
+	// Return true if we wish to reconnect
+	if s.handleEvents(s.rtm.IncomingEvents) {
+		s.rtm.Disconnect()
 		wg.Wait()
-		return nil
+		return true
 	}
+	wg.Wait()
+	return false
 }
 
 func (s *SlackBot) Stop() {
-	s.rtm.Disconnect()
+	if s.rtm != nil {
+		s.rtm.Disconnect()
+	}
 	close(s.done)
+	s.backfill.Stop()
+	s.commands.Stop()
+}
+
+// Queue an event received via the Events API for the bot to handle. Returns an error
+// if the event could not be queued in time to acknowledge the event.
+func (s *SlackBot) Dispatch(eventID string, ev slack.RTMEvent) error {
+	if eventID != "" {
+		if _, ok := s.dispatched.Get(eventID); ok {
+			s.log.Debugf("Ignoring event '%s' slack already delivered", eventID)
+			return nil
+		}
+	}
+
+	select {
+	case s.incoming <- ev:
+		if eventID != "" {
+			s.dispatched.Add(eventID, true)
+		}
+		return nil
+	case <-time.After(slackQueueTimeout):
+		return errors.New("timed out queuing slack event; slack will retry")
+	}
 }
 
-func (s *SlackBot) handleEvents() (shouldReconnect bool) {
+func (s *SlackBot) handleEvents(events <-chan slack.RTMEvent) (shouldReconnect bool) {
 	defer func() {
 		// Gorilla Websockets can panic
 		if r := recover(); r != nil {
@@ -122,20 +243,33 @@ func (s *SlackBot) handleEvents() (shouldReconnect bool) {
 
 	for {
 		select {
-		case msg := <-s.rtm.IncomingEvents:
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := s.recorder.Record(msg); err != nil {
+				s.log.Errorf("while recording event: %s", err)
+			}
+
 			switch ev := msg.Data.(type) {
 			case *slack.ConnectedEvent:
 				s.log.Debugf("Connection counter: %d", ev.ConnectionCount)
+				s.state.Set(StateConnected, nil)
+				if ev.Info != nil && ev.Info.User != nil {
+					s.commands.SetBotUserID(ev.Info.User.ID)
+				}
 			case *slack.ConnectingEvent:
 				s.log.Info("Connecting via RTM...")
+				s.state.Set(StateConnecting, nil)
 			case *slack.HelloEvent:
 				s.log.Info("Slack said hello... Connected!")
+				s.state.Set(StateConnected, nil)
 			case *slack.LatencyReport:
 				s.log.Debugf("Latency Report '%s'", ev.Value)
 			case *slack.MessageEvent:
 				userName, err := s.idMgr.GetUserName(ev.User)
 				if err != nil {
-					s.log.Debug("Unknown user message: %+v", ev)
+					s.log.Debugf("Unknown user message: %+v", ev)
 					continue
 				}
 
@@ -144,20 +278,21 @@ func (s *SlackBot) handleEvents() (shouldReconnect bool) {
 				if err != nil {
 					s.log.Errorf("%s", err)
 				}
+				s.commands.Handle(ev)
 			case *slack.ReactionAddedEvent:
 				s.log.Debugf("Reaction Added By: %s", ev.ItemUser)
 				err := s.store.HandleReactionAdded(ev)
 				if err != nil {
 					s.log.Errorf("%s", err)
 				}
-
-				/*info := s.rtm.GetInfo()
-				prefix := fmt.Sprintf("<@%s> ", info.User.ID)
-
-				if ev.User != info.User.ID && strings.HasPrefix(ev.Text, prefix) {
-					s.rtm.SendMessage(s.rtm.NewOutgoingMessage("What's up buddy!?!?", ev.Channel))
-				}*/
-			case *slack.ChannelJoinedEvent, *slack.ChannelRenameEvent:
+			case *slack.ChannelJoinedEvent:
+				s.log.Infof("Joined Channel '%s'", ev.Channel.Name)
+				err := s.idMgr.UpdateChannels()
+				if err != nil {
+					s.log.Errorf("Error updating channel metadata: %s", err)
+				}
+				s.backfill.Backfill(ev.Channel.ID)
+			case *slack.ChannelRenameEvent:
 				s.log.Info("Channel Info Updated")
 				err := s.idMgr.UpdateChannels()
 				if err != nil {
@@ -167,11 +302,18 @@ func (s *SlackBot) handleEvents() (shouldReconnect bool) {
 				s.log.Errorf("RTM: %s", ev.Error())
 			case *slack.InvalidAuthEvent:
 				s.log.Error("RTM reports invalid credentials; disconnecting...")
+				s.state.Set(StateAuthFailed, errors.New("invalid_auth"))
 				return
 			case *slack.IncomingEventError:
 				s.log.Errorf("Incoming Error '%+v'", msg)
+			case *slack.ConnectionErrorEvent:
+				s.log.Errorf("Connection error: %s", ev)
+				s.state.Set(StateDisconnected, ev)
 			case *slack.DisconnectedEvent:
-				s.log.Errorf("Disconnected...", msg)
+				s.log.Errorf("Disconnected... %+v", msg)
+				if !ev.Intentional {
+					s.state.Set(StateDisconnected, nil)
+				}
 			default:
 				s.log.Debugf("Event Received: %+v", msg)
 			}
@@ -0,0 +1,116 @@
+package channelstats
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	slashCommandUsage = "Usage: `/stats [#channel] [counter] [period]` or `/stats me [#channel] [period]`\n" +
+		"Counters: %s\nPeriods: `24h`, `7d`, `2w`, `today`, `this week` or `this month`"
+	// Slack expects a response within 3 seconds, chart rendering and upload happens after we respond
+	slashCommandTimeout = time.Second * 2
+	chartUploadTimeout  = time.Second * 30
+)
+
+// Answers the '/stats' slash command with an ephemeral summary of the requested counter
+// and uploads a chart of the counter to the user via direct message.
+type SlashCommandHandler struct {
+	log   *logrus.Entry
+	conf  Config
+	store Storer
+	idMgr IDManager
+	api   *SlackAPI
+}
+
+func NewSlashCommandHandler(conf Config, store Storer, idMgr IDManager) *SlashCommandHandler {
+	return &SlashCommandHandler{
+		log:   GetLogger().WithField("prefix", "slash-command"),
+		conf:  conf,
+		store: store,
+		idMgr: idMgr,
+		api:   NewSlackAPI(conf),
+	}
+}
+
+func (h *SlashCommandHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := verifySlackRequest(r, h.conf.Slack.SigningSecret)
+	if err != nil {
+		abort(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		abort(w, errors.Wrap(err, "while decoding slash command"), http.StatusBadRequest)
+		return
+	}
+
+	userID := form.Get("user_id")
+	args, err := ParseCommandArgs(form.Get("text"), h.idMgr, time.Now())
+	if err != nil {
+		toJSON(w, ephemeral(fmt.Sprintf("%s\n"+slashCommandUsage, err, strings.Join(validCounters, ", "))))
+		return
+	}
+	if len(args.Channels) == 0 {
+		args.Channels = []string{form.Get("channel_id")}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), slashCommandTimeout)
+	defer cancel()
+
+	var msg SlackMessage
+	if args.Me {
+		msg, err = UserStatsMessage(ctx, h.store, h.idMgr, args, userID)
+	} else {
+		msg, err = TopUsersMessage(ctx, h.store, h.idMgr, args)
+		msg.Blocks = append(msg.Blocks, contextBlock("A chart is on its way via direct message."))
+	}
+	if err != nil {
+		h.log.Errorf("while answering '%s %s': %s", form.Get("command"), form.Get("text"), err)
+		toJSON(w, ephemeral("Sorry, something went wrong while fetching those stats"))
+		return
+	}
+	msg.ResponseType = "ephemeral"
+	toJSON(w, msg)
+
+	if !args.Me {
+		go h.uploadChart(args, userID)
+	}
+}
+
+// Render the chart for the counter and upload it to the users direct message channel
+func (h *SlashCommandHandler) uploadChart(args CommandArgs, userID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), chartUploadTimeout)
+	defer cancel()
+
+	channelID := args.Channels[0]
+	var buf bytes.Buffer
+	if err := renderFor(args.Counter)(ctx, h.store, &buf, args.TimeRange, channelID, args.Counter); err != nil {
+		h.log.Errorf("while rendering chart for '%s' '%s': %s", channelID, args.Counter, err)
+		return
+	}
+
+	dm, err := h.api.OpenDM(userID)
+	if err != nil {
+		h.log.Errorf("while opening direct message with '%s': %s", userID, err)
+		return
+	}
+
+	title := fmt.Sprintf("%s in #%s for %s", args.Counter, channelName(h.idMgr, channelID), args.Period)
+	if err := h.api.UploadFile(dm, "", args.Counter+".png", title, buf.Bytes()); err != nil {
+		h.log.Errorf("while uploading chart to '%s': %s", userID, err)
+	}
+}
+
+func ephemeral(text string) SlackMessage {
+	return SlackMessage{ResponseType: "ephemeral", Text: text}
+}
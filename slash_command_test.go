@@ -0,0 +1,187 @@
+package channelstats_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestSlashCommand(t *testing.T) {
+	suite.Run(t, new(SlashCommandSuite))
+}
+
+type upload struct {
+	Channel  string
+	Filename string
+}
+
+type SlashCommandSuite struct {
+	suite.Suite
+	idMgr   *channelstats.MockIDManage
+	store   channelstats.Storer
+	handler http.Handler
+	slack   *httptest.Server
+	uploads chan upload
+}
+
+func (s *SlashCommandSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *SlashCommandSuite) SetupTest() {
+	s.uploads = make(chan upload, 10)
+
+	// A fake slack web API
+	mux := http.NewServeMux()
+	mux.HandleFunc("/conversations.open", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok": true, "channel": {"id": "D1"}}`)
+	})
+	mux.HandleFunc("/files.upload", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_form_data"}`)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			fmt.Fprint(w, `{"ok": false, "error": "no_file_data"}`)
+			return
+		}
+		file.Close()
+		s.uploads <- upload{Channel: r.FormValue("channels"), Filename: r.FormValue("filename")}
+		fmt.Fprint(w, `{"ok": true}`)
+	})
+	s.slack = httptest.NewServer(mux)
+
+	var conf channelstats.Config
+	conf.Store.Backend = "memory"
+	conf.Slack.SigningSecret = signingSecret
+	conf.Slack.APIURL = s.slack.URL
+
+	s.idMgr = &channelstats.MockIDManage{UserByID: map[string]string{"U1": "joe", "U2": "scott"}}
+
+	var err error
+	s.store, err = channelstats.NewStore(conf, s.idMgr)
+	s.Require().NoError(err)
+	s.handler = channelstats.NewSlashCommandHandler(conf, s.store, s.idMgr)
+
+	now := time.Now()
+	for i, user := range []string{"U1", "U1", "U1", "U2"} {
+		ts := fmt.Sprintf("%d.%06d", now.Unix(), i)
+		s.Require().NoError(s.store.HandleMessage(&slack.MessageEvent{
+			Msg: slack.Msg{Timestamp: ts, Channel: "C1", User: user, Text: "hello http://example.com"},
+		}))
+	}
+}
+
+func (s *SlashCommandSuite) TearDownTest() {
+	s.slack.Close()
+	s.store.Close()
+}
+
+func (s *SlashCommandSuite) command(text, secret string) (int, channelstats.SlackMessage) {
+	form := url.Values{
+		"command":    []string{"/stats"},
+		"text":       []string{text},
+		"channel_id": []string{"C1"},
+		"user_id":    []string{"U1"},
+	}
+	body := form.Encode()
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/slack/commands", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", channelstats.SlackSignature(secret, ts, []byte(body)))
+
+	resp := httptest.NewRecorder()
+	s.handler.ServeHTTP(resp, req)
+
+	var msg channelstats.SlackMessage
+	if resp.Code == http.StatusOK {
+		s.Require().NoError(json.Unmarshal(resp.Body.Bytes(), &msg))
+	}
+	return resp.Code, msg
+}
+
+func (s *SlashCommandSuite) TestTopUsers() {
+	code, msg := s.command("<#C1|general> link 7d", signingSecret)
+	s.Require().Equal(http.StatusOK, code)
+	s.Equal("ephemeral", msg.ResponseType)
+	s.Require().Len(msg.Blocks, 4)
+	s.Equal("Top link in #general", msg.Blocks[0].Text.Text)
+	s.Equal("1. *joe* — 3\n2. *scott* — 1", msg.Blocks[1].Text.Text)
+	s.Equal("For the last 7 days", msg.Blocks[2].Elements[0].Text)
+
+	select {
+	case u := <-s.uploads:
+		s.Equal("D1", u.Channel)
+		s.Equal("link.png", u.Filename)
+	case <-time.After(time.Second * 10):
+		s.Fail("timed out waiting for chart upload")
+	}
+}
+
+func (s *SlashCommandSuite) TestMe() {
+	code, msg := s.command("me", signingSecret)
+	s.Require().Equal(http.StatusOK, code)
+	s.Require().Len(msg.Blocks, 1)
+	s.Equal("*Your stats in #general* for the last 7 days", msg.Blocks[0].Text.Text)
+	s.Contains(msg.Blocks[0].Fields, channelstats.TextObject{Type: "mrkdwn", Text: "*messages*\n3"})
+	s.Contains(msg.Blocks[0].Fields, channelstats.TextObject{Type: "mrkdwn", Text: "*negative*\n0"})
+}
+
+func (s *SlashCommandSuite) TestUsage() {
+	code, msg := s.command("bogus", signingSecret)
+	s.Require().Equal(http.StatusOK, code)
+	s.Equal("ephemeral", msg.ResponseType)
+	s.Contains(msg.Text, "I don't understand 'bogus'")
+	s.Contains(msg.Text, "Usage:")
+}
+
+func (s *SlashCommandSuite) TestInvalidSignature() {
+	code, _ := s.command("me", "wrong-secret")
+	s.Equal(http.StatusUnauthorized, code)
+}
+
+func (s *SlashCommandSuite) TestParseCommandArgs() {
+	// Wednesday
+	now := time.Date(2018, 12, 12, 15, 30, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		text   string
+		start  time.Time
+		period string
+	}{
+		{text: "", start: now.Add(-time.Hour * 24 * 7), period: "the last 7 days"},
+		{text: "24h", start: now.Add(-time.Hour * 24), period: "the last 24 hours"},
+		{text: "1w", start: now.Add(-time.Hour * 24 * 7), period: "the last week"},
+		{text: "today", start: time.Date(2018, 12, 12, 0, 0, 0, 0, time.UTC), period: "today"},
+		{text: "this week", start: time.Date(2018, 12, 10, 0, 0, 0, 0, time.UTC), period: "this week"},
+		{text: "this month", start: time.Date(2018, 12, 1, 0, 0, 0, 0, time.UTC), period: "this month"},
+	} {
+		args, err := channelstats.ParseCommandArgs(test.text, s.idMgr, now)
+		s.Require().NoError(err, test.text)
+		s.Equal(test.start, args.TimeRange.Start, test.text)
+		s.Equal(now, args.TimeRange.End, test.text)
+		s.Equal(test.period, args.Period, test.text)
+		s.Equal("messages", args.Counter, test.text)
+	}
+
+	args, err := channelstats.ParseCommandArgs("my <#C1|general> #random Emoji", s.idMgr, now)
+	s.Require().NoError(err)
+	s.True(args.Me)
+	s.Equal([]string{"C1", "C02C073ND"}, args.Channels)
+	s.Equal("emoji", args.Counter)
+
+	_, err = channelstats.ParseCommandArgs("this year", s.idMgr, now)
+	s.EqualError(err, "unknown period 'this year'; expected 'this week' or 'this month'")
+}
@@ -0,0 +1,334 @@
+package channelstats
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Upgrade the connection via STARTTLS, the server must support STARTTLS
+	SMTPStartTLS = "starttls"
+	// Connect via TLS, usually on port 465
+	SMTPImplicitTLS = "tls"
+	// Do not encrypt the connection, authentication is only attempted to localhost
+	SMTPNoTLS = "none"
+
+	SMTPAuthPlain = "plain"
+	SMTPAuthLogin = "login"
+)
+
+var (
+	htmlTagRegex   = regexp.MustCompile(`(?s)<(style|head)[^>]*>.*?</(style|head)>|<[^>]+>`)
+	blankLineRegex = regexp.MustCompile(`\n\s*\n\s*(\n\s*)*`)
+)
+
+// Sends email via an SMTP server
+type SMTPMailer struct {
+	log  *logrus.Entry
+	conf SMTPConfig
+}
+
+func NewSMTPMailer(conf Config) (Mailer, error) {
+	return &SMTPMailer{
+		log:  GetLogger().WithField("prefix", "mailer"),
+		conf: conf.Mail.SMTP,
+	}, nil
+}
+
+// Send a report to the designated email address (could be mailing list)
+func (m *SMTPMailer) Report(channelName string, data ReportData) error {
+	to := strings.Join(data.Recipients, ", ")
+	if to == "" {
+		if m.conf.ReportAddr == "" {
+			m.log.Errorf("mail.backend = 'smtp'; however mail.smtp.report-address is empty; skipping..")
+			return nil
+		}
+		to = m.conf.ReportAddr
+	}
+
+	msg, err := buildReportMessage(m.conf.From, to, fmt.Sprintf("[channel-stats] Report for %s", channelName), data)
+	if err != nil {
+		return errors.Wrap(err, "while building report email")
+	}
+
+	if err := m.send(to, msg); err != nil {
+		return errors.Wrap(err, "while sending report via SMTP")
+	}
+	m.log.Infof("Sent report for '%s' via SMTP", channelName)
+	return nil
+}
+
+// Send an email message to the designated operator the this chat bot
+func (m *SMTPMailer) Operator(msg string) error {
+	if m.conf.OperatorAddr == "" {
+		m.log.Errorf("mail.backend = 'smtp'; however mail.smtp.operator-address is empty; skipping..")
+		return nil
+	}
+
+	var buf bytes.Buffer
+	writeHeaders(&buf, m.conf.From, m.conf.OperatorAddr, "[channel-stats] Operator Notification")
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	if err := writeQuotedPrintable(&buf, []byte(msg)); err != nil {
+		return errors.Wrap(err, "while building operator email")
+	}
+
+	if err := m.send(m.conf.OperatorAddr, buf.Bytes()); err != nil {
+		return errors.Wrap(err, "while sending operator notification via SMTP")
+	}
+	m.log.Info("Notified operator via SMTP")
+	return nil
+}
+
+func (m *SMTPMailer) send(to string, msg []byte) error {
+	addr := net.JoinHostPort(m.conf.Host, strconv.Itoa(m.conf.Port))
+	tlsConf := &tls.Config{ServerName: m.conf.Host, InsecureSkipVerify: m.conf.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: m.conf.Timeout.Duration}
+
+	var conn net.Conn
+	var err error
+	if m.conf.TLS == SMTPImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConf)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "while connecting to '%s'", addr)
+	}
+	defer conn.Close()
+
+	if m.conf.Timeout.Duration != 0 {
+		conn.SetDeadline(time.Now().Add(m.conf.Timeout.Duration))
+	}
+
+	c, err := smtp.NewClient(conn, m.conf.Host)
+	if err != nil {
+		return errors.Wrap(err, "while greeting SMTP server")
+	}
+	defer c.Close()
+
+	if m.conf.TLS == SMTPStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not support STARTTLS; set mail.smtp.tls = 'none' to send unencrypted")
+		}
+		if err := c.StartTLS(tlsConf); err != nil {
+			return errors.Wrap(err, "during STARTTLS")
+		}
+	}
+
+	if m.conf.Username != "" {
+		var auth smtp.Auth
+		if m.conf.Auth == SMTPAuthLogin {
+			auth = &loginAuth{host: m.conf.Host, username: m.conf.Username, password: m.conf.Password}
+		} else {
+			auth = smtp.PlainAuth("", m.conf.Username, m.conf.Password, m.conf.Host)
+		}
+		if err := c.Auth(auth); err != nil {
+			return errors.Wrap(err, "during SMTP authentication")
+		}
+	}
+
+	if err := c.Mail(m.conf.From); err != nil {
+		return errors.Wrap(err, "during MAIL FROM")
+	}
+	for _, addr := range strings.Split(to, ",") {
+		if err := c.Rcpt(strings.TrimSpace(addr)); err != nil {
+			return errors.Wrapf(err, "during RCPT TO '%s'", addr)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "during DATA")
+	}
+	if _, err := w.Write(msg); err != nil {
+		return errors.Wrap(err, "while writing message")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "while writing message")
+	}
+	return c.Quit()
+}
+
+// Implements the LOGIN mechanism which is not provided by net/smtp
+type loginAuth struct {
+	host     string
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like smtp.PlainAuth, refuse to send credentials over an unencrypted connection
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, errors.Errorf("unexpected LOGIN challenge '%s'", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// Build a multipart/alternative message with a text/plain part and a multipart/related part which
+// contains the HTML and the images it references via 'cid:<file name>'
+func buildReportMessage(from, to, subject string, data ReportData) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeaders(&buf, from, to, subject)
+
+	alt := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", alt.Boundary())
+
+	text := data.Text
+	if len(text) == 0 {
+		text = htmlToText(data.Html)
+	}
+	part, err := alt.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(part, text); err != nil {
+		return nil, err
+	}
+
+	var related bytes.Buffer
+	rel := multipart.NewWriter(&related)
+	part, err = alt.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {fmt.Sprintf("multipart/related; type=\"text/html\"; boundary=%s", rel.Boundary())},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	htmlPart, err := rel.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(htmlPart, data.Html); err != nil {
+		return nil, err
+	}
+
+	// Sort the images such that the message is the same for the same report
+	var files []string
+	for file := range data.Images {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		contentType := mime.TypeByExtension(filepath.Ext(file))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		image, err := rel.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-ID":                {fmt.Sprintf("<%s>", file)},
+			"Content-Disposition":       {mime.FormatMediaType("inline", map[string]string{"filename": file})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(image, data.Images[file])
+	}
+	if err := rel.Close(); err != nil {
+		return nil, err
+	}
+
+	if _, err := part.Write(related.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := alt.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeaders(w io.Writer, from, to, subject string) {
+	fmt.Fprintf(w, "From: %s\r\n", from)
+	fmt.Fprintf(w, "To: %s\r\n", to)
+	fmt.Fprintf(w, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(w, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(w, "Message-ID: <%s@channel-stats>\r\n", randomID())
+	fmt.Fprintf(w, "MIME-Version: 1.0\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, content []byte) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(content); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// Write base64 encoded content wrapped at 76 characters as required by RFC 2045
+func writeBase64(w io.Writer, content []byte) {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		fmt.Fprintf(w, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(w, "%s\r\n", encoded)
+}
+
+// A crude conversion of the report HTML to text, used when the report has no text version
+func htmlToText(content []byte) []byte {
+	text := htmlTagRegex.ReplaceAllString(string(content), "")
+	text = html.UnescapeString(text)
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	text = blankLineRegex.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return []byte(strings.TrimSpace(text) + "\n")
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
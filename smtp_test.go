@@ -0,0 +1,322 @@
+package channelstats_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+func TestSMTP(t *testing.T) {
+	suite.Run(t, new(SMTPSuite))
+}
+
+// A local SMTP stand-in which records the messages it receives
+type smtpServer struct {
+	listener net.Listener
+	tlsConf  *tls.Config
+	// Accept connections via TLS instead of offering STARTTLS
+	implicitTLS bool
+	noStartTLS  bool
+
+	mutex    sync.Mutex
+	auth     []string
+	rcpts    []string
+	messages []string
+	wg       sync.WaitGroup
+}
+
+func newSMTPServer(tlsConf *tls.Config, implicitTLS, noStartTLS bool) (*smtpServer, error) {
+	var listener net.Listener
+	var err error
+	if implicitTLS {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConf)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &smtpServer{listener: listener, tlsConf: tlsConf, implicitTLS: implicitTLS, noStartTLS: noStartTLS}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.handle(conn)
+			}()
+		}
+	}()
+	return s, nil
+}
+
+func (s *smtpServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *smtpServer) Auth() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.auth
+}
+
+func (s *smtpServer) Recipients() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rcpts
+}
+
+func (s *smtpServer) Messages() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.messages
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	isTLS := s.implicitTLS
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+
+		switch cmd {
+		case "EHLO", "HELO":
+			if !isTLS && !s.noStartTLS {
+				tp.PrintfLine("250-localhost")
+				tp.PrintfLine("250-STARTTLS")
+			} else {
+				tp.PrintfLine("250-localhost")
+			}
+			tp.PrintfLine("250 AUTH PLAIN LOGIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConf)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, isTLS = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			fields := strings.Fields(line)
+			if strings.ToUpper(fields[1]) == "PLAIN" {
+				creds, _ := base64.StdEncoding.DecodeString(fields[2])
+				parts := strings.Split(string(creds), "\x00")
+				s.record(&s.auth, "plain "+parts[1]+" "+parts[2])
+			} else {
+				tp.PrintfLine("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				user, _ := tp.ReadLine()
+				tp.PrintfLine("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				pass, _ := tp.ReadLine()
+				u, _ := base64.StdEncoding.DecodeString(user)
+				p, _ := base64.StdEncoding.DecodeString(pass)
+				s.record(&s.auth, "login "+string(u)+" "+string(p))
+			}
+			tp.PrintfLine("235 Authentication successful")
+		case "RCPT":
+			s.record(&s.rcpts, strings.TrimSpace(line[len("RCPT TO:"):]))
+			tp.PrintfLine("250 OK")
+		case "MAIL", "RSET", "NOOP":
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			msg, err := ioutil.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			s.record(&s.messages, string(msg))
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpServer) record(list *[]string, item string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	*list = append(*list, item)
+}
+
+type SMTPSuite struct {
+	suite.Suite
+	tlsConf *tls.Config
+}
+
+func (s *SMTPSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+
+	// Borrow the self signed certificate httptest uses
+	server := httptest.NewUnstartedServer(nil)
+	server.StartTLS()
+	s.tlsConf = &tls.Config{Certificates: server.TLS.Certificates}
+	server.Close()
+}
+
+func (s *SMTPSuite) mailer(server *smtpServer, tlsMode, auth string) channelstats.Mailer {
+	var conf channelstats.Config
+	conf.Mail.Backend = channelstats.MailBackendSMTP
+	conf.Mail.SMTP = channelstats.SMTPConfig{
+		Host:               "127.0.0.1",
+		Port:               server.Port(),
+		TLS:                tlsMode,
+		InsecureSkipVerify: true,
+		Username:           "bot",
+		Password:           "s3cret",
+		Auth:               auth,
+		From:               "channel-stats@example.com",
+		OperatorAddr:       "operator@example.com",
+		ReportAddr:         "team@example.com",
+	}
+	mailer, err := channelstats.NewMailer(conf)
+	s.Require().NoError(err)
+	return mailer
+}
+
+func (s *SMTPSuite) TestReportViaStartTLS() {
+	server, err := newSMTPServer(s.tlsConf, false, false)
+	s.Require().NoError(err)
+	defer server.Close()
+
+	image := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 100)
+	err = s.mailer(server, channelstats.SMTPStartTLS, channelstats.SMTPAuthPlain).Report("general", channelstats.ReportData{
+		Html: []byte("<html><head><style>p {}</style></head><body><p>Channel general</p>" +
+			"<img src=\"cid:most-active.png\"/></body></html>"),
+		Images: map[string][]byte{"most-active.png": image},
+	})
+	s.Require().NoError(err)
+
+	s.Equal([]string{"plain bot s3cret"}, server.Auth())
+	s.Require().Len(server.Messages(), 1)
+
+	msg, err := mail.ReadMessage(strings.NewReader(server.Messages()[0]))
+	s.Require().NoError(err)
+	s.Equal("[channel-stats] Report for general", msg.Header.Get("Subject"))
+	s.Equal("team@example.com", msg.Header.Get("To"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	s.Require().NoError(err)
+	s.Equal("multipart/alternative", mediaType)
+	alt := multipart.NewReader(msg.Body, params["boundary"])
+
+	// The text alternative is generated from the HTML
+	text, err := alt.NextPart()
+	s.Require().NoError(err)
+	s.Equal("text/plain; charset=utf-8", text.Header.Get("Content-Type"))
+	content, err := ioutil.ReadAll(text)
+	s.Require().NoError(err)
+	s.Equal("Channel general\n", string(content))
+
+	related, err := alt.NextPart()
+	s.Require().NoError(err)
+	mediaType, params, err = mime.ParseMediaType(related.Header.Get("Content-Type"))
+	s.Require().NoError(err)
+	s.Equal("multipart/related", mediaType)
+	rel := multipart.NewReader(related, params["boundary"])
+
+	htmlPart, err := rel.NextPart()
+	s.Require().NoError(err)
+	s.Equal("text/html; charset=utf-8", htmlPart.Header.Get("Content-Type"))
+	content, err = ioutil.ReadAll(htmlPart)
+	s.Require().NoError(err)
+	s.Contains(string(content), `<img src="cid:most-active.png"/>`)
+
+	imagePart, err := rel.NextPart()
+	s.Require().NoError(err)
+	s.Equal("image/png", imagePart.Header.Get("Content-Type"))
+	s.Equal("<most-active.png>", imagePart.Header.Get("Content-Id"))
+	s.Equal("inline; filename=most-active.png", imagePart.Header.Get("Content-Disposition"))
+	encoded, err := ioutil.ReadAll(imagePart)
+	s.Require().NoError(err)
+	decoded, err := base64.StdEncoding.DecodeString(strings.Replace(string(encoded), "\r\n", "", -1))
+	s.Require().NoError(err)
+	s.Equal(image, decoded)
+}
+
+func (s *SMTPSuite) TestReportRecipients() {
+	server, err := newSMTPServer(s.tlsConf, false, false)
+	s.Require().NoError(err)
+	defer server.Close()
+
+	// The recipients of the channel replace the report address
+	err = s.mailer(server, channelstats.SMTPStartTLS, channelstats.SMTPAuthPlain).Report("general", channelstats.ReportData{
+		Html:       []byte("<html><body><p>Channel general</p></body></html>"),
+		Recipients: []string{"incidents@example.com", "sre@example.com"},
+	})
+	s.Require().NoError(err)
+
+	s.Equal([]string{"<incidents@example.com>", "<sre@example.com>"}, server.Recipients())
+	s.Require().Len(server.Messages(), 1)
+	msg, err := mail.ReadMessage(strings.NewReader(server.Messages()[0]))
+	s.Require().NoError(err)
+	s.Equal("incidents@example.com, sre@example.com", msg.Header.Get("To"))
+}
+
+func (s *SMTPSuite) TestOperatorViaImplicitTLS() {
+	server, err := newSMTPServer(s.tlsConf, true, false)
+	s.Require().NoError(err)
+	defer server.Close()
+
+	err = s.mailer(server, channelstats.SMTPImplicitTLS, channelstats.SMTPAuthLogin).Operator("disconnected from slack")
+	s.Require().NoError(err)
+
+	s.Equal([]string{"login bot s3cret"}, server.Auth())
+	s.Require().Len(server.Messages(), 1)
+
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(server.Messages()[0])))
+	s.Require().NoError(err)
+	s.Equal("[channel-stats] Operator Notification", msg.Header.Get("Subject"))
+	s.Equal("operator@example.com", msg.Header.Get("To"))
+	s.Equal("text/plain; charset=utf-8", msg.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(msg.Body)
+	s.Require().NoError(err)
+	s.Equal("disconnected from slack\n", string(body))
+}
+
+func (s *SMTPSuite) TestStartTLSNotSupported() {
+	server, err := newSMTPServer(s.tlsConf, false, true)
+	s.Require().NoError(err)
+	defer server.Close()
+
+	err = s.mailer(server, channelstats.SMTPStartTLS, channelstats.SMTPAuthPlain).Operator("hello")
+	s.Require().Error(err)
+	s.Contains(err.Error(), "does not support STARTTLS")
+	s.Empty(server.Messages())
+
+	// Without TLS credentials are only sent to localhost
+	s.Require().NoError(s.mailer(server, channelstats.SMTPNoTLS, channelstats.SMTPAuthLogin).Operator("hello"))
+	s.Equal([]string{"login bot s3cret"}, server.Auth())
+}
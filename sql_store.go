@@ -0,0 +1,470 @@
+package channelstats
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// The schema is compatible with both SQLite and PostgreSQL. Each row is a single
+// counter for a user in a channel during an hour, identical to the badger keys.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS datapoints (
+		hour       VARCHAR(13) NOT NULL,
+		counter    VARCHAR(32) NOT NULL,
+		channel_id VARCHAR(32) NOT NULL,
+		user_id    VARCHAR(32) NOT NULL,
+		value      BIGINT NOT NULL,
+		PRIMARY KEY (hour, counter, channel_id, user_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS datapoints_by_channel ON datapoints (channel_id, counter, hour)`,
+	`CREATE TABLE IF NOT EXISTS events (
+		id VARCHAR(255) NOT NULL PRIMARY KEY
+	)`,
+	// The hour each event counted live occurred in, such that they can be pruned
+	`CREATE TABLE IF NOT EXISTS live_events (
+		id   VARCHAR(255) NOT NULL PRIMARY KEY,
+		hour VARCHAR(13) NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS live_events_by_hour ON live_events (hour)`,
+	// The report summary and the rendered report are JSON encoded
+	`CREATE TABLE IF NOT EXISTS reports (
+		id         VARCHAR(64) NOT NULL PRIMARY KEY,
+		channel_id VARCHAR(32) NOT NULL,
+		summary    TEXT NOT NULL,
+		content    TEXT NOT NULL
+	)`,
+}
+
+const sqlUpsert = `INSERT INTO datapoints (hour, counter, channel_id, user_id, value)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (hour, counter, channel_id, user_id)
+	DO UPDATE SET value = datapoints.value + excluded.value`
+
+// The number of data points ForEach() reads from the database at a time
+const sqlForEachPageSize = 1000
+
+const sqlInsertEvent = `INSERT INTO events (id) VALUES ($1) ON CONFLICT (id) DO NOTHING`
+
+const sqlInsertLiveEvent = `INSERT INTO live_events (id, hour) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`
+
+type SQLStore struct {
+	idMgr   IDManager
+	log     *logrus.Entry
+	db      *sql.DB
+	journal *Journal
+}
+
+func NewSQLStore(conf Config, idMgr IDManager) (Storer, error) {
+	logger := GetLogger().WithField("prefix", "store")
+
+	journal, err := NewJournal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(conf.Store.Backend, conf.Store.DSN)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while opening '%s' database", conf.Store.Backend)
+	}
+
+	// SQLite only allows a single writer
+	if conf.Store.Backend == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+
+	for _, stmt := range sqlSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "while creating database schema")
+		}
+	}
+
+	return &SQLStore{
+		journal: journal,
+		log:     logger,
+		idMgr:   idMgr,
+		db:      db,
+	}, nil
+}
+
+func (s *SQLStore) GetDataPoints(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]DataPoint, error) {
+	s.log.Debugf("GetDataPoints(%+v, %s, %s)", *timeRange, counter, channelID)
+	start, end := hourBounds(timeRange)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT hour, counter, channel_id, user_id, value FROM datapoints
+		WHERE channel_id = $1 AND counter = $2 AND hour >= $3 AND hour <= $4
+		ORDER BY hour, user_id`, channelID, counter, start, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "while querying data points")
+	}
+	return s.scanDataPoints(rows)
+}
+
+func (s *SQLStore) SumByUser(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]SumResp, error) {
+	start, end := hourBounds(timeRange)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id, SUM(value) FROM datapoints
+		WHERE channel_id = $1 AND counter = $2 AND hour >= $3 AND hour <= $4
+		GROUP BY user_id`, channelID, counter, start, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "while querying sum by user")
+	}
+	defer rows.Close()
+
+	var dataPoints []DataPoint
+	for rows.Next() {
+		dp := DataPoint{ChannelID: channelID, Counter: counter}
+		if err := rows.Scan(&dp.UserID, &dp.Value); err != nil {
+			return nil, errors.Wrap(err, "while scanning sum by user")
+		}
+		if err = dp.ResolveID(s.idMgr); err != nil {
+			s.log.Debugf("while resolving data point ids for '%+v': %s", dp, err)
+		}
+		dataPoints = append(dataPoints, dp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "while iterating sum by user")
+	}
+
+	// Users are summed by name, as multiple ids could resolve to the same name
+	return sumByUser(dataPoints), nil
+}
+
+func (s *SQLStore) PercentageByUser(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]PercentageResp, error) {
+	// Get the total number of messages for the channel during this time
+	messages, err := s.SumByUser(ctx, timeRange, channelID, "messages")
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the data type counts during this time
+	counters, err := s.SumByUser(ctx, timeRange, channelID, counter)
+	if err != nil {
+		return nil, err
+	}
+
+	return percentageByUser(messages, counters), nil
+}
+
+func (s *SQLStore) ForEach(ctx context.Context, filter DataPointFilter, fn func(DataPoint) error) error {
+	var where []string
+	var args []interface{}
+
+	add := func(clause string, values ...interface{}) {
+		for _, value := range values {
+			args = append(args, value)
+			clause = strings.Replace(clause, "?", fmt.Sprintf("$%d", len(args)), 1)
+		}
+		where = append(where, clause)
+	}
+
+	if filter.ChannelID != "" {
+		add("channel_id = ?", filter.ChannelID)
+	}
+	if filter.Counter != "" {
+		add("counter = ?", filter.Counter)
+	}
+	if filter.StartHour != "" {
+		add("hour >= ?", filter.StartHour)
+	}
+	if filter.EndHour != "" {
+		add("hour <= ?", filter.EndHour)
+	}
+
+	// Page through the data points and close the rows before calling fn(), such that a slow
+	// caller does not hold the only SQLite connection and block every write.
+	after := filter.After
+	for {
+		page, err := s.dataPointsAfter(ctx, where, args, after)
+		if err != nil {
+			return err
+		}
+		for _, dp := range page {
+			if err := fn(dp); err != nil {
+				return err
+			}
+		}
+		if len(page) < sqlForEachPageSize {
+			return nil
+		}
+		after = string(page[len(page)-1].Key())
+	}
+}
+
+// Returns the next page of data points matching the where clauses with a key greater than 'after'
+func (s *SQLStore) dataPointsAfter(ctx context.Context, where []string, args []interface{}, after string) ([]DataPoint, error) {
+	where = append([]string{}, where...)
+	args = append([]interface{}{}, args...)
+
+	if after != "" {
+		parts := strings.SplitN(after, "/", 4)
+		if len(parts) != 4 {
+			return nil, errors.Errorf("invalid cursor '%s'", after)
+		}
+		where = append(where, fmt.Sprintf("(hour, counter, channel_id, user_id) > ($%d, $%d, $%d, $%d)",
+			len(args)+1, len(args)+2, len(args)+3, len(args)+4))
+		args = append(args, parts[0], parts[1], parts[2], parts[3])
+	}
+
+	query := "SELECT hour, counter, channel_id, user_id, value FROM datapoints"
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY hour, counter, channel_id, user_id LIMIT %d", sqlForEachPageSize)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "while querying data points")
+	}
+	return s.scanDataPoints(rows)
+}
+
+func (s *SQLStore) HandleReactionAdded(ev *slack.ReactionAddedEvent) error {
+	_, err := s.handleReactionAdded(ev, true)
+	return err
+}
+
+func (s *SQLStore) HandleMessage(ev *slack.MessageEvent) error {
+	_, err := s.handleMessage(ev, true)
+	return err
+}
+
+func (s *SQLStore) HandleReactionAddedOnce(ev *slack.ReactionAddedEvent) (bool, error) {
+	return s.handleReactionAdded(ev, false)
+}
+
+func (s *SQLStore) HandleMessageOnce(ev *slack.MessageEvent) (bool, error) {
+	return s.handleMessage(ev, false)
+}
+
+func (s *SQLStore) handleReactionAdded(ev *slack.ReactionAddedEvent, live bool) (bool, error) {
+	dps, err := dataPointsFromReaction(ev)
+	if err != nil {
+		return false, errors.Wrap(err, "while handling reaction added")
+	}
+	counted, err := s.saveDataPoints(reactionEventID(ev), liveEventHour(ev.EventTimestamp, live), dps)
+	if err != nil || !counted {
+		return counted, err
+	}
+	return true, s.journal.Reaction(ev, dps)
+}
+
+func (s *SQLStore) handleMessage(ev *slack.MessageEvent, live bool) (bool, error) {
+	dps, err := dataPointsFromMessage(ev)
+	if err != nil {
+		return false, errors.Wrap(err, "while handling message")
+	}
+	counted, err := s.saveDataPoints(messageEventID(ev), liveEventHour(ev.Timestamp, live), dps)
+	if err != nil || !counted {
+		return counted, err
+	}
+	return true, s.journal.Message(ev, dps)
+}
+
+func (s *SQLStore) AddDataPoints(dps []DataPoint) error {
+	_, err := s.saveDataPoints("", "", dps)
+	return err
+}
+
+func (s *SQLStore) DeleteCounter(ctx context.Context, counter, startHour string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM datapoints WHERE counter = $1 AND hour >= $2`, counter, startHour)
+	return errors.Wrapf(err, "while deleting '%s' data points", counter)
+}
+
+func (s *SQLStore) Close() error {
+	if err := s.journal.Close(); err != nil {
+		s.log.Errorf("while closing journal: %s", err)
+	}
+	return s.db.Close()
+}
+
+func (s *SQLStore) SaveReport(report ArchivedReport) error {
+	summary, content := report.split()
+	summaryJSON, err := json.Marshal(summary)
+	if err != nil {
+		return errors.Wrap(err, "while marshalling report")
+	}
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return errors.Wrap(err, "while marshalling report content")
+	}
+
+	_, err = s.db.Exec(`INSERT INTO reports (id, channel_id, summary, content) VALUES ($1, $2, $3, $4)`,
+		report.ID, report.ChannelID, string(summaryJSON), string(contentJSON))
+	return errors.Wrapf(err, "while saving report '%s'", report.ID)
+}
+
+func (s *SQLStore) ListReports(ctx context.Context, channelID string) ([]ArchivedReport, error) {
+	query := `SELECT summary FROM reports ORDER BY id`
+	var args []interface{}
+	if channelID != "" {
+		query = `SELECT summary FROM reports WHERE channel_id = $1 ORDER BY id`
+		args = append(args, channelID)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "while querying reports")
+	}
+	defer rows.Close()
+
+	var results []ArchivedReport
+	for rows.Next() {
+		var summary string
+		if err := rows.Scan(&summary); err != nil {
+			return nil, errors.Wrap(err, "while scanning report")
+		}
+		var report ArchivedReport
+		if err := json.Unmarshal([]byte(summary), &report); err != nil {
+			return nil, errors.Wrap(err, "while unmarshalling report")
+		}
+		results = append(results, report)
+	}
+	return results, errors.Wrap(rows.Err(), "while iterating reports")
+}
+
+func (s *SQLStore) GetReport(ctx context.Context, id string) (ArchivedReport, error) {
+	var report ArchivedReport
+	var summary, content string
+
+	err := s.db.QueryRowContext(ctx, `SELECT summary, content FROM reports WHERE id = $1`, id).
+		Scan(&summary, &content)
+	if err == sql.ErrNoRows {
+		return report, ErrReportNotFound
+	}
+	if err != nil {
+		return report, errors.Wrapf(err, "while querying report '%s'", id)
+	}
+
+	var rendered archivedContent
+	if err := json.Unmarshal([]byte(summary), &report); err != nil {
+		return report, errors.Wrapf(err, "while unmarshalling report '%s'", id)
+	}
+	if err := json.Unmarshal([]byte(content), &rendered); err != nil {
+		return report, errors.Wrapf(err, "while unmarshalling report content '%s'", id)
+	}
+	report.Html, report.Images = rendered.Html, rendered.Images
+	return report, nil
+}
+
+func (s *SQLStore) DeleteReports(ctx context.Context, before time.Time) (int, error) {
+	// Report ids sort by creation time
+	result, err := s.db.ExecContext(ctx, `DELETE FROM reports WHERE id < $1`, before.UTC().Format(reportIDTime))
+	if err != nil {
+		return 0, errors.Wrap(err, "while deleting reports")
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), errors.Wrap(err, "while counting deleted reports")
+}
+
+func (s *SQLStore) PruneEvents(ctx context.Context, before time.Time) (int, error) {
+	beforeHour := before.UTC().Format(RFC3339Short)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "while starting transaction")
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM events WHERE id IN
+		(SELECT id FROM live_events WHERE hour < $1)`, beforeHour)
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, "while pruning events")
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM live_events WHERE hour < $1`, beforeHour)
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, "while pruning live events")
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrap(err, "while counting pruned events")
+	}
+	return int(pruned), errors.Wrap(tx.Commit(), "while committing pruned events")
+}
+
+// Add the data points to the counters in a single transaction. If an eventID is provided the data
+// points are only added if the event has not been counted before. Live events record the hour they
+// occurred in, such that they can be pruned.
+func (s *SQLStore) saveDataPoints(eventID, liveHour string, dps []DataPoint) (bool, error) {
+	if len(dps) == 0 && eventID == "" {
+		return false, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "while starting transaction")
+	}
+
+	if eventID != "" {
+		result, err := tx.Exec(sqlInsertEvent, eventID)
+		if err != nil {
+			tx.Rollback()
+			return false, errors.Wrapf(err, "while storing event '%s'", eventID)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return false, errors.Wrapf(err, "while storing event '%s'", eventID)
+		}
+		// The event has already been counted
+		if rows == 0 {
+			tx.Rollback()
+			return false, nil
+		}
+	}
+
+	if eventID != "" && liveHour != "" {
+		if _, err := tx.Exec(sqlInsertLiveEvent, eventID, liveHour); err != nil {
+			tx.Rollback()
+			return false, errors.Wrapf(err, "while storing event '%s'", eventID)
+		}
+	}
+
+	for _, dp := range dps {
+		_, err := tx.Exec(sqlUpsert, dp.Hour, dp.Counter, dp.ChannelID, dp.UserID, dp.Value)
+		if err != nil {
+			tx.Rollback()
+			return false, errors.Wrapf(err, "while storing '%s' data point", dp.Counter)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "while committing data points")
+	}
+	return true, nil
+}
+
+func (s *SQLStore) scanDataPoints(rows *sql.Rows) ([]DataPoint, error) {
+	defer rows.Close()
+
+	var results []DataPoint
+	for rows.Next() {
+		var dp DataPoint
+		if err := rows.Scan(&dp.Hour, &dp.Counter, &dp.ChannelID, &dp.UserID, &dp.Value); err != nil {
+			return nil, errors.Wrap(err, "while scanning data point")
+		}
+		if err := dp.ResolveID(s.idMgr); err != nil {
+			s.log.Debugf("while resolving data point ids for '%+v': %s", dp, err)
+		}
+		results = append(results, dp)
+	}
+	return results, errors.Wrap(rows.Err(), "while iterating data points")
+}
+
+// Returns the first and last hour included in the time range, such that
+// hour >= start && hour <= end selects the same hours as TimeRange.ByHour()
+func hourBounds(timeRange *TimeRange) (string, string) {
+	hours := timeRange.ByHour()
+	return hours[0], hours[len(hours)-1]
+}
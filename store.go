@@ -1,7 +1,10 @@
 package channelstats
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"regexp"
 	"runtime/debug"
@@ -23,28 +26,125 @@ const (
 	IN  = 1
 )
 
+const (
+	// Keys which are not data points begin with this prefix, which sorts after every hour key
+	metaKeyPrefix = "~"
+	// Records the id of every counted event, such that no event is counted twice
+	eventKeyPrefix = metaKeyPrefix + "events/"
+	// Archived reports, the rendered report is stored under the content prefix
+	reportKeyPrefix        = metaKeyPrefix + "reports/"
+	reportContentKeyPrefix = metaKeyPrefix + "report-content/"
+)
+
 var linkRegex = regexp.MustCompile(`(http://|https://)`)
 var emojiRegex = regexp.MustCompile(`:([a-z0-9_\+\-]+):`)
 
 type Storer interface {
-	PercentageByUser(*TimeRange, string, string) ([]PercentageResp, error)
-	SumByUser(*TimeRange, string, string) ([]SumResp, error)
-	GetDataPoints(*TimeRange, string, string) ([]DataPoint, error)
+	// The results of PercentageByUser, SumByUser and GetDataPoints may be shared with
+	// other callers via the QueryCache and must not be modified
+	PercentageByUser(context.Context, *TimeRange, string, string) ([]PercentageResp, error)
+	SumByUser(context.Context, *TimeRange, string, string) ([]SumResp, error)
+	GetDataPoints(context.Context, *TimeRange, string, string) ([]DataPoint, error)
 	HandleReactionAdded(*slack.ReactionAddedEvent) error
 	HandleMessage(*slack.MessageEvent) error
-	GetAll() ([]DataPoint, error)
+	ForEach(context.Context, DataPointFilter, func(DataPoint) error) error
 	Close() error
 }
 
+// Selects which data points are passed to Storer.ForEach(), empty fields match everything
+type DataPointFilter struct {
+	// Only include data points for this channel id
+	ChannelID string
+	// Only include data points for this counter
+	Counter string
+	// Only include data points at or after this hour
+	StartHour string
+	// Only include data points at or before this hour
+	EndHour string
+	// Only include data points with a key greater than this key, used as a pagination cursor
+	After string
+}
+
+func (f DataPointFilter) Match(dp DataPoint) bool {
+	if f.ChannelID != "" && dp.ChannelID != f.ChannelID {
+		return false
+	}
+	if f.Counter != "" && dp.Counter != f.Counter {
+		return false
+	}
+	if f.StartHour != "" && dp.Hour < f.StartHour {
+		return false
+	}
+	if f.EndHour != "" && dp.Hour > f.EndHour {
+		return false
+	}
+	if f.After != "" && string(dp.Key()) <= f.After {
+		return false
+	}
+	return true
+}
+
+// Returns true if no data point which sorts after this one can match the filter
+func (f DataPointFilter) Done(dp DataPoint) bool {
+	return f.EndHour != "" && dp.Hour > f.EndHour
+}
+
+// Returns the key iteration should start from
+func (f DataPointFilter) SeekKey() string {
+	if f.After > f.StartHour {
+		return f.After
+	}
+	return f.StartHour
+}
+
+// Any Storer that can stream a consistent backup of its data while still serving requests
+type Backuper interface {
+	// Write a backup of all data newer than 'since' to the writer, returns the
+	// version to pass as 'since' on the next incremental backup
+	Backup(w io.Writer, since uint64) (uint64, error)
+	// Load a backup previously created by Backup()
+	Restore(r io.Reader) error
+}
+
+// Any Storer that can count an event exactly once, such that importing the same history
+// more than once does not inflate the counters. Returns false if the event was already counted.
+// Events counted live are recorded like those counted via the Deduper interface, such that
+// backfilling the channel history does not count them again.
+type Deduper interface {
+	HandleMessageOnce(*slack.MessageEvent) (bool, error)
+	HandleReactionAddedOnce(*slack.ReactionAddedEvent) (bool, error)
+}
+
+// Any Storer that can forget the events it counted live. Backfill never counts history older than
+// the look-back, so live events older than that no longer need to be recorded. Events counted via
+// the Deduper interface are never forgotten.
+type EventPruner interface {
+	// Forget the live events which occurred before the time, returns the number of events forgotten
+	PruneEvents(ctx context.Context, before time.Time) (int, error)
+}
+
 type Store struct {
-	idMgr    IDManager
-	log      *logrus.Entry
-	db       *badger.DB
-	cache    *holster.LRUCache
-	cacheTTL time.Duration
+	idMgr   IDManager
+	log     *logrus.Entry
+	db      *badger.DB
+	cache   *QueryCache
+	journal *Journal
 }
 
+// Create a new Storer using the backend selected by 'store.backend'
 func NewStore(conf Config, idMgr IDManager) (Storer, error) {
+	switch conf.Store.Backend {
+	case "", "badger":
+		return NewBadgerStore(conf, idMgr)
+	case "sqlite", "postgres":
+		return NewSQLStore(conf, idMgr)
+	case "memory":
+		return NewMemoryStore(conf, idMgr)
+	}
+	return nil, errors.Errorf("unknown store.backend '%s'", conf.Store.Backend)
+}
+
+func NewBadgerStore(conf Config, idMgr IDManager) (Storer, error) {
 	opts := badger.DefaultOptions
 	opts.Dir = conf.Store.DataDir
 	opts.ValueDir = conf.Store.DataDir
@@ -54,16 +154,21 @@ func NewStore(conf Config, idMgr IDManager) (Storer, error) {
 	logger := GetLogger().WithField("prefix", "store")
 	log.SetOutput(logger.Writer())
 
+	journal, err := NewJournal(conf)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := badger.Open(opts)
 	if err != nil {
 		return nil, errors.Wrap(err, "while opening badger database")
 	}
 	return &Store{
-		cache:    holster.NewLRUCache(conf.Store.CacheSize),
-		cacheTTL: conf.Store.CacheTTL.Duration,
-		log:      logger,
-		idMgr:    idMgr,
-		db:       db,
+		cache:   NewQueryCache(conf.Store.CacheSize, conf.Store.CacheTTL.Duration),
+		journal: journal,
+		log:     logger,
+		idMgr:   idMgr,
+		db:      db,
 	}, nil
 }
 
@@ -119,16 +224,41 @@ func (s *DataPoint) EncodeValue() []byte {
 	return []byte(fmt.Sprintf("%d", s.Value))
 }
 
-func (s *Store) GetDataPoints(timeRange *TimeRange, channelID, counter string) ([]DataPoint, error) {
+func (s *Store) QueryCache() *QueryCache {
+	return s.cache
+}
+
+func (s *Store) GetDataPoints(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]DataPoint, error) {
+	// Check the cache first
+	cacheKey := s.cache.Key("datapoints", timeRange, channelID, counter)
+	if item, ok := s.cache.Get(cacheKey); ok {
+		return item.([]DataPoint), nil
+	}
+
+	results, err := s.getDataPoints(ctx, timeRange, channelID, counter)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Add(cacheKey, results)
+	return results, nil
+}
+
+func (s *Store) getDataPoints(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]DataPoint, error) {
 	s.log.Debugf("GetDataPoints(%+v, %s, %s)", *timeRange, counter, channelID)
 	resultChan := make(chan DataPoint, 5)
+	var errs []error
 
 	go func() {
 		fan := holster.NewFanOut(5)
 		for _, hour := range timeRange.ByHour() {
+			// Stop scheduling scans if the caller has given up
+			if ctx.Err() != nil {
+				break
+			}
 			fan.Run(func(data interface{}) error {
 				key := DataPoint{Hour: data.(string), Counter: counter, ChannelID: channelID}.PrefixKey()
-				dps, err := s.GetByPrefix(key)
+				dps, err := s.GetByPrefix(ctx, key)
 				if err != nil {
 					return errors.Wrapf(err, "during while getting data points for prefix '%s'", key)
 				}
@@ -140,7 +270,7 @@ func (s *Store) GetDataPoints(timeRange *TimeRange, channelID, counter string) (
 				return nil
 			}, hour)
 		}
-		fan.Wait()
+		errs = fan.Wait()
 		close(resultChan)
 	}()
 
@@ -148,6 +278,14 @@ func (s *Store) GetDataPoints(timeRange *TimeRange, channelID, counter string) (
 	for dp := range resultChan {
 		results = append(results, dp)
 	}
+
+	// Partial results are never returned
+	if len(errs) != 0 {
+		return nil, errs[0]
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "while getting data points")
+	}
 	return results, nil
 }
 
@@ -156,44 +294,24 @@ type SumResp struct {
 	Sum  int64  `json:"sum"`
 }
 
-func (s *Store) SumByUser(timeRange *TimeRange, channelID, counter string) ([]SumResp, error) {
+func (s *Store) SumByUser(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]SumResp, error) {
 	var results []SumResp
 
 	// Check the cache first
-	cacheKey := fmt.Sprintf("%s/%s/%s", timeRange.String(), channelID, counter)
-	item, ok := s.cache.Get(cacheKey)
-	if ok {
+	cacheKey := s.cache.Key("sum", timeRange, channelID, counter)
+	if item, ok := s.cache.Get(cacheKey); ok {
 		return item.([]SumResp), nil
 	}
 
-	dataPoints, err := s.GetDataPoints(timeRange, channelID, counter)
+	dataPoints, err := s.getDataPoints(ctx, timeRange, channelID, counter)
 	if err != nil {
 		return nil, err
 	}
 
-	byUser := make(map[string]int64)
-	for _, dp := range dataPoints {
-		if value, exists := byUser[dp.UserName]; exists {
-			byUser[dp.UserName] = value + dp.Value
-		} else {
-			byUser[dp.UserName] = dp.Value
-		}
-	}
-
-	for key, value := range byUser {
-		results = append(results, SumResp{User: key, Sum: value})
-	}
-
-	// Sort the results
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Sum < results[j].Sum
-	})
-
-	if err == nil && len(results) != 0 {
-		// Add to the cache with TTL
-		s.cache.AddWithTTL(cacheKey, results, s.cacheTTL)
-	}
+	results = sumByUser(dataPoints)
 
+	// Add to the cache, cached results are invalidated by writes to the channel
+	s.cache.Add(cacheKey, results)
 	return results, nil
 }
 
@@ -208,19 +326,56 @@ type PercentageResp struct {
 	Percent int64 `json:"percentage"`
 }
 
-func (s *Store) PercentageByUser(timeRange *TimeRange, channelID, counter string) ([]PercentageResp, error) {
+func (s *Store) PercentageByUser(ctx context.Context, timeRange *TimeRange, channelID, counter string) ([]PercentageResp, error) {
+	// Check the cache first
+	cacheKey := s.cache.Key("percentage", timeRange, channelID, counter)
+	if item, ok := s.cache.Get(cacheKey); ok {
+		return item.([]PercentageResp), nil
+	}
+
 	// Get the total number of messages for the channel during this time
-	messages, err := s.SumByUser(timeRange, channelID, "messages")
+	messages, err := s.SumByUser(ctx, timeRange, channelID, "messages")
 	if err != nil {
 		return nil, err
 	}
 
 	// Get the data type counts during this time
-	counters, err := s.SumByUser(timeRange, channelID, counter)
+	counters, err := s.SumByUser(ctx, timeRange, channelID, counter)
 	if err != nil {
 		return nil, err
 	}
 
+	results := percentageByUser(messages, counters)
+	s.cache.Add(cacheKey, results)
+	return results, nil
+}
+
+// Sum the data points by user name, sorted by sum
+func sumByUser(dataPoints []DataPoint) []SumResp {
+	var results []SumResp
+
+	byUser := make(map[string]int64)
+	for _, dp := range dataPoints {
+		if value, exists := byUser[dp.UserName]; exists {
+			byUser[dp.UserName] = value + dp.Value
+		} else {
+			byUser[dp.UserName] = dp.Value
+		}
+	}
+
+	for key, value := range byUser {
+		results = append(results, SumResp{User: key, Sum: value})
+	}
+
+	// Sort the results
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Sum < results[j].Sum
+	})
+	return results
+}
+
+// Calculate the percentage of messages each user sent that were counted by the counter
+func percentageByUser(messages, counters []SumResp) []PercentageResp {
 	counterMap := make(map[string]int64)
 	for _, counter := range counters {
 		counterMap[counter.User] = counter.Sum
@@ -244,17 +399,19 @@ func (s *Store) PercentageByUser(timeRange *TimeRange, channelID, counter string
 	sort.Slice(results, func(i, j int) bool {
 		return results[i].Total < results[j].Total
 	})
-
-	return results, nil
+	return results
 }
 
-func (s Store) GetByPrefix(keyPrefix []byte) ([]DataPoint, error) {
+func (s Store) GetByPrefix(ctx context.Context, keyPrefix []byte) ([]DataPoint, error) {
 	var results []DataPoint
 
 	err := s.db.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 		for it.Seek(keyPrefix); it.ValidForPrefix(keyPrefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			dp, err := DataPointFrom(it.Item())
 			if err != nil {
 				return err
@@ -269,130 +426,473 @@ func (s Store) GetByPrefix(keyPrefix []byte) ([]DataPoint, error) {
 	return results, err
 }
 
-func (s *Store) GetAll() ([]DataPoint, error) {
-	var results []DataPoint
+// Call fn() for each data point that matches the filter in key order, iteration
+// stops if fn() returns an error or the context is cancelled.
+func (s *Store) ForEach(ctx context.Context, filter DataPointFilter, fn func(DataPoint) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	// Fetch all the things from the database
-	err := s.db.View(func(txn *badger.Txn) error {
+	return s.db.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
-		for it.Rewind(); it.Valid(); it.Next() {
+		for it.Seek([]byte(filter.SeekKey())); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// Only metadata keys follow the data points
+			if strings.HasPrefix(string(it.Item().Key()), metaKeyPrefix) {
+				return nil
+			}
 			dp, err := DataPointFrom(it.Item())
 			if err != nil {
 				return err
 			}
+			if filter.Done(dp) {
+				return nil
+			}
+			if !filter.Match(dp) {
+				continue
+			}
 			if err = dp.ResolveID(s.idMgr); err != nil {
 				s.log.Debugf("while resolving data point ids for '%+v': %s", dp, err)
 			}
-			results = append(results, dp)
+			if err := fn(dp); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
-	return results, err
 }
 
 func (s *Store) Close() error {
+	if err := s.journal.Close(); err != nil {
+		s.log.Errorf("while closing journal: %s", err)
+	}
 	return s.db.Close()
 }
 
-func (s *Store) hourFromTimeStamp(text string) (string, error) {
-	float, err := strconv.ParseFloat(text, 64)
+func (s *Store) Backup(w io.Writer, since uint64) (uint64, error) {
+	s.log.Infof("Starting backup since version '%d'", since)
+	version, err := s.db.Backup(w, since)
 	if err != nil {
-		return "", errors.Wrapf(err, "timestamp conversion for '%s'", text)
+		return 0, errors.Wrap(err, "while streaming badger backup")
+	}
+	s.log.Infof("Backup complete at version '%d'", version)
+	return version, nil
+}
+
+func (s *Store) Restore(r io.Reader) error {
+	s.log.Info("Restoring from backup")
+	if err := s.db.Load(r); err != nil {
+		return errors.Wrap(err, "while loading badger backup")
+	}
+	s.cache.InvalidateAll()
+	return nil
+}
+
+func hourFromTimeStamp(text string) (string, error) {
+	timestamp, err := timeFromTimeStamp(text)
+	if err != nil {
+		return "", err
 	}
-	timestamp := time.Unix(0, int64(float*1000000)*int64(time.Microsecond/time.Nanosecond)).UTC()
 	return timestamp.Format(RFC3339Short), nil
 }
 
+// Convert a slack timestamp like '1544659200.000100' into a time
+func timeFromTimeStamp(text string) (time.Time, error) {
+	float, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "timestamp conversion for '%s'", text)
+	}
+	return time.Unix(0, int64(float*1000000)*int64(time.Microsecond/time.Nanosecond)).UTC(), nil
+}
+
 func (s *Store) HandleReactionAdded(ev *slack.ReactionAddedEvent) error {
-	hour, err := s.hourFromTimeStamp(ev.EventTimestamp)
+	_, err := s.handleReactionAdded(ev, true)
+	return err
+}
+
+func (s *Store) HandleMessage(ev *slack.MessageEvent) error {
+	_, err := s.handleMessage(ev, true)
+	return err
+}
+
+func (s *Store) HandleReactionAddedOnce(ev *slack.ReactionAddedEvent) (bool, error) {
+	return s.handleReactionAdded(ev, false)
+}
+
+func (s *Store) HandleMessageOnce(ev *slack.MessageEvent) (bool, error) {
+	return s.handleMessage(ev, false)
+}
+
+func (s *Store) handleReactionAdded(ev *slack.ReactionAddedEvent, live bool) (bool, error) {
+	dps, err := dataPointsFromReaction(ev)
 	if err != nil {
-		return errors.Wrap(err, "while handling reaction added")
+		return false, errors.Wrap(err, "while handling reaction added")
 	}
-	dp := DataPoint{
-		Hour:      hour,
-		ChannelID: ev.Item.Channel,
-		UserID:    ev.User,
-		Value:     int64(1),
+	counted, err := s.saveDataPoints(reactionEventID(ev), liveEventHour(ev.EventTimestamp, live), dps)
+	if err != nil || !counted {
+		return counted, err
+	}
+	return true, s.journal.Reaction(ev, dps)
+}
+
+func (s *Store) handleMessage(ev *slack.MessageEvent, live bool) (bool, error) {
+	dps, err := dataPointsFromMessage(ev)
+	if err != nil {
+		return false, errors.Wrap(err, "while handling message")
 	}
+	counted, err := s.saveDataPoints(messageEventID(ev), liveEventHour(ev.Timestamp, live), dps)
+	if err != nil || !counted {
+		return counted, err
+	}
+	return true, s.journal.Message(ev, dps)
+}
 
-	return s.db.Update(func(txn *badger.Txn) error {
-		dp.Counter = "emoji"
-		err := saveDataPoint(txn, dp)
-		if err != nil {
-			return errors.Wrapf(err, "while storing 'messages' data point")
+func (s *Store) AddDataPoints(dps []DataPoint) error {
+	_, err := s.saveDataPoints("", "", dps)
+	return err
+}
+
+func (s *Store) DeleteCounter(ctx context.Context, counter, startHour string) error {
+	// Collect the keys first, as a single transaction cannot delete an unlimited number of keys
+	var keys [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek([]byte(startHour)); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			key := it.Item().KeyCopy(nil)
+			if strings.HasPrefix(string(key), metaKeyPrefix) {
+				return nil
+			}
+			if parts := strings.Split(string(key), "/"); len(parts) == 4 && parts[1] == counter {
+				keys = append(keys, key)
+			}
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.deleteKeys(keys); err != nil {
+		return err
+	}
+	s.cache.InvalidateAll()
+	return nil
 }
 
-func (s *Store) HandleMessage(ev *slack.MessageEvent) error {
-	hour, err := s.hourFromTimeStamp(ev.Timestamp)
+func (s *Store) PruneEvents(ctx context.Context, before time.Time) (int, error) {
+	beforeHour := before.UTC().Format(RFC3339Short)
+
+	// Live events record the hour they occurred in, events counted via the Deduper interface record nothing
+	var keys [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(eventKeyPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			hour, err := it.Item().Value()
+			if err != nil {
+				return errors.Wrapf(err, "while fetching key '%s'", it.Item().Key())
+			}
+			if len(hour) != 0 && string(hour) < beforeHour {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "while handling message")
+		return 0, err
 	}
+	return len(keys), s.deleteKeys(keys)
+}
 
-	// Silently ignore empty messages
-	if len(ev.Text) == 0 {
+// Delete the keys in batches, as a single transaction cannot delete an unlimited number of keys
+func (s *Store) deleteKeys(keys [][]byte) error {
+	const batchSize = 1000
+	for i := 0; i < len(keys); i += batchSize {
+		end := i + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		err := s.db.Update(func(txn *badger.Txn) error {
+			for _, key := range keys[i:end] {
+				if err := txn.Delete(key); err != nil {
+					return errors.Wrapf(err, "while deleting key '%s'", key)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add the data points to the counters in a single badger transaction. If an eventID is provided the
+// data points are only added if the event has not been counted before. Live events record the hour
+// they occurred in, such that they can be pruned.
+func (s *Store) saveDataPoints(eventID, liveHour string, dps []DataPoint) (bool, error) {
+	if len(dps) == 0 && eventID == "" {
+		return false, nil
+	}
+
+	var counted bool
+	err := s.db.Update(func(txn *badger.Txn) error {
+		if eventID != "" {
+			key := []byte(eventKeyPrefix + eventID)
+			_, err := txn.Get(key)
+			if err == nil {
+				return nil
+			}
+			if err != badger.ErrKeyNotFound {
+				return errors.Wrapf(err, "while fetching key '%s'", key)
+			}
+			if err := txn.Set(key, []byte(liveHour)); err != nil {
+				return errors.Wrapf(err, "while setting event key '%s'", key)
+			}
+		}
+
+		for _, dp := range dps {
+			if err := saveDataPoint(txn, dp); err != nil {
+				return errors.Wrapf(err, "while storing '%s' data point", dp.Counter)
+			}
+		}
+		counted = true
 		return nil
+	})
+	if err != nil {
+		return false, err
 	}
 
-	dp := DataPoint{
-		Hour:      hour,
-		ChannelID: ev.Channel,
-		UserID:    ev.User,
-		Value:     int64(1),
+	// Now the write is committed, invalidate any cached queries which include it
+	if counted {
+		for _, dp := range dps {
+			s.cache.Invalidate(dp.ChannelID, dp.Hour)
+		}
+	}
+	return counted, nil
+}
+
+func (s *Store) SaveReport(report ArchivedReport) error {
+	summary, content := report.split()
+	summaryJSON, err := json.Marshal(summary)
+	if err != nil {
+		return errors.Wrap(err, "while marshalling report")
+	}
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return errors.Wrap(err, "while marshalling report content")
 	}
 
-	// Start a badger transaction
 	return s.db.Update(func(txn *badger.Txn) error {
-		// Count Messages
-		dp.Counter = "messages"
-		err := saveDataPoint(txn, dp)
-		if err != nil {
-			return errors.Wrapf(err, "while storing 'messages' data point")
+		if err := txn.Set([]byte(reportKeyPrefix+report.ID), summaryJSON); err != nil {
+			return errors.Wrapf(err, "while saving report '%s'", report.ID)
 		}
+		if err := txn.Set([]byte(reportContentKeyPrefix+report.ID), contentJSON); err != nil {
+			return errors.Wrapf(err, "while saving report content '%s'", report.ID)
+		}
+		return nil
+	})
+}
 
-		result := SentimentAnalysis(ev.Text)
-		if result.Score > 0 {
-			dp.Counter = "positive"
-			err = saveDataPoint(txn, dp)
+func (s *Store) ListReports(ctx context.Context, channelID string) ([]ArchivedReport, error) {
+	var results []ArchivedReport
+	prefix := []byte(reportKeyPrefix)
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			value, err := it.Item().Value()
 			if err != nil {
-				return errors.Wrapf(err, "while storing 'positive' data point")
+				return errors.Wrapf(err, "while fetching report '%s'", it.Item().Key())
 			}
-		}
-		if result.Score < 0 {
-			dp.Counter = "negative"
-			if err = saveDataPoint(txn, dp); err != nil {
-				return errors.Wrapf(err, "while storing 'negative' data point")
+			var report ArchivedReport
+			if err := json.Unmarshal(value, &report); err != nil {
+				return errors.Wrapf(err, "while unmarshalling report '%s'", it.Item().Key())
 			}
+			if channelID != "" && report.ChannelID != channelID {
+				continue
+			}
+			results = append(results, report)
 		}
+		return nil
+	})
+	return results, err
+}
 
-		// Link counter
-		if HasLink(ev.Text) {
-			dp.Counter = "link"
-			if err = saveDataPoint(txn, dp); err != nil {
-				return errors.Wrapf(err, "while storing 'link' data point")
+func (s *Store) GetReport(ctx context.Context, id string) (ArchivedReport, error) {
+	var report ArchivedReport
+	var content archivedContent
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		for key, dest := range map[string]interface{}{
+			reportKeyPrefix + id:        &report,
+			reportContentKeyPrefix + id: &content,
+		} {
+			item, err := txn.Get([]byte(key))
+			if err == badger.ErrKeyNotFound {
+				return ErrReportNotFound
+			}
+			if err != nil {
+				return errors.Wrapf(err, "while fetching key '%s'", key)
+			}
+			value, err := item.Value()
+			if err != nil {
+				return errors.Wrapf(err, "while fetching key '%s'", key)
+			}
+			if err := json.Unmarshal(value, dest); err != nil {
+				return errors.Wrapf(err, "while unmarshalling key '%s'", key)
 			}
 		}
+		return nil
+	})
+	report.Html, report.Images = content.Html, content.Images
+	return report, err
+}
 
-		// Emoji counter
-		if HasEmoji(ev.Text) {
-			dp.Counter = "emoji"
-			if err = saveDataPoint(txn, dp); err != nil {
-				return errors.Wrapf(err, "while storing 'emoji' data point")
+func (s *Store) DeleteReports(ctx context.Context, before time.Time) (int, error) {
+	var ids []string
+	prefix := []byte(reportKeyPrefix)
+
+	// Report ids sort by creation time, so stop at the first report created after the time
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
 			}
+			id := strings.TrimPrefix(string(it.Item().Key()), reportKeyPrefix)
+			if !reportCreatedBefore(id, before) {
+				return nil
+			}
+			ids = append(ids, id)
 		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
 
-		// Count words
-		count := CountWords(ev.Text)
-		dp.Counter = "word-count"
-		dp.Value = count
-		if err = saveDataPoint(txn, dp); err != nil {
-			return errors.Wrapf(err, "while storing 'word-count' data point")
+	err = s.db.Update(func(txn *badger.Txn) error {
+		for _, id := range ids {
+			for _, key := range []string{reportKeyPrefix + id, reportContentKeyPrefix + id} {
+				if err := txn.Delete([]byte(key)); err != nil {
+					return errors.Wrapf(err, "while deleting key '%s'", key)
+				}
+			}
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// Returns the hour the event occurred in if it was counted live, events counted via
+// the Deduper interface return an empty hour as they are never pruned
+func liveEventHour(ts string, live bool) string {
+	if !live {
+		return ""
+	}
+	// The timestamp was validated when creating the data points
+	hour, _ := hourFromTimeStamp(ts)
+	return hour
+}
+
+// Returns an id which uniquely identifies the message within the workspace
+func messageEventID(ev *slack.MessageEvent) string {
+	return fmt.Sprintf("message/%s/%s", ev.Channel, ev.Timestamp)
+}
+
+// Returns an id which uniquely identifies the reaction within the workspace
+func reactionEventID(ev *slack.ReactionAddedEvent) string {
+	return fmt.Sprintf("reaction/%s/%s/%s/%s", ev.Item.Channel, ev.Item.Timestamp, ev.User, ev.Reaction)
+}
+
+// Returns the data points a reaction adds to the counters
+func dataPointsFromReaction(ev *slack.ReactionAddedEvent) ([]DataPoint, error) {
+	hour, err := hourFromTimeStamp(ev.EventTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	return []DataPoint{
+		{
+			Hour:      hour,
+			Counter:   "emoji",
+			ChannelID: ev.Item.Channel,
+			UserID:    ev.User,
+			Value:     int64(1),
+		},
+	}, nil
+}
+
+// Returns the data points a message adds to the counters, every Storer
+// implementation should count messages using this function
+func dataPointsFromMessage(ev *slack.MessageEvent) ([]DataPoint, error) {
+	hour, err := hourFromTimeStamp(ev.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Silently ignore empty messages
+	if len(ev.Text) == 0 {
+		return nil, nil
+	}
+
+	dp := DataPoint{
+		Hour:      hour,
+		ChannelID: ev.Channel,
+		UserID:    ev.User,
+		Value:     int64(1),
+	}
+	var results []DataPoint
+
+	add := func(counter string, value int64) {
+		dp.Counter = counter
+		dp.Value = value
+		results = append(results, dp)
+	}
+
+	// Count Messages
+	add("messages", 1)
+
+	result := SentimentAnalysis(ev.Text)
+	if result.Score > 0 {
+		add("positive", 1)
+	}
+	if result.Score < 0 {
+		add("negative", 1)
+	}
+
+	// Link counter
+	if HasLink(ev.Text) {
+		add("link", 1)
+	}
+
+	// Emoji counter
+	if HasEmoji(ev.Text) {
+		add("emoji", 1)
+	}
+
+	// Count words
+	add("word-count", CountWords(ev.Text))
+	return results, nil
 }
 
 func SentimentAnalysis(message string) (score hc.FullScore) {
@@ -466,9 +966,18 @@ func saveDataPoint(txn *badger.Txn, dp DataPoint) error {
 // Suitable for testing
 type NullStore struct{}
 
-func (n *NullStore) GetDataPoints(*TimeRange, string, string) ([]DataPoint, error) {
+func (n *NullStore) GetDataPoints(context.Context, *TimeRange, string, string) ([]DataPoint, error) {
 	return []DataPoint{}, nil
 }
-func (n *NullStore) SumByUser(*TimeRange, string, string) ([]SumResp, error) { return []SumResp{}, nil }
-func (n *NullStore) GetAll() ([]DataPoint, error)                            { return []DataPoint{}, nil }
-func (n *NullStore) Close() error                                            { return nil }
+func (n *NullStore) PercentageByUser(context.Context, *TimeRange, string, string) ([]PercentageResp, error) {
+	return []PercentageResp{}, nil
+}
+func (n *NullStore) SumByUser(context.Context, *TimeRange, string, string) ([]SumResp, error) {
+	return []SumResp{}, nil
+}
+func (n *NullStore) HandleReactionAdded(*slack.ReactionAddedEvent) error { return nil }
+func (n *NullStore) HandleMessage(*slack.MessageEvent) error             { return nil }
+func (n *NullStore) Close() error                                        { return nil }
+func (n *NullStore) ForEach(context.Context, DataPointFilter, func(DataPoint) error) error {
+	return nil
+}
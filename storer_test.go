@@ -0,0 +1,547 @@
+package channelstats_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/suite"
+	"github.com/thrawn01/channel-stats"
+)
+
+// Every Storer implementation must pass this suite
+func TestStorers(t *testing.T) {
+	for _, backend := range []string{"badger", "sqlite", "memory"} {
+		t.Run(backend, func(t *testing.T) {
+			suite.Run(t, &StorerSuite{backend: backend})
+		})
+	}
+}
+
+type StorerSuite struct {
+	suite.Suite
+	backend string
+	dir     string
+	store   channelstats.Storer
+	seq     int64
+}
+
+const (
+	// 2018-12-13T00
+	hourZero = "1544659200.000100"
+	// 2018-12-13T01
+	hourOne = "1544662800.000200"
+	// 2018-12-14T00
+	nextDay = "1544745600.000300"
+)
+
+func (s *StorerSuite) SetupSuite() {
+	channelstats.InitLogging(channelstats.Config{})
+}
+
+func (s *StorerSuite) SetupTest() {
+	var err error
+	s.dir, err = ioutil.TempDir("", "channel-stats-test")
+	s.Require().NoError(err)
+
+	var conf channelstats.Config
+	conf.Store.Backend = s.backend
+	conf.Store.DataDir = s.dir
+	conf.Store.DSN = filepath.Join(s.dir, "channel-stats.db")
+	conf.Store.CacheSize = 100
+
+	s.store, err = channelstats.NewStore(conf, &channelstats.MockIDManage{
+		UserByID: map[string]string{
+			"U1": "joe",
+			"U2": "scott",
+		},
+	})
+	s.Require().NoError(err)
+}
+
+func (s *StorerSuite) TearDownTest() {
+	s.NoError(s.store.Close())
+	os.RemoveAll(s.dir)
+}
+
+// Returns a unique slack timestamp in the same second as ts, as the timestamp identifies the message
+func (s *StorerSuite) unique(ts string) string {
+	return fmt.Sprintf("%s.%06d", strings.Split(ts, ".")[0], atomic.AddInt64(&s.seq, 1))
+}
+
+func (s *StorerSuite) message(ts, user, text string) {
+	s.Require().NoError(s.store.HandleMessage(&slack.MessageEvent{
+		Msg: slack.Msg{
+			Timestamp: s.unique(ts),
+			Channel:   "C1",
+			User:      user,
+			Text:      text,
+		},
+	}))
+}
+
+func (s *StorerSuite) timeRange(start, end string) *channelstats.TimeRange {
+	timeRange, err := channelstats.NewTimeRange(start, end)
+	s.Require().NoError(err)
+	return timeRange
+}
+
+func (s *StorerSuite) values(timeRange *channelstats.TimeRange, counter string) []string {
+	dps, err := s.store.GetDataPoints(context.Background(), timeRange, "C1", counter)
+	s.Require().NoError(err)
+
+	var results []string
+	for _, dp := range dps {
+		results = append(results, fmt.Sprintf("%s/%s/%s/%d", dp.Hour, dp.Counter, dp.UserName, dp.Value))
+	}
+	sort.Strings(results)
+	return results
+}
+
+// Returns the keys and values of every data point matching the filter in the order visited
+func (s *StorerSuite) forEach(filter channelstats.DataPointFilter) []string {
+	var results []string
+	err := s.store.ForEach(context.Background(), filter, func(dp channelstats.DataPoint) error {
+		results = append(results, fmt.Sprintf("%s/%d", dp.Key(), dp.Value))
+		return nil
+	})
+	s.Require().NoError(err)
+	return results
+}
+
+func (s *StorerSuite) TestHandleMessage() {
+	s.message(hourZero, "U1", "I love this great link http://google.com :smile:")
+
+	timeRange := s.timeRange("2018-12-13T00", "2018-12-13T01")
+	s.Equal([]string{"2018-12-13T00/messages/joe/1"}, s.values(timeRange, "messages"))
+	s.Equal([]string{"2018-12-13T00/positive/joe/1"}, s.values(timeRange, "positive"))
+	s.Equal([]string{"2018-12-13T00/link/joe/1"}, s.values(timeRange, "link"))
+	s.Equal([]string{"2018-12-13T00/emoji/joe/1"}, s.values(timeRange, "emoji"))
+	s.Equal([]string{"2018-12-13T00/word-count/joe/7"}, s.values(timeRange, "word-count"))
+	s.Nil(s.values(timeRange, "negative"))
+}
+
+func (s *StorerSuite) TestHandleMessageCountsOnce() {
+	// Slack may deliver the same event more than once, such as after a reconnect
+	ev := &slack.MessageEvent{Msg: slack.Msg{Timestamp: hourZero, Channel: "C1", User: "U1", Text: "hello"}}
+	s.Require().NoError(s.store.HandleMessage(ev))
+	s.Require().NoError(s.store.HandleMessage(ev))
+
+	reaction := &slack.ReactionAddedEvent{User: "U2", Reaction: "smile", EventTimestamp: hourZero}
+	reaction.Item.Channel = "C1"
+	reaction.Item.Timestamp = hourZero
+	s.Require().NoError(s.store.HandleReactionAdded(reaction))
+	s.Require().NoError(s.store.HandleReactionAdded(reaction))
+
+	timeRange := s.timeRange("2018-12-13T00", "2018-12-13T01")
+	s.Equal([]string{"2018-12-13T00/messages/joe/1"}, s.values(timeRange, "messages"))
+	s.Equal([]string{"2018-12-13T00/emoji/scott/1"}, s.values(timeRange, "emoji"))
+}
+
+func (s *StorerSuite) TestHandleMessageIgnoresEmpty() {
+	s.message(hourZero, "U1", "")
+
+	s.Len(s.forEach(channelstats.DataPointFilter{}), 0)
+}
+
+func (s *StorerSuite) TestHandleMessageInvalidTimestamp() {
+	err := s.store.HandleMessage(&slack.MessageEvent{
+		Msg: slack.Msg{Timestamp: "not-a-timestamp", Channel: "C1", User: "U1", Text: "hello"},
+	})
+	s.Error(err)
+}
+
+func (s *StorerSuite) TestHandleReactionAdded() {
+	ev := &slack.ReactionAddedEvent{User: "U2", EventTimestamp: hourOne}
+	ev.Item.Channel = "C1"
+	s.Require().NoError(s.store.HandleReactionAdded(ev))
+
+	timeRange := s.timeRange("2018-12-13T00", "2018-12-13T01")
+	s.Equal([]string{"2018-12-13T01/emoji/scott/1"}, s.values(timeRange, "emoji"))
+	s.Nil(s.values(timeRange, "messages"))
+}
+
+func (s *StorerSuite) TestHandleOnce() {
+	deduper, ok := s.store.(channelstats.Deduper)
+	s.Require().True(ok, "store must implement Deduper")
+
+	msg := &slack.MessageEvent{Msg: slack.Msg{Timestamp: hourZero, Channel: "C1", User: "U1", Text: "hello"}}
+	reaction := &slack.ReactionAddedEvent{User: "U2", Reaction: "smile", EventTimestamp: hourZero}
+	reaction.Item.Channel = "C1"
+	reaction.Item.Timestamp = hourZero
+
+	for i, expected := range []bool{true, false} {
+		counted, err := deduper.HandleMessageOnce(msg)
+		s.Require().NoError(err)
+		s.Equal(expected, counted, "message attempt %d", i)
+
+		counted, err = deduper.HandleReactionAddedOnce(reaction)
+		s.Require().NoError(err)
+		s.Equal(expected, counted, "reaction attempt %d", i)
+	}
+
+	// A different reaction to the same message is a different event
+	reaction.Reaction = "frown"
+	counted, err := deduper.HandleReactionAddedOnce(reaction)
+	s.Require().NoError(err)
+	s.True(counted)
+
+	timeRange := s.timeRange("2018-12-13T00", "2018-12-13T01")
+	s.Equal([]string{"2018-12-13T00/messages/joe/1"}, s.values(timeRange, "messages"))
+	s.Equal([]string{"2018-12-13T00/emoji/scott/2"}, s.values(timeRange, "emoji"))
+
+	// Events recorded for de-duplication are not data points
+	s.Len(s.forEach(channelstats.DataPointFilter{}), 3)
+}
+
+func (s *StorerSuite) TestPruneEvents() {
+	deduper := s.store.(channelstats.Deduper)
+	pruner, ok := s.store.(channelstats.EventPruner)
+	s.Require().True(ok, "store must implement EventPruner")
+
+	old := &slack.MessageEvent{Msg: slack.Msg{Timestamp: hourZero, Channel: "C1", User: "U1", Text: "hello"}}
+	reaction := &slack.ReactionAddedEvent{User: "U2", Reaction: "smile", EventTimestamp: hourOne}
+	reaction.Item.Channel = "C1"
+	reaction.Item.Timestamp = hourZero
+	imported := &slack.MessageEvent{Msg: slack.Msg{Timestamp: hourOne, Channel: "C1", User: "U2", Text: "hello"}}
+	recent := &slack.MessageEvent{Msg: slack.Msg{Timestamp: nextDay, Channel: "C1", User: "U1", Text: "hello"}}
+
+	s.Require().NoError(s.store.HandleMessage(old))
+	s.Require().NoError(s.store.HandleReactionAdded(reaction))
+	s.Require().NoError(s.store.HandleMessage(recent))
+	counted, err := deduper.HandleMessageOnce(imported)
+	s.Require().NoError(err)
+	s.True(counted)
+
+	pruned, err := pruner.PruneEvents(context.Background(), time.Date(2018, 12, 14, 0, 0, 0, 0, time.UTC))
+	s.Require().NoError(err)
+	s.Equal(2, pruned)
+
+	// Only the live events before the hour are forgotten, imported events are recorded forever
+	for _, expected := range []struct {
+		ev      *slack.MessageEvent
+		counted bool
+	}{{old, true}, {imported, false}, {recent, false}} {
+		counted, err := deduper.HandleMessageOnce(expected.ev)
+		s.Require().NoError(err)
+		s.Equal(expected.counted, counted, expected.ev.Timestamp)
+	}
+	counted, err = deduper.HandleReactionAddedOnce(reaction)
+	s.Require().NoError(err)
+	s.True(counted)
+
+	// Pruning again finds nothing, as the events are now recorded as imported
+	pruned, err = pruner.PruneEvents(context.Background(), time.Date(2018, 12, 14, 0, 0, 0, 0, time.UTC))
+	s.Require().NoError(err)
+	s.Equal(0, pruned)
+}
+
+func (s *StorerSuite) TestDeleteCounter() {
+	reindexer, ok := s.store.(channelstats.Reindexer)
+	s.Require().True(ok, "store must implement Reindexer")
+
+	s.message(hourZero, "U1", "http://google.com")
+	s.message(hourOne, "U1", "http://google.com")
+	s.message(nextDay, "U2", "http://google.com")
+
+	timeRange := s.timeRange("2018-12-13T00", "2018-12-14T23")
+	s.Len(s.values(timeRange, "link"), 3)
+
+	s.Require().NoError(reindexer.DeleteCounter(context.Background(), "link", "2018-12-13T01"))
+	s.Equal([]string{"2018-12-13T00/link/joe/1"}, s.values(timeRange, "link"))
+	s.Len(s.values(timeRange, "messages"), 3)
+
+	s.Require().NoError(reindexer.AddDataPoints([]channelstats.DataPoint{
+		{Hour: "2018-12-13T01", Counter: "link", ChannelID: "C1", UserID: "U1", Value: 2},
+	}))
+	s.Equal([]string{"2018-12-13T00/link/joe/1", "2018-12-13T01/link/joe/2"}, s.values(timeRange, "link"))
+}
+
+func (s *StorerSuite) TestGetDataPointsTimeRange() {
+	s.message(hourZero, "U1", "first")
+	s.message(hourOne, "U1", "second")
+	s.message(hourOne, "U1", "third")
+	s.message(nextDay, "U1", "fourth")
+
+	s.Equal([]string{"2018-12-13T00/messages/joe/1"},
+		s.values(s.timeRange("2018-12-13T00", "2018-12-13T00"), "messages"))
+	s.Equal([]string{"2018-12-13T00/messages/joe/1", "2018-12-13T01/messages/joe/2"},
+		s.values(s.timeRange("2018-12-13T00", "2018-12-13T23"), "messages"))
+}
+
+func (s *StorerSuite) TestSumByUser() {
+	s.message(hourZero, "U1", "one")
+	s.message(hourOne, "U1", "two")
+	s.message(hourOne, "U1", "three")
+	s.message(hourOne, "U2", "four")
+	s.message(nextDay, "U2", "out of range")
+
+	sums, err := s.store.SumByUser(context.Background(), s.timeRange("2018-12-13T00", "2018-12-13T23"), "C1", "messages")
+	s.Require().NoError(err)
+	s.Equal([]channelstats.SumResp{{User: "scott", Sum: 1}, {User: "joe", Sum: 3}}, sums)
+}
+
+func (s *StorerSuite) TestQueriesSeeNewWrites() {
+	timeRange := s.timeRange("2018-12-13T00", "2018-12-13T23")
+	s.message(hourZero, "U1", "one")
+
+	for i := int64(1); i < 3; i++ {
+		sums, err := s.store.SumByUser(context.Background(), timeRange, "C1", "messages")
+		s.Require().NoError(err)
+		s.Equal([]channelstats.SumResp{{User: "joe", Sum: i}}, sums)
+
+		results, err := s.store.PercentageByUser(context.Background(), timeRange, "C1", "messages")
+		s.Require().NoError(err)
+		s.Equal([]channelstats.PercentageResp{{User: "joe", Total: i, Count: i, Percent: 100}}, results)
+
+		s.Len(s.values(timeRange, "messages"), int(i))
+		s.message(hourOne, "U1", "another")
+	}
+}
+
+func (s *StorerSuite) TestPercentageByUser() {
+	s.message(hourZero, "U1", "this is terrible")
+	s.message(hourZero, "U1", "just a message")
+	s.message(hourZero, "U1", "another message")
+	s.message(hourZero, "U1", "and one more")
+	s.message(hourOne, "U2", "I hate this")
+	s.message(hourOne, "U2", "an awful day")
+
+	results, err := s.store.PercentageByUser(context.Background(), s.timeRange("2018-12-13T00", "2018-12-13T23"), "C1", "negative")
+	s.Require().NoError(err)
+	s.Equal([]channelstats.PercentageResp{
+		{User: "scott", Total: 2, Count: 2, Percent: 100},
+		{User: "joe", Total: 4, Count: 1, Percent: 25},
+	}, results)
+}
+
+func (s *StorerSuite) TestForEach() {
+	s.message(hourZero, "U1", "hello")
+	s.message(nextDay, "U2", "hello there")
+
+	s.Equal([]string{
+		"2018-12-13T00/messages/C1/U1/1",
+		"2018-12-13T00/word-count/C1/U1/1",
+		"2018-12-14T00/messages/C1/U2/1",
+		"2018-12-14T00/word-count/C1/U2/2",
+	}, s.forEach(channelstats.DataPointFilter{}))
+}
+
+func (s *StorerSuite) TestForEachFilters() {
+	s.message(hourZero, "U1", "hello")
+	s.message(hourOne, "U2", "hello there")
+	s.message(nextDay, "U2", "hello there")
+	s.Require().NoError(s.store.HandleMessage(&slack.MessageEvent{
+		Msg: slack.Msg{Timestamp: hourZero, Channel: "C2", User: "U1", Text: "hello"},
+	}))
+
+	s.Equal([]string{
+		"2018-12-13T00/messages/C1/U1/1",
+		"2018-12-13T01/messages/C1/U2/1",
+		"2018-12-14T00/messages/C1/U2/1",
+	}, s.forEach(channelstats.DataPointFilter{ChannelID: "C1", Counter: "messages"}))
+
+	s.Equal([]string{
+		"2018-12-13T01/messages/C1/U2/1",
+		"2018-12-13T01/word-count/C1/U2/2",
+	}, s.forEach(channelstats.DataPointFilter{StartHour: "2018-12-13T01", EndHour: "2018-12-13T23"}))
+}
+
+func (s *StorerSuite) TestForEachPagination() {
+	s.message(hourZero, "U1", "hello")
+	s.message(hourZero, "U2", "hello")
+	s.message(nextDay, "U2", "hello there")
+
+	all := s.forEach(channelstats.DataPointFilter{})
+	s.Require().Len(all, 6)
+
+	// Fetch two data points at a time using the key of the last as the cursor
+	var pages []string
+	var cursor string
+	for {
+		var page []channelstats.DataPoint
+		err := s.store.ForEach(context.Background(), channelstats.DataPointFilter{After: cursor},
+			func(dp channelstats.DataPoint) error {
+				if len(page) == 2 {
+					return errors.New("limit")
+				}
+				page = append(page, dp)
+				return nil
+			})
+		if len(page) == 0 {
+			s.Require().NoError(err)
+			break
+		}
+		for _, dp := range page {
+			pages = append(pages, fmt.Sprintf("%s/%d", dp.Key(), dp.Value))
+		}
+		cursor = string(page[len(page)-1].Key())
+	}
+	s.Equal(all, pages)
+}
+
+func (s *StorerSuite) TestWriteDuringForEach() {
+	// More data points than the SQL store reads at a time
+	var dps []channelstats.DataPoint
+	for i := 0; i < 2500; i++ {
+		dps = append(dps, channelstats.DataPoint{Hour: "2018-12-13T00", Counter: "messages",
+			ChannelID: "C1", UserID: fmt.Sprintf("U%04d", i), Value: 1})
+	}
+	s.Require().NoError(s.store.(channelstats.Reindexer).AddDataPoints(dps))
+
+	// A slow export must not block writes
+	seen := make(map[string]bool)
+	err := s.store.ForEach(context.Background(), channelstats.DataPointFilter{}, func(dp channelstats.DataPoint) error {
+		s.False(seen[string(dp.Key())], "visited '%s' twice", dp.Key())
+		seen[string(dp.Key())] = true
+		if len(seen) != 1 {
+			return nil
+		}
+
+		written := make(chan error, 1)
+		go func() {
+			written <- s.store.HandleMessage(&slack.MessageEvent{
+				Msg: slack.Msg{Timestamp: s.unique(nextDay), Channel: "C1", User: "U1", Text: "hello"},
+			})
+		}()
+		select {
+		case err := <-written:
+			return err
+		case <-time.After(time.Second * 5):
+			return errors.New("timed out waiting for write")
+		}
+	})
+	s.Require().NoError(err)
+
+	// Data points written during the export may or may not be visited, but none are lost
+	for _, dp := range dps {
+		s.True(seen[string(dp.Key())], "'%s' was not visited", dp.Key())
+	}
+}
+
+func (s *StorerSuite) TestCancelledContext() {
+	s.message(hourZero, "U1", "hello")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	timeRange := s.timeRange("2018-12-13T00", "2018-12-13T23")
+	_, err := s.store.GetDataPoints(ctx, timeRange, "C1", "messages")
+	s.Error(err)
+	_, err = s.store.SumByUser(ctx, timeRange, "C1", "messages")
+	s.Error(err)
+	_, err = s.store.PercentageByUser(ctx, timeRange, "C1", "messages")
+	s.Error(err)
+	err = s.store.ForEach(ctx, channelstats.DataPointFilter{}, func(channelstats.DataPoint) error {
+		return nil
+	})
+	s.Error(err)
+}
+
+func (s *StorerSuite) TestConcurrentAccess() {
+	var wg sync.WaitGroup
+	for _, user := range []string{"U1", "U2"} {
+		wg.Add(2)
+		go func(user string) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				s.NoError(s.store.HandleMessage(&slack.MessageEvent{
+					Msg: slack.Msg{Timestamp: s.unique(hourZero), Channel: "C1", User: user, Text: "hello"},
+				}))
+			}
+		}(user)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				s.NoError(s.store.ForEach(context.Background(), channelstats.DataPointFilter{},
+					func(channelstats.DataPoint) error { return nil }))
+			}
+		}()
+	}
+	wg.Wait()
+
+	sums, err := s.store.SumByUser(context.Background(), s.timeRange("2018-12-13T00", "2018-12-13T23"), "C1", "messages")
+	s.Require().NoError(err)
+	s.Len(sums, 2)
+	for _, sum := range sums {
+		s.Equal(int64(25), sum.Sum)
+	}
+}
+
+func (s *StorerSuite) TestReportArchive() {
+	archiver, ok := s.store.(channelstats.ReportArchiver)
+	s.Require().True(ok)
+
+	report := func(channelID string, created time.Time) channelstats.ArchivedReport {
+		return channelstats.NewArchivedReport(channelstats.ChannelReport{
+			Channel:   channelstats.SlackChannelInfo{Name: "general", Id: channelID},
+			TimeRange: s.timeRange("2018-12-06T00", "2018-12-13T00"),
+			Model: channelstats.ReportModel{
+				Totals: map[string]channelstats.CounterTotal{"messages": {Value: 42, Previous: 21, Change: 21}},
+			},
+			Data: channelstats.ReportData{
+				Html:   []byte(`<img src="cid:most-active.png"/>`),
+				Images: map[string][]byte{"most-active.png": []byte("PNG")},
+			},
+		}, created)
+	}
+
+	lastWeek := time.Date(2018, 12, 6, 0, 0, 0, 0, time.UTC)
+	thisWeek := time.Date(2018, 12, 13, 0, 0, 0, 0, time.UTC)
+	s.Require().NoError(archiver.SaveReport(report("C1", thisWeek)))
+	s.Require().NoError(archiver.SaveReport(report("C1", lastWeek)))
+	s.Require().NoError(archiver.SaveReport(report("C2", thisWeek)))
+	// Reports for the same channel created in the same second do not collide
+	s.Require().NoError(archiver.SaveReport(report("C2", thisWeek)))
+
+	// Reports are listed oldest first without the rendered report
+	reports, err := archiver.ListReports(context.Background(), "C1")
+	s.Require().NoError(err)
+	s.Require().Len(reports, 2)
+	s.Regexp(`^20181206T000000Z-C1-[0-9a-f]{8}$`, reports[0].ID)
+	s.Regexp(`^20181213T000000Z-C1-[0-9a-f]{8}$`, reports[1].ID)
+	lastWeekID := reports[0].ID
+	s.Equal("2018-12-06T00", reports[1].StartHour)
+	s.Equal(int64(42), reports[1].Model.Totals["messages"].Value)
+	s.Nil(reports[1].Html)
+	s.Nil(reports[1].Images)
+
+	reports, err = archiver.ListReports(context.Background(), "C2")
+	s.Require().NoError(err)
+	s.Require().Len(reports, 2)
+	s.NotEqual(reports[0].ID, reports[1].ID)
+
+	archived, err := archiver.GetReport(context.Background(), reports[0].ID)
+	s.Require().NoError(err)
+	s.Equal("C2", archived.ChannelID)
+	s.Equal(thisWeek, archived.Created.UTC())
+	s.Equal(`<img src="cid:most-active.png"/>`, string(archived.Html))
+	s.Equal([]byte("PNG"), archived.Images["most-active.png"])
+
+	_, err = archiver.GetReport(context.Background(), "unknown")
+	s.Equal(channelstats.ErrReportNotFound, err)
+
+	// Only the reports created before the time are deleted
+	deleted, err := archiver.DeleteReports(context.Background(), thisWeek)
+	s.Require().NoError(err)
+	s.Equal(1, deleted)
+
+	reports, err = archiver.ListReports(context.Background(), "")
+	s.Require().NoError(err)
+	s.Require().Len(reports, 3)
+	for _, report := range reports {
+		s.Equal(thisWeek, report.Created.UTC())
+	}
+	_, err = archiver.GetReport(context.Background(), lastWeekID)
+	s.Equal(channelstats.ErrReportNotFound, err)
+}